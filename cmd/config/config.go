@@ -2,6 +2,8 @@ package config
 
 import (
 	"strings"
+	"testing"
+	"time"
 	"woody-wood-portail/cmd/logger"
 
 	"github.com/go-playground/validator/v10"
@@ -18,16 +20,104 @@ type config struct {
 		Port    string
 		BaseURL string `mapstructure:"base_url"`
 		JWT     struct {
-			Secret string `validate:"required"`
+			// Secret signing the tokens issued before the keyring was introduced, they are only verified until the
+			// longest token lifetime has passed since the first key of the keyring. Empty to refuse them.
+			Secret string
+			// Comma separated base64 encoded 32 bytes keys encrypting the signing keys stored in the database. The first
+			// key encrypts the signing keys, the others are only kept to decrypt them until they are re-encrypted.
+			EncryptionKeys string `mapstructure:"encryption_keys" validate:"required"`
+			// Algorithm of the new signing keys, HS256 or EdDSA (Ed25519)
+			Algorithm string `validate:"oneof=HS256 EdDSA"`
+			// Age after which the signing key is replaced, 0 to never rotate keys
+			RotationInterval time.Duration `mapstructure:"rotation_interval"`
 			// MaxAge of the JWT token in days
 			MaxAge int `mapstructure:"max_age"`
+			// Validity of the links sent by email to verify an email address
+			EmailVerificationTTL time.Duration `mapstructure:"email_verification_ttl"`
+			// Validity of the links sent by email to reset a password
+			ResetPasswordTTL time.Duration `mapstructure:"reset_password_ttl"`
+			// Validity of the login links sent by email
+			MagicLinkTTL time.Duration `mapstructure:"magic_link_ttl"`
+			// Validity of the links sent by email to confirm a new email address
+			EmailChangeTTL time.Duration `mapstructure:"email_change_ttl"`
 		}
+		WebAuthn struct {
+			// Timeout of passkey registration and login ceremonies
+			Timeout time.Duration
+		}
+		OIDC struct {
+			// Issuer URL of the OpenID Connect provider, the OIDC login is disabled if empty
+			Issuer       string
+			ClientID     string `mapstructure:"client_id"`
+			ClientSecret string `mapstructure:"client_secret"`
+			// Name of the provider displayed on the login button
+			Name string
+			// Time allowed to complete the login or the registration with the provider
+			Timeout time.Duration
+		}
+		// Comma separated IP addresses or CIDR ranges allowed to access the administration, any address if empty
+		AdminAllowedIPs string `mapstructure:"admin_allowed_ips"`
 	}
 
 	Users struct {
 		RenewalInterval        string `mapstructure:"renewal_interval"`
 		ReminderDays           string `mapstructure:"reminder_days"`
 		AddressProofsDirectory string `mapstructure:"address_proof_directory"`
+		// Comma separated base64 encoded 32 bytes keys encrypting the address proofs. The first key encrypts the
+		// proofs, the others are only kept to decrypt the proofs until they are re-encrypted with the first one.
+		AddressProofKeys string `mapstructure:"address_proof_keys" validate:"required"`
+		// Duration after which the address proofs are deleted whatever the registration state, 0 to keep them
+		AddressProofRetention time.Duration `mapstructure:"address_proof_retention"`
+		// Maximum size of each address proof document, in bytes
+		AddressProofMaxSize int64 `mapstructure:"address_proof_max_size" validate:"min=1"`
+		// Maximum number of address proof documents of a registration
+		AddressProofMaxFiles int `mapstructure:"address_proof_max_files" validate:"min=1"`
+		// Text written over the address proofs sent to the administrators, empty to send the documents as uploaded
+		AddressProofWatermark string `mapstructure:"address_proof_watermark"`
+		// Require users with administration permissions to enable two-factor authentication
+		RequireAdminTOTP bool `mapstructure:"require_admin_totp"`
+		// Require a new address proof reviewed by an administrator to renew a registration, instead of the invitation code only
+		RenewalReview bool `mapstructure:"renewal_review"`
+		// Maximum duration of an administrator viewing the application as another user
+		ImpersonationDuration time.Duration `mapstructure:"impersonation_duration"`
+
+		Login struct {
+			// Number of consecutive failed logins before locking the account
+			MaxFailures int `mapstructure:"max_failures"`
+			// Duration of the first lockout, doubled on each following lockout
+			LockDuration time.Duration `mapstructure:"lock_duration"`
+			// Number of failed logins allowed from a single IP address during IPWindow
+			MaxIPFailures int           `mapstructure:"max_ip_failures"`
+			IPWindow      time.Duration `mapstructure:"ip_window"`
+			// Minimum delay between two password reset emails sent to the same account
+			ResetMailInterval time.Duration `mapstructure:"reset_mail_interval"`
+			// Number of password reset requests allowed from a single IP address during IPWindow
+			MaxIPResetMails int `mapstructure:"max_ip_reset_mails"`
+		}
+
+		Invitation struct {
+			// Age after which the invitation codes marked to rotate are replaced, 0 to never rotate them
+			RotationInterval time.Duration `mapstructure:"rotation_interval"`
+			// Name of the building printed on the invitation posters
+			BuildingName string `mapstructure:"building_name"`
+			// Allow registering without invitation code while no code is active, a wrong code is always refused
+			Optional bool
+		}
+
+		// Argon2id parameters of new password hashes, existing hashes are upgraded on the next successful login
+		Password struct {
+			Iterations int `validate:"min=1"`
+			// Memory in KiB
+			Memory      int `validate:"min=1024"`
+			Parallelism int `validate:"min=1,max=255"`
+
+			MinLength int `mapstructure:"min_length" validate:"min=1"`
+			// Minimum zxcvbn score, from 0 (too guessable) to 4 (very unguessable)
+			MinScore int `mapstructure:"min_score" validate:"min=0,max=4"`
+			// Directory of breached password SHA-1 hashes, split by 5 characters prefix in PREFIX.txt files
+			// like the haveibeenpwned range API. The check is disabled if empty.
+			BreachedDirectory string `mapstructure:"breached_directory"`
+		}
 	}
 
 	Gate struct {
@@ -35,6 +125,35 @@ type config struct {
 		// Timeout of the polling request in seconds
 		Timeout           int
 		FirmwareDirectory string `mapstructure:"firmware_directory"`
+
+		Geofence struct {
+			Enabled   bool
+			Latitude  float64
+			Longitude float64
+			// Radius around the gate, in meters, in which users are allowed to open it
+			Radius float64
+			// MaxAccuracy is the worst browser position accuracy accepted, in meters. It is capped to half the radius.
+			MaxAccuracy float64 `mapstructure:"max_accuracy"`
+			// Comma separated list of roles allowed to open the gate from anywhere
+			ExemptRoles string `mapstructure:"exempt_roles"`
+		}
+	}
+
+	// Storage of the address proofs and of the firmware. The local backend uses Users.AddressProofsDirectory and
+	// Gate.FirmwareDirectory, the s3 backend uses a single bucket.
+	Storage struct {
+		Backend string `validate:"oneof=local s3"`
+		S3      struct {
+			// Host and port of the S3 compatible service, without scheme
+			Endpoint  string
+			Region    string
+			Bucket    string
+			AccessKey string `mapstructure:"access_key"`
+			SecretKey string `mapstructure:"secret_key"`
+			UseSSL    bool   `mapstructure:"use_ssl"`
+			// Prefix of all the object keys, to share the bucket with other applications
+			Prefix string
+		}
 	}
 
 	Database struct {
@@ -53,6 +172,18 @@ func init() {
 	Config.Http.Port = "80"
 	Config.Http.BaseURL = "http://localhost"
 	Config.Http.JWT.MaxAge = 30
+	Config.Http.JWT.Algorithm = "HS256"
+	Config.Http.JWT.RotationInterval = 30 * 24 * time.Hour
+	Config.Http.JWT.EmailVerificationTTL = 48 * time.Hour
+	Config.Http.JWT.ResetPasswordTTL = time.Hour
+	Config.Http.JWT.MagicLinkTTL = 15 * time.Minute
+	Config.Http.JWT.EmailChangeTTL = 24 * time.Hour
+	Config.Http.WebAuthn.Timeout = 5 * time.Minute
+	Config.Http.OIDC.Name = "OpenID Connect"
+	Config.Http.OIDC.Timeout = 15 * time.Minute
+
+	Config.Storage.Backend = "local"
+	Config.Storage.S3.UseSSL = true
 
 	Config.Database.URL = "user=postgres dbname=gate password=postgres host=localhost"
 	Config.Database.MigrateOnStart = true
@@ -62,10 +193,31 @@ func init() {
 
 	Config.Gate.Timeout = 60
 	Config.Gate.FirmwareDirectory = "/usr/src/app/firmwares"
+	Config.Gate.Geofence.Radius = 150
+	Config.Gate.Geofence.MaxAccuracy = 200
+	Config.Gate.Geofence.ExemptRoles = "admin"
 
 	Config.Users.ReminderDays = "7, 3, 1"
 	Config.Users.RenewalInterval = "2 months"
 	Config.Users.AddressProofsDirectory = "/usr/src/app/address_proofs"
+	Config.Users.AddressProofRetention = 30 * 24 * time.Hour
+	Config.Users.AddressProofMaxSize = 10 * 1024 * 1024
+	Config.Users.AddressProofMaxFiles = 5
+	Config.Users.AddressProofWatermark = "Réservé à l'usage de Woody Wood Gate"
+	Config.Users.ImpersonationDuration = 15 * time.Minute
+	Config.Users.Invitation.RotationInterval = 30 * 24 * time.Hour
+	Config.Users.Invitation.BuildingName = "Résidence Woody Wood"
+	Config.Users.Login.MaxFailures = 5
+	Config.Users.Login.LockDuration = 15 * time.Minute
+	Config.Users.Login.MaxIPFailures = 20
+	Config.Users.Login.IPWindow = 15 * time.Minute
+	Config.Users.Login.ResetMailInterval = 5 * time.Minute
+	Config.Users.Login.MaxIPResetMails = 5
+	Config.Users.Password.Iterations = 3
+	Config.Users.Password.Memory = 64 * 1024
+	Config.Users.Password.Parallelism = 4
+	Config.Users.Password.MinLength = 16
+	Config.Users.Password.MinScore = 3
 
 	v := viper.New()
 	v.AutomaticEnv()
@@ -84,7 +236,10 @@ func init() {
 			logger.Log.Warn().Str("field", strings.TrimPrefix(e.Namespace(), "config.")).Str("reason", e.Tag()).Msg("invalid config field")
 		}
 
-		logger.Log.Fatal().Msg("configuration is not valid")
+		// The tests set the configuration they need themselves
+		if !testing.Testing() {
+			logger.Log.Fatal().Msg("configuration is not valid")
+		}
 	}
 
 	logger.Log.Debug().Interface("config", Config).Msg("config loaded")
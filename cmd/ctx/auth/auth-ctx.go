@@ -3,8 +3,12 @@ package auth
 import (
 	"context"
 	"woody-wood-portail/cmd/ctx"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/auth"
 	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/permissions"
 
+	"github.com/a-h/templ"
 	"github.com/labstack/echo/v4"
 )
 
@@ -12,6 +16,11 @@ type userContextKeyType string
 
 var userContextKey userContextKeyType = "user"
 
+const (
+	permissionsEchoKey  = "permissions"
+	impersonatorEchoKey = "impersonator"
+)
+
 func GetUserFromEcho(c echo.Context) db.User {
 	user, ok := c.Get("user").(db.User)
 	if !ok {
@@ -25,6 +34,30 @@ func IsAuthenticated(c echo.Context) bool {
 	return ok
 }
 
+// GetSessionFromEcho returns the server side session of the current request, if authenticated
+func GetSessionFromEcho(c echo.Context) (db.Session, bool) {
+	session, ok := c.Get(auth.SessionEchoKey).(db.Session)
+	return session, ok
+}
+
+// Impersonate makes the rest of the request run as the user, remembering the administrator who is viewing the application
+func Impersonate(c echo.Context, admin db.User, user db.User) {
+	c.Set(impersonatorEchoKey, admin)
+	c.Set("user", user)
+	// The permissions of the administrator may have been loaded already
+	c.Set(permissionsEchoKey, nil)
+}
+
+// GetImpersonatorFromEcho returns the administrator viewing the application as the current user, if any
+func GetImpersonatorFromEcho(c echo.Context) (db.User, bool) {
+	admin, ok := c.Get(impersonatorEchoKey).(db.User)
+	return admin, ok
+}
+
+func GetImpersonatorFromTempl(c context.Context) (db.User, bool) {
+	return GetImpersonatorFromEcho(ctx.GetEchoFromTempl(c))
+}
+
 func GetUserFromTempl(c context.Context) db.User {
 	user, ok := c.Value(userContextKey).(db.User)
 	if !ok {
@@ -37,8 +70,41 @@ func GetUserFromTempl(c context.Context) db.User {
 func EchoToTemplContext(c echo.Context) context.Context {
 	templCtx := c.Request().Context()
 	templCtx = ctx.WithEchoContext(templCtx, c)
+	if nonce, ok := c.Get(ctx.NonceEchoKey).(string); ok {
+		templCtx = templ.WithNonce(templCtx, nonce)
+	}
 	if user, ok := c.Get("user").(db.User); ok {
 		templCtx = context.WithValue(templCtx, userContextKey, user)
 	}
 	return templCtx
 }
+
+// GetPermissionsFromEcho returns the permissions granted to the current user by its role.
+// They are loaded on first use and cached for the rest of the request.
+func GetPermissionsFromEcho(c echo.Context) []string {
+	if granted, ok := c.Get(permissionsEchoKey).([]string); ok {
+		return granted
+	}
+
+	user, ok := c.Get("user").(db.User)
+	if !ok {
+		return nil
+	}
+
+	role, err := db.Q(c).GetRole(c.Request().Context(), user.Role)
+	if err != nil {
+		logger.Log.Error().Err(err).Str("role", user.Role).Msg("failed to load role permissions")
+		return nil
+	}
+
+	c.Set(permissionsEchoKey, role.Permissions)
+	return role.Permissions
+}
+
+func HasPermission(c echo.Context, required ...permissions.Permission) bool {
+	return permissions.HasAny(GetPermissionsFromEcho(c), required...)
+}
+
+func HasPermissionInTempl(c context.Context, required ...permissions.Permission) bool {
+	return HasPermission(ctx.GetEchoFromTempl(c), required...)
+}
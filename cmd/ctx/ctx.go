@@ -10,6 +10,13 @@ type echoContextKeyType string
 
 var echoContextKey echoContextKeyType = "echo"
 
+const (
+	// CSRFEchoKey is where the CSRF middleware stores the token of the current request
+	CSRFEchoKey = "csrf"
+	// NonceEchoKey is where the Content-Security-Policy nonce of the current request is stored
+	NonceEchoKey = "nonce"
+)
+
 func WithEchoContext(c context.Context, ec echo.Context) context.Context {
 	return context.WithValue(c, echoContextKey, ec)
 }
@@ -21,3 +28,9 @@ func GetEchoFromTempl(c context.Context) echo.Context {
 	}
 	return ec
 }
+
+// GetCSRFToken returns the token to send with state changing requests
+func GetCSRFToken(c context.Context) string {
+	token, _ := GetEchoFromTempl(c).Get(CSRFEchoKey).(string)
+	return token
+}
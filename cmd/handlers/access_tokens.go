@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+	ctx "woody-wood-portail/cmd/ctx/auth"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/tokens"
+	"woody-wood-portail/views"
+	"woody-wood-portail/views/components"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const accessTokenEchoKey = "access_token"
+
+func registerAccessTokenUserHandlers(userRoutes *echo.Group) {
+	tokensRoutes := userRoutes.Group("/tokens")
+
+	tokensRoutes.GET("", func(c echo.Context) error {
+		accessTokens, err := db.Q(c).ListActiveAccessTokensByUser(c.Request().Context(), ctx.GetUserFromEcho(c).ID)
+		if err != nil {
+			return err
+		}
+
+		return Render(c, 200, views.UserAccessTokensPage(&views.UserAccessTokensModel{
+			Tokens: accessTokens,
+			Form:   views.UserAccessTokenFormModel{FormModel: components.NewFormModel(nil, nil)},
+		}))
+	})
+
+	tokensRoutes.POST("", func(c echo.Context) error {
+		values, rawValues, err := Bind[views.UserAccessTokenValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to bind values")
+			return Render(c, 422, views.UserAccessTokenForm(&views.UserAccessTokenFormModel{FormModel: components.NewFormError("Erreur inatendue", rawValues)}, nil))
+		}
+
+		model := &views.UserAccessTokenFormModel{FormModel: components.NewFormModel(rawValues, Validate(c, values))}
+		for _, scope := range values.Scopes {
+			if !tokens.IsValid(scope) {
+				model.Errors.Fields["Scopes"] = "Autorisation inconnue : " + scope
+				break
+			}
+		}
+		if model.HasError() {
+			return Render(c, 422, views.UserAccessTokenForm(model, nil))
+		}
+
+		days, err := strconv.Atoi(values.ExpiresIn)
+		if err != nil {
+			model.Errors.Fields["ExpiresIn"] = "Expiration invalide"
+			return Render(c, 422, views.UserAccessTokenForm(model, nil))
+		}
+
+		token, hash, err := tokens.Generate()
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to generate access token")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserAccessTokenForm(model, nil))
+		}
+
+		currentUser := ctx.GetUserFromEcho(c)
+		accessToken, err := db.Q(c).CreateAccessToken(c.Request().Context(), db.CreateAccessTokenParams{
+			UserID:    currentUser.ID,
+			Name:      values.Name,
+			TokenHash: hash,
+			Scopes:    values.Scopes,
+			ExpiresAt: pgtype.Timestamp{Time: time.Now().AddDate(0, 0, days), Valid: true},
+		})
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to create access token")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserAccessTokenForm(model, nil))
+		}
+
+		accessTokens, err := db.Q(c).ListActiveAccessTokensByUser(c.Request().Context(), currentUser.ID)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to list access tokens")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserAccessTokenForm(model, nil))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserAccessTokenForm(model, nil))
+		}
+
+		logger.Log.Info().Stringer("user", currentUser.ID).Stringer("token", accessToken.ID).Strs("scopes", accessToken.Scopes).Msg("Access token created")
+
+		return Render(c, 200, views.UserAccessTokenForm(&views.UserAccessTokenFormModel{
+			FormModel: components.NewFormModel(nil, nil),
+			Created:   token,
+		}, accessTokens))
+	})
+
+	tokensRoutes.DELETE("/:id", func(c echo.Context) error {
+		currentUser := ctx.GetUserFromEcho(c)
+
+		tokenID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(404, "Failed to parse token ID: "+err.Error())
+		}
+
+		revoked, err := db.Q(c).RevokeAccessToken(c.Request().Context(), db.RevokeAccessTokenParams{
+			ID:     tokenID,
+			UserID: currentUser.ID,
+		})
+		if err != nil {
+			logger.Log.Error().Err(err).Stringer("token", tokenID).Msg("Failed to revoke access token")
+			return c.String(500, "Erreur inatendue")
+		}
+		if revoked == 0 {
+			return c.String(404, "Jeton introuvable")
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		logger.Log.Info().Stringer("user", currentUser.ID).Stringer("token", tokenID).Msg("Access token revoked")
+
+		return c.NoContent(200)
+	})
+}
+
+// RegisterAPIHandlers registers the routes used by automations, authenticated with a personal access token
+func RegisterAPIHandlers(e *echo.Echo, openChannel chan struct{}) {
+	apiRoutes := e.Group("/api")
+
+	apiRoutes.Use(middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+		KeyLookup:  "header:" + echo.HeaderAuthorization,
+		AuthScheme: "Bearer",
+		Validator: func(key string, c echo.Context) (bool, error) {
+			row, err := db.Q(c).GetActiveAccessToken(c.Request().Context(), tokens.Hash(key))
+			if errors.Is(err, pgx.ErrNoRows) {
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+
+			if !row.User.EmailVerified || row.User.RegistrationState != "accepted" {
+				logger.Log.Info().Stringer("user", row.User.ID).Msg("Access token of an inactive account")
+				return false, nil
+			}
+
+			// Not in the request transaction, since most handlers never commit it
+			if err := db.QGlobal().TouchAccessToken(c.Request().Context(), row.AccessToken.ID); err != nil {
+				logger.Log.Error().Err(err).Stringer("token", row.AccessToken.ID).Msg("Failed to update access token last use")
+			}
+
+			c.Set("user", row.User)
+			c.Set(accessTokenEchoKey, row.AccessToken)
+			return true, nil
+		},
+		ErrorHandler: func(err error, c echo.Context) error {
+			logger.Log.Info().Err(err).Msg("Invalid access token")
+			return c.JSON(401, map[string]string{"message": "Jeton d'accès invalide ou expiré"})
+		},
+	}))
+
+	apiRoutes.POST("/gate/open", func(c echo.Context) error {
+		accessToken := c.Get(accessTokenEchoKey).(db.AccessToken)
+
+		message, ok := openGate(c, ctx.GetUserFromEcho(c), pgtype.UUID{Bytes: accessToken.ID, Valid: true}, openChannel)
+		if !ok {
+			return c.JSON(422, map[string]string{"message": message})
+		}
+		return c.JSON(200, map[string]string{"message": message})
+	}, RequireScopeMiddleware(tokens.GateOpen))
+
+	apiRoutes.GET("/logs", func(c echo.Context) error {
+		logs, err := db.Q(c).ListLogsByUser(c.Request().Context(), ctx.GetUserFromEcho(c).ID)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to list logs")
+			return c.JSON(500, map[string]string{"message": "Erreur inatendue"})
+		}
+
+		type apiLog struct {
+			CreatedAt time.Time `json:"created_at"`
+			Distance  *float64  `json:"distance,omitempty"`
+			Token     string    `json:"token,omitempty"`
+		}
+		result := make([]apiLog, 0, len(logs))
+		for _, log := range logs {
+			l := apiLog{CreatedAt: log.CreatedAt.Time, Token: log.AccessTokenName.String}
+			if log.Distance.Valid {
+				l.Distance = &log.Distance.Float64
+			}
+			result = append(result, l)
+		}
+
+		return c.JSON(200, result)
+	}, RequireScopeMiddleware(tokens.LogsRead))
+}
+
+// RequireScopeMiddleware rejects access tokens which have not been granted the scope
+func RequireScopeMiddleware(scope tokens.Scope) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			accessToken, ok := c.Get(accessTokenEchoKey).(db.AccessToken)
+			if !ok || !tokens.Has(accessToken.Scopes, scope) {
+				return c.JSON(403, map[string]string{"message": "Ce jeton n'a pas l'autorisation " + string(scope)})
+			}
+			return next(c)
+		}
+	}
+}
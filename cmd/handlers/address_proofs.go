@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path/filepath"
+	ctx "woody-wood-portail/cmd/ctx/auth"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/proofs"
+	"woody-wood-portail/views"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+func registerAddressProofHandlers(registrationsGroup *echo.Group) {
+	registrationsGroup.GET("/:id/address_proofs", func(c echo.Context) error {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(400, "missing id param")
+		}
+
+		documents, err := proofs.List(c.Request().Context(), userID)
+		if err != nil {
+			logger.Log.Err(err).Stringer("user id", userID).Msg("failed to list user address proofs")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		return Render(c, 200, views.AdminAddressProofs(userID, documents))
+	})
+
+	registrationsGroup.GET("/:id/address_proofs/:document", func(c echo.Context) error {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(400, "missing id param")
+		}
+
+		document, err := proofs.Watermarked(c.Request().Context(), userID, c.Param("document"))
+		if errors.Is(err, proofs.ErrNotFound) {
+			return c.NoContent(404)
+		} else if err != nil {
+			logger.Log.Err(err).Stringer("user id", userID).Msg("failed to decrypt user address proof")
+			return c.NoContent(500)
+		}
+
+		if err := recordAddressProofAccess(c, userID, false); err != nil {
+			return c.NoContent(500)
+		}
+
+		contentType := mime.TypeByExtension(filepath.Ext(document.Filename))
+		if contentType == "" {
+			contentType = http.DetectContentType(document.Content)
+		}
+		c.Response().Header().Set("Cache-Control", "no-store")
+		c.Response().Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": document.Filename}))
+		return c.Blob(200, contentType, document.Content)
+	})
+
+	registrationsGroup.GET("/:id/address_proofs/:document/preview", func(c echo.Context) error {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(400, "missing id param")
+		}
+
+		preview, err := proofs.Preview(c.Request().Context(), userID, c.Param("document"))
+		if errors.Is(err, proofs.ErrNotFound) || errors.Is(err, proofs.ErrUnsupportedType) {
+			return c.NoContent(404)
+		} else if err != nil {
+			logger.Log.Err(err).Stringer("user id", userID).Msg("failed to generate user address proof preview")
+			return c.NoContent(500)
+		}
+
+		if err := recordAddressProofAccess(c, userID, true); err != nil {
+			return c.NoContent(500)
+		}
+
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return c.Blob(200, "image/jpeg", preview)
+	})
+}
+
+// recordAddressProofAccess saves the access of the current admin to an address proof, the document must only be sent
+// once it is recorded
+func recordAddressProofAccess(c echo.Context, userID uuid.UUID, preview bool) error {
+	admin := ctx.GetUserFromEcho(c)
+	if err := db.Q(c).CreateAddressProofDownload(c.Request().Context(), db.CreateAddressProofDownloadParams{
+		AdminID:  pgtype.UUID{Bytes: admin.ID, Valid: true},
+		UserID:   userID,
+		Ip:       c.RealIP(),
+		Document: pgtype.Text{String: c.Param("document"), Valid: true},
+		Preview:  preview,
+	}); err != nil {
+		logger.Log.Err(err).Stringer("user id", userID).Msg("failed to record address proof download")
+		return err
+	}
+	if err := db.Commit(c); err != nil {
+		logger.Log.Err(err).Msg("failed to commit address proof download")
+		return err
+	}
+
+	logger.Log.Info().Stringer("user", userID).Stringer("admin", admin.ID).Str("document", c.Param("document")).Bool("preview", preview).Msg("address proof downloaded")
+	return nil
+}
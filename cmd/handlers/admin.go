@@ -1,92 +1,45 @@
 package handlers
 
 import (
-	"encoding/base64"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
-	"os"
-	"path"
+	"time"
 	"woody-wood-portail/cmd/config"
 	ctx "woody-wood-portail/cmd/ctx/auth"
 	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/auth"
 	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/decisions"
 	"woody-wood-portail/cmd/services/mails"
+	"woody-wood-portail/cmd/services/permissions"
+	"woody-wood-portail/cmd/services/proofs"
+	"woody-wood-portail/cmd/services/storage"
 	"woody-wood-portail/views"
 	"woody-wood-portail/views/components"
 	"woody-wood-portail/views/emails"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
 	"github.com/labstack/echo/v4"
-	"github.com/skip2/go-qrcode"
 )
 
-func RegisterAdminHandlers(e RequireAuth, gateModel *Model) {
+func RegisterAdminHandlers(e RequireAuth, gateModel *Model, openChannel chan struct{}) {
 	adminGroup := e.Group.Group("/admin")
-	adminGroup.Use(RequireAdminRoleMiddleware)
+	adminGroup.Use(RequireAllowedIPMiddleware(), RequirePermissionMiddleware(permissions.All...), RequireTOTPMiddleware)
 
 	adminGroup.GET("", func(c echo.Context) error {
-		return Redirect(c, "/admin/users")
+		return Redirect(c, adminHomePage(c))
 	})
 	adminGroup.GET("/", func(c echo.Context) error {
-		return Redirect(c, "/admin/users")
+		return Redirect(c, adminHomePage(c))
 	})
 
-	adminGroup.GET("/invitation", func(c echo.Context) error {
-		var err error
-		model := &views.AdminInvitationFormModel{}
-		model.Code, err = db.Q(c).GetRegistrationCode(c.Request().Context())
+	registerInvitationAdminHandlers(adminGroup)
 
-		if err != nil && err != pgx.ErrNoRows {
-			model.Err = err.Error()
-			return Render(c, 200, views.AdminInvitationPage(model))
-		}
-
-		model.QrCode, err = invitationQrCodeHandler(model.Code)
-		if err != nil {
-			model.Err = err.Error()
-		}
-
-		return Render(c, 200, views.AdminInvitationPage(model))
-	})
-
-	adminGroup.POST("/invitation", func(c echo.Context) error {
-		var err error
-		model := &views.AdminInvitationFormModel{}
-		model.Code, err = db.Q(c).GetRegistrationCode(c.Request().Context())
-		if err != nil && err != pgx.ErrNoRows {
-			model.Err = err.Error()
-			return Render(c, 422, views.AdminInvitationForm(model))
-		}
-
-		newCode := fmt.Sprintf("%06d", rand.Int31n(899_999)+100_000)
-
-		if err = db.Q(c).SetRegistrationCode(c.Request().Context(), newCode); err != nil {
-			logger.Log.Error().Err(err).Msg("Failed to set registration code")
-			model.Err = err.Error()
-			return Render(c, 422, views.AdminInvitationForm(model))
-		}
+	usersGroup := adminGroup.Group("/users", RequirePermissionMiddleware(permissions.ApproveRegistrations, permissions.ManageUsers, permissions.ViewLogs))
 
-		model.Code = newCode
-		model.QrCode, err = invitationQrCodeHandler(newCode)
-		if err != nil {
-			logger.Log.Error().Err(err).Msg("Failed to render QR code")
-			model.Err = err.Error()
-			return Render(c, 422, views.AdminInvitationForm(model))
-		}
-
-		if err := db.Commit(c); err != nil {
-			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
-			model.Err = err.Error()
-			return Render(c, 422, views.AdminInvitationForm(model))
-		}
-
-		return Render(c, 200, views.AdminInvitationForm(model))
-	})
-
-	adminGroup.GET("/users", func(c echo.Context) error {
+	usersGroup.GET("", func(c echo.Context) error {
 		users, err := db.Q(c).ListUsers(c.Request().Context())
 		if err != nil {
 			return fmt.Errorf("failed to list users: %w", err)
@@ -97,11 +50,29 @@ func RegisterAdminHandlers(e RequireAuth, gateModel *Model) {
 			Rejected: make([]db.User, 0),
 		}
 
+		if ctx.HasPermission(c, permissions.ManageUsers) {
+			model.Locked, err = db.Q(c).ListLockedUsers(c.Request().Context())
+			if err != nil {
+				return fmt.Errorf("failed to list locked users: %w", err)
+			}
+
+			model.OutdatedPasswords, err = auth.CountOutdatedPasswords(c.Request().Context(), db.Q(c))
+			if err != nil {
+				return fmt.Errorf("failed to count outdated passwords: %w", err)
+			}
+		}
+
 		for _, user := range users {
+			if user.RenewalRequestedAt.Valid {
+				model.Renewals = append(model.Renewals, user)
+			}
+
 			switch user.RegistrationState {
+			case "renewal_pending":
+				// Only listed in the renewals
 			case "pending", "new":
 				model.Pending = append(model.Pending, user)
-			case "rejected":
+			case "rejected", "suspended":
 				model.Rejected = append(model.Rejected, user)
 			case "accepted":
 				model.Users = append(model.Users, user)
@@ -113,7 +84,7 @@ func RegisterAdminHandlers(e RequireAuth, gateModel *Model) {
 		return Render(c, 200, views.AdminUsersPage(model))
 	})
 
-	adminGroup.GET("/users/:id", func(c echo.Context) error {
+	usersGroup.GET("/:id", func(c echo.Context) error {
 		userID, err := uuid.Parse(c.Param("id"))
 		if err != nil {
 			return c.String(404, "Failed to parse user ID: "+err.Error())
@@ -130,18 +101,26 @@ func RegisterAdminHandlers(e RequireAuth, gateModel *Model) {
 			},
 		}
 
-		logs, err := db.Q(c).ListLogsByUser(c.Request().Context(), userID)
+		model.Form.Roles, err = db.Q(c).ListRoles(c.Request().Context())
 		if err != nil {
-			logger.Log.Error().Err(err).Msg("Failed to list logs")
-			model.Form.Errors.Global = "Une erreur inatendue est survenue lors du chargement des demandes d'ouvertures"
-		} else {
-			model.Logs = logs
+			logger.Log.Error().Err(err).Msg("Failed to list roles")
+			model.Form.Errors.Global = "Une erreur inatendue est survenue lors du chargement des rôles"
+		}
+
+		if ctx.HasPermission(c, permissions.ViewLogs) {
+			logs, err := db.Q(c).ListLogsByUser(c.Request().Context(), userID)
+			if err != nil {
+				logger.Log.Error().Err(err).Msg("Failed to list logs")
+				model.Form.Errors.Global = "Une erreur inatendue est survenue lors du chargement des demandes d'ouvertures"
+			} else {
+				model.Logs = logs
+			}
 		}
 
 		return Render(c, 200, views.AdminUserPage(model))
-	})
+	}, RequirePermissionMiddleware(permissions.ManageUsers, permissions.ViewLogs))
 
-	adminGroup.PUT("/users/:id", func(c echo.Context) error {
+	usersGroup.PUT("/:id", func(c echo.Context) error {
 		userID, err := uuid.Parse(c.Param("id"))
 		if err != nil {
 			return c.String(404, "Failed to parse user ID: "+err.Error())
@@ -157,16 +136,34 @@ func RegisterAdminHandlers(e RequireAuth, gateModel *Model) {
 			FormModel: components.NewFormModel(rawValues, Validate(c, values)),
 		}
 
+		model.User, err = db.Q(c).GetUser(c.Request().Context(), userID)
+		if err != nil {
+			return c.NoContent(404)
+		}
+
+		model.Roles, err = db.Q(c).ListRoles(c.Request().Context())
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to list roles")
+			model.Errors.Global = "Une erreur inatendue est survenue lors du chargement des rôles"
+			return Render(c, 422, views.AdminUserForm(model))
+		}
+
+		if values.Role != model.User.Role && !ctx.HasPermission(c, permissions.ManageRoles) {
+			model.Errors.Fields["Role"] = "Vous n'avez pas la permission de modifier le rôle"
+		}
+
 		if model.HasError() {
 			return Render(c, 422, views.AdminUserForm(model))
 		}
 
+		previousEmail := model.User.Email
 		model.User, err = db.Q(c).UpdateUserInfo(c.Request().Context(), db.UpdateUserInfoParams{
-			ID:        userID,
-			Email:     values.Email,
-			Role:      values.Role,
-			FullName:  values.FullName,
-			Apartment: values.Apartment,
+			ID:             userID,
+			Email:          values.Email,
+			Role:           values.Role,
+			FullName:       values.FullName,
+			Apartment:      values.Apartment,
+			LockdownExempt: values.LockdownExempt,
 		})
 		if err != nil {
 			logger.Log.Error().Err(err).Msg("Failed to update user info")
@@ -180,28 +177,31 @@ func RegisterAdminHandlers(e RequireAuth, gateModel *Model) {
 			return Render(c, 422, views.AdminUserForm(model))
 		}
 
+		if model.User.Email != previousEmail {
+			logger.Log.Info().Stringer("user", userID).Msg("Email changed by an administrator")
+			if err := sendVerificationMail(c, model.User); err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to send verification email")
+				model.Errors.Global = "L'email a été modifié mais le mail de vérification n'a pas pu être envoyé"
+				return Render(c, 422, views.AdminUserForm(model))
+			}
+		}
+
 		return Render(c, 200, views.AdminUserForm(model))
-	})
+	}, RequirePermissionMiddleware(permissions.ManageUsers))
 
-	adminGroup.GET("/registrations/:id/address_proof", func(c echo.Context) error {
-		userID, err := uuid.Parse(c.Param("id"))
-		if err != nil {
-			return c.String(400, "missing id param")
-		}
+	registerTOTPAdminHandlers(usersGroup)
+	registerSessionAdminHandlers(usersGroup)
+	registerLockoutAdminHandlers(usersGroup)
+	registerImpersonationAdminHandlers(usersGroup)
+	registerImpersonationLogHandlers(adminGroup)
 
-		userAddressProofDir := addressProofDir(userID)
-		entries, err := os.ReadDir(userAddressProofDir)
-		if os.IsNotExist(err) || len(entries) == 0 {
-			return c.NoContent(404)
-		} else if err != nil {
-			logger.Log.Err(err).Stringer("user id", userID).Str("dir", userAddressProofDir).Msg("failed to list user address proof files")
-			return c.NoContent(500)
-		}
+	registrationsGroup := adminGroup.Group("/registrations", RequirePermissionMiddleware(permissions.ApproveRegistrations))
 
-		return c.File(path.Join(userAddressProofDir, entries[0].Name()))
-	})
+	registerAddressProofHandlers(registrationsGroup)
+	registerRenewalHandlers(registrationsGroup)
+	registerDecisionHandlers(registrationsGroup)
 
-	adminGroup.PUT("/registrations/:id/:action", func(c echo.Context) error {
+	registrationsGroup.PUT("/:id/:action", func(c echo.Context) error {
 		model := &views.AdminUserRowModel{}
 
 		userID, err := uuid.Parse(c.Param("id"))
@@ -226,8 +226,8 @@ func RegisterAdminHandlers(e RequireAuth, gateModel *Model) {
 				return Render(c, 422, views.AdminPendingRow(model))
 			}
 
-			if err := os.RemoveAll(addressProofDir(userID)); err != nil {
-				logger.Log.Err(err).Stringer("user", userID).Str("dir", addressProofDir(userID)).Msg("failed to clean up address proof")
+			if err := proofs.Delete(c.Request().Context(), userID); err != nil {
+				logger.Log.Err(err).Stringer("user", userID).Msg("failed to clean up address proof")
 				model.Err = errors.New("échec de la suppression du justificatif de domicile")
 				return Render(c, 422, views.AdminPendingRow(model))
 			}
@@ -241,24 +241,30 @@ func RegisterAdminHandlers(e RequireAuth, gateModel *Model) {
 
 			return Render(c, 200, components.OOB("beforeend:#accepted-list", views.AdminAcceptedRow(model)))
 		case "reject":
-			model.User, err = db.Q(c).RegistrationRejected(c.Request().Context(), userID)
+			model.User, err = db.Q(c).GetUser(c.Request().Context(), userID)
 			if err != nil {
 				model.Err = errors.New("utilisateur introuvable")
-				logger.Log.Error().Err(err).Msg("Failed to reject registration")
 				return Render(c, 422, views.AdminPendingRow(model))
 			}
 
-			if err := sendRegistrationRejectedMail(c, model.User); err != nil {
-				model.Err = errors.New("échec de l'envoi de l'e-mail")
+			decision, err := readDecision(c)
+			if err != nil {
+				model.Err = err
 				return Render(c, 422, views.AdminPendingRow(model))
 			}
 
-			if err := os.RemoveAll(addressProofDir(userID)); err != nil {
-				logger.Log.Err(err).Stringer("user", userID).Str("dir", addressProofDir(userID)).Msg("failed to clean up address proof")
-				model.Err = errors.New("échec de la suppression du justificatif de domicile")
+			reason, comment := decisions.Params(decision.Reason, decision.Comment)
+			model.User, err = db.Q(c).RegistrationRejected(c.Request().Context(), db.RegistrationRejectedParams{
+				ID:                  userID,
+				DecisionReason:      reason,
+				DecisionComment:     comment,
+				ResubmissionAllowed: decision.AllowResubmission,
+			})
+			if err != nil {
+				model.Err = errors.New("utilisateur introuvable")
+				logger.Log.Error().Err(err).Msg("Failed to reject registration")
 				return Render(c, 422, views.AdminPendingRow(model))
 			}
-			logger.Log.Info().Stringer("user", userID).Msg("Successfully cleaned up address proof")
 
 			if err := db.Commit(c); err != nil {
 				model.Err = errors.New("échec de l'enregistrement")
@@ -266,6 +272,15 @@ func RegisterAdminHandlers(e RequireAuth, gateModel *Model) {
 				return Render(c, 422, views.AdminPendingRow(model))
 			}
 
+			// The rejection is saved, the cleanup and the notification can't roll it back
+			if err := proofs.Delete(c.Request().Context(), userID); err != nil {
+				logger.Log.Err(err).Stringer("user", userID).Msg("failed to clean up address proof")
+			} else {
+				logger.Log.Info().Stringer("user", userID).Msg("Successfully cleaned up address proof")
+			}
+
+			_ = sendRegistrationRejectedMail(c, model.User)
+
 			return Render(c, 200, components.OOB("beforeend:#rejected-list", views.AdminRejectedRow(model)))
 		case "reset":
 			model.User, err = db.Q(c).RegistrationPending(c.Request().Context(), userID)
@@ -289,7 +304,7 @@ func RegisterAdminHandlers(e RequireAuth, gateModel *Model) {
 		}
 	})
 
-	adminGroup.DELETE("/registrations/:id", func(c echo.Context) error {
+	registrationsGroup.DELETE("/:id", func(c echo.Context) error {
 		model := &views.AdminUserRowModel{}
 
 		userID, err := uuid.Parse(c.Param("id"))
@@ -315,11 +330,13 @@ func RegisterAdminHandlers(e RequireAuth, gateModel *Model) {
 		return c.NoContent(200)
 	})
 
-	adminGroup.GET("/firmware", func(c echo.Context) error {
+	firmwareGroup := adminGroup.Group("/firmware", RequirePermissionMiddleware(permissions.ManageFirmware))
+
+	firmwareGroup.GET("", func(c echo.Context) error {
 		model := views.FirmwarePageModel{}
 
-		currentVersion, err := getCurrentFirmwareVersion()
-		if err != nil && !os.IsNotExist(err) {
+		currentVersion, err := getCurrentFirmwareVersion(c.Request().Context())
+		if err != nil {
 			logger.Log.Error().Err(err).Msg("failed to get current version")
 			model.ErrorMsg = fmt.Sprintf("failed to get current version: %s", err)
 			model.CurrentVersion = "none"
@@ -335,8 +352,8 @@ func RegisterAdminHandlers(e RequireAuth, gateModel *Model) {
 		return Render(c, 200, views.FirmwarePage(model))
 	})
 
-	adminGroup.PUT("/firmware", func(c echo.Context) (err error) {
-		currentVersion, _ := getCurrentFirmwareVersion()
+	firmwareGroup.PUT("", func(c echo.Context) (err error) {
+		currentVersion, _ := getCurrentFirmwareVersion(c.Request().Context())
 
 		firmware, err := c.FormFile("firmware")
 		if err != nil {
@@ -351,58 +368,72 @@ func RegisterAdminHandlers(e RequireAuth, gateModel *Model) {
 		}
 		defer src.Close()
 
-		dst, err := os.Create(path.Join(config.Config.Gate.FirmwareDirectory, firmware.Filename))
+		content, err := io.ReadAll(src)
 		if err != nil {
-			logger.Log.Error().Err(err).Msg("Failed to create new firmware file")
-			return Render(c, 422, views.FirmwareUpdateResult(currentVersion, fmt.Sprintf("Échec de la création du fichier : %s", err)))
+			logger.Log.Error().Err(err).Msg("Failed to read uploaded firmware file")
+			return Render(c, 422, views.FirmwareUpdateResult(currentVersion, fmt.Sprintf("Impossible de lire le fichier envoyé : %s", err)))
+		}
+
+		if err = storage.Firmwares.Put(c.Request().Context(), firmware.Filename, content, time.Now()); err != nil {
+			logger.Log.Error().Err(err).Msg("failed to save firmware")
+			return Render(c, 422, views.FirmwareUpdateResult(currentVersion, fmt.Sprintf("Échec de l'enregirstement du firmware : %s", err)))
 		}
 		defer func() {
 			if err != nil {
-				logger.Log.Info().Msg("error while uploading file, deleting it from disk")
-				if err := os.Remove(path.Join(config.Config.Gate.FirmwareDirectory, firmware.Filename)); err != nil {
+				logger.Log.Info().Msg("error while uploading file, deleting it from the storage")
+				if err := storage.Firmwares.Delete(context.Background(), firmware.Filename); err != nil {
 					logger.Log.Error().Err(err).Msg("failed to delete firmware file")
 				}
 			}
 		}()
-		defer dst.Close()
-
-		if _, err = io.Copy(dst, src); err != nil {
-			logger.Log.Error().Err(err).Msg("failed to save firmware")
-			return Render(c, 422, views.FirmwareUpdateResult(currentVersion, fmt.Sprintf("Échec de l'enregirstement du firmware : %s", err)))
-		}
 
-		if currentVersion != "none" {
+		if currentVersion != "none" && currentVersion+".bin" != firmware.Filename {
 			logger.Log.Info().Str("current version", currentVersion).Msg("deleting previous firmware")
-			if err = os.Remove(path.Join(config.Config.Gate.FirmwareDirectory, currentVersion+".bin")); err != nil {
+			if err = storage.Firmwares.Delete(c.Request().Context(), currentVersion+".bin"); err != nil {
 				logger.Log.Error().Err(err).Msg("failed to delete firmware file")
 				return Render(c, 422, views.FirmwareUpdateResult(currentVersion, fmt.Sprintf("Échec de la suppression du fichier précédent : %s", err)))
 			}
 		}
 
-		logger.Log.Info().Str("firmware", firmware.Filename).Str("save path", config.Config.Gate.FirmwareDirectory).Msg("New firmware uploaded")
+		logger.Log.Info().Str("firmware", firmware.Filename).Str("backend", config.Config.Storage.Backend).Msg("New firmware uploaded")
 
-		currentVersion, _ = getCurrentFirmwareVersion()
+		currentVersion, _ = getCurrentFirmwareVersion(c.Request().Context())
 		return Render(c, 200, views.FirmwareUpdateResult(currentVersion, ""))
 	})
+
+	registerRoleHandlers(adminGroup)
+	registerLockdownHandlers(adminGroup, openChannel)
 }
 
-func invitationQrCodeHandler(code string) (string, error) {
-	qrPNG, err := qrcode.Encode(config.Config.Http.BaseURL+"/register?code="+code, qrcode.Medium, 256)
-	if err != nil {
-		return "", err
+// RequirePermissionMiddleware only allows users having at least one of the given permissions
+func RequirePermissionMiddleware(required ...permissions.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !ctx.HasPermission(c, required...) {
+				user := ctx.GetUserFromEcho(c)
+				logger.Log.Debug().Stringer("user", user.ID).Str("role", user.Role).Str("path", c.Path()).Msg("unauthorized access to admin page")
+				return Redirect(c, "/user")
+			}
+			return next(c)
+		}
 	}
-
-	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrPNG), nil
 }
 
-func RequireAdminRoleMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		user := ctx.GetUserFromEcho(c)
-		if user.Role != "admin" {
-			logger.Log.Debug().Stringer("user", user.ID).Str("role", user.Role).Msg("unauthorized access to admin page")
-			return Redirect(c, "/user")
-		}
-		return next(c)
+// adminHomePage returns the first admin page the current user is allowed to see
+func adminHomePage(c echo.Context) string {
+	switch {
+	case ctx.HasPermission(c, permissions.ApproveRegistrations, permissions.ManageUsers, permissions.ViewLogs):
+		return "/admin/users"
+	case ctx.HasPermission(c, permissions.ManageInvitations):
+		return "/admin/invitation"
+	case ctx.HasPermission(c, permissions.ManageRoles):
+		return "/admin/roles"
+	case ctx.HasPermission(c, permissions.ManageGate):
+		return "/admin/lockdown"
+	case ctx.HasPermission(c, permissions.ManageFirmware):
+		return "/admin/firmware"
+	default:
+		return "/user"
 	}
 }
 
@@ -429,7 +460,3 @@ func sendRegistrationRejectedMail(c echo.Context, user db.User) error {
 
 	return nil
 }
-
-func addressProofDir(userID uuid.UUID) string {
-	return path.Join(config.Config.Users.AddressProofsDirectory, userID.String())
-}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/permissions"
+	"woody-wood-portail/views"
+	"woody-wood-portail/views/components"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+func registerRoleHandlers(adminGroup *echo.Group) {
+	rolesGroup := adminGroup.Group("/roles", RequirePermissionMiddleware(permissions.ManageRoles))
+
+	rolesGroup.GET("", func(c echo.Context) error {
+		roles, err := db.Q(c).ListRoles(c.Request().Context())
+		if err != nil {
+			return fmt.Errorf("failed to list roles: %w", err)
+		}
+
+		return Render(c, 200, views.AdminRolesPage(&views.AdminRolesPageModel{Roles: roles}))
+	})
+
+	rolesGroup.POST("", func(c echo.Context) error {
+		values, rawValues, err := Bind[views.AdminNewRoleValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to bind values")
+			return Render(c, 422, views.AdminNewRoleForm(&views.AdminRoleFormModel{FormModel: components.NewFormError("Erreur inatendue", rawValues)}, nil))
+		}
+
+		model := &views.AdminRoleFormModel{
+			FormModel: components.NewFormModel(rawValues, Validate(c, values)),
+		}
+		validatePermissions(model, values.Permissions)
+
+		if model.HasError() {
+			return Render(c, 422, views.AdminNewRoleForm(model, nil))
+		}
+
+		role, err := db.Q(c).CreateRole(c.Request().Context(), db.CreateRoleParams{
+			Name:        values.Name,
+			Label:       values.Label,
+			Permissions: nonNilPermissions(values.Permissions),
+		})
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to create role")
+			model.Errors.Global = "Une erreur inatendue lors de la sauvegarde"
+			return Render(c, 422, views.AdminNewRoleForm(model, nil))
+		}
+
+		if err = db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			model.Errors.Global = "Une erreur inatendue lors de la sauvegarde"
+			return Render(c, 422, views.AdminNewRoleForm(model, nil))
+		}
+
+		logger.Log.Info().Str("role", role.Name).Strs("permissions", role.Permissions).Msg("Role created")
+
+		return Render(c, 200, views.AdminNewRoleForm(&views.AdminRoleFormModel{}, &role))
+	})
+
+	rolesGroup.PUT("/:name", func(c echo.Context) error {
+		role, err := db.Q(c).GetRole(c.Request().Context(), c.Param("name"))
+		if err != nil {
+			return c.NoContent(404)
+		}
+
+		if role.Builtin {
+			return Render(c, 422, views.AdminRoleForm(&views.AdminRoleFormModel{Role: role, FormModel: components.NewFormError("Les rôles intégrés ne peuvent pas être modifiés")}))
+		}
+
+		values, rawValues, err := Bind[views.AdminRoleValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to bind values")
+			return Render(c, 422, views.AdminRoleForm(&views.AdminRoleFormModel{Role: role, FormModel: components.NewFormError("Erreur inatendue", rawValues)}))
+		}
+
+		model := &views.AdminRoleFormModel{
+			FormModel: components.NewFormModel(rawValues, Validate(c, values)),
+			Role:      role,
+		}
+		validatePermissions(model, values.Permissions)
+
+		if model.HasError() {
+			return Render(c, 422, views.AdminRoleForm(model))
+		}
+
+		model.Role, err = db.Q(c).UpdateRole(c.Request().Context(), db.UpdateRoleParams{
+			Name:        role.Name,
+			Label:       values.Label,
+			Permissions: nonNilPermissions(values.Permissions),
+		})
+		if err != nil {
+			logger.Log.Error().Err(err).Str("role", role.Name).Msg("Failed to update role")
+			model.Role = role
+			model.Errors.Global = "Une erreur inatendue lors de la sauvegarde"
+			return Render(c, 422, views.AdminRoleForm(model))
+		}
+
+		if err = db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			model.Errors.Global = "Une erreur inatendue lors de la sauvegarde"
+			return Render(c, 422, views.AdminRoleForm(model))
+		}
+
+		logger.Log.Info().Str("role", role.Name).Strs("permissions", model.Role.Permissions).Msg("Role updated")
+
+		return Render(c, 200, views.AdminRoleForm(&views.AdminRoleFormModel{Role: model.Role}))
+	})
+
+	rolesGroup.DELETE("/:name", func(c echo.Context) error {
+		role, err := db.Q(c).GetRole(c.Request().Context(), c.Param("name"))
+		if err != nil {
+			return c.NoContent(404)
+		}
+
+		model := &views.AdminRoleFormModel{Role: role, FormModel: components.NewFormModel(nil, nil)}
+
+		if role.Builtin {
+			model.Errors.Global = "Les rôles intégrés ne peuvent pas être supprimés"
+			return Render(c, 422, views.AdminRoleForm(model))
+		}
+
+		users, err := db.Q(c).ListUsersByRole(c.Request().Context(), role.Name)
+		if err != nil {
+			logger.Log.Error().Err(err).Str("role", role.Name).Msg("Failed to list role users")
+			model.Errors.Global = "Une erreur inatendue lors de la suppression"
+			return Render(c, 422, views.AdminRoleForm(model))
+		}
+		if len(users) > 0 {
+			model.Errors.Global = fmt.Sprintf("Ce rôle est encore attribué à %d utilisateur(s)", len(users))
+			return Render(c, 422, views.AdminRoleForm(model))
+		}
+
+		if _, err := db.Q(c).DeleteRole(c.Request().Context(), role.Name); err != nil {
+			logger.Log.Error().Err(err).Str("role", role.Name).Msg("Failed to delete role")
+			model.Errors.Global = "Une erreur inatendue lors de la suppression"
+			return Render(c, 422, views.AdminRoleForm(model))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			model.Errors.Global = "Une erreur inatendue lors de la suppression"
+			return Render(c, 422, views.AdminRoleForm(model))
+		}
+
+		logger.Log.Info().Str("role", role.Name).Msg("Role deleted")
+
+		return c.NoContent(200)
+	})
+}
+
+func validatePermissions(model *views.AdminRoleFormModel, granted []string) {
+	for _, permission := range granted {
+		if !permissions.IsValid(permission) {
+			model.Errors.Fields["Permissions"] = "Permission inconnue : " + permission
+			return
+		}
+	}
+}
+
+// nonNilPermissions avoids storing a null array when no permission is checked
+func nonNilPermissions(granted []string) []string {
+	if granted == nil {
+		return []string{}
+	}
+	return granted
+}
+
+func init() {
+	roleNameRegex := regexp.MustCompile(`^[a-z0-9_-]{2,50}$`)
+	customValidations["role_name"] = CustomValidation{
+		Message: "L'identifiant ne doit contenir que des minuscules, chiffres, - ou _",
+		Validate: func(fl validator.FieldLevel) bool {
+			return roleNameRegex.MatchString(fl.Field().String())
+		},
+	}
+
+	customValidations["uniq_role"] = CustomValidation{
+		Message: "Un rôle utilisant cet identifiant existe déjà",
+		ValidateCtx: func(c context.Context, fl validator.FieldLevel) bool {
+			_, err := db.Qtempl(c).GetRole(c, fl.Field().String())
+			if errors.Is(err, pgx.ErrNoRows) {
+				return true
+			} else if err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to get role by name")
+			}
+			return false
+		},
+	}
+}
@@ -7,16 +7,19 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"os"
-	"path"
+	"reflect"
 	"regexp"
 	"time"
 	"woody-wood-portail/cmd/config"
 	ctx "woody-wood-portail/cmd/ctx/auth"
 	"woody-wood-portail/cmd/logger"
 	"woody-wood-portail/cmd/services/auth"
+	"woody-wood-portail/cmd/services/cookies"
 	"woody-wood-portail/cmd/services/db"
 	"woody-wood-portail/cmd/services/mails"
+	"woody-wood-portail/cmd/services/permissions"
+	"woody-wood-portail/cmd/services/proofs"
+	"woody-wood-portail/cmd/services/throttle"
 	"woody-wood-portail/views"
 	components "woody-wood-portail/views/components"
 	"woody-wood-portail/views/emails"
@@ -25,6 +28,7 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/labstack/echo/v4"
 )
 
@@ -42,7 +46,7 @@ func RequireAuthGroup(e *echo.Echo) RequireAuth {
 			logger.Log.Debug().Err(err).Msg("not verified, redirecting to /verify")
 			RedirectWitQuery(c, "/verify")
 		} else if errors.Is(err, auth.ErrRegistrationNotAccepted) {
-			if c.Get("user").(db.User).RegistrationState == "suspended" {
+			if state := c.Get("user").(db.User).RegistrationState; state == "suspended" || state == "renewal_pending" {
 				logger.Log.Debug().Err(err).Msg("registration not accepted, redirecting to /renew-registration")
 				RedirectWitQuery(c, "/renew-registration")
 			} else {
@@ -59,6 +63,8 @@ func RequireAuthGroup(e *echo.Echo) RequireAuth {
 		return err
 	}))
 
+	requireAuth.Use(impersonationMiddleware)
+
 	return RequireAuth{requireAuth}
 }
 
@@ -78,7 +84,14 @@ func RegisterAuthHandlers(e *echo.Echo) {
 			return RedirectWitQuery(c, "/renew-registration")
 		}
 
-		return Render(c, 200, views.RegisterPage(c.QueryParam("code")))
+		code := c.QueryParam("code")
+		apartment := ""
+		// The apartment assigned by the code is shown, it replaces the one entered anyway
+		if invitation, err := db.Q(c).GetValidInvitationCode(c.Request().Context(), code); err == nil && invitation.Apartment.Valid {
+			apartment = invitation.Apartment.String
+		}
+
+		return Render(c, 200, views.RegisterPage(code, apartment))
 	})
 
 	authGroup.GET("/login", func(c echo.Context) error {
@@ -96,10 +109,9 @@ func RegisterAuthHandlers(e *echo.Echo) {
 
 		model := components.NewFormModel(rawValues, Validate(c, values))
 
-		addressProofFile, err := c.FormFile("AddressProofFile")
-		if err != nil {
-			logger.Log.Err(err).Msg("failed to get address proof file from form")
-			model.Errors.Fields["AddressProofFile"] = "Le justificatif de domicile est obligatoire"
+		addressProofs, addressProofsErr := readAddressProofs(c)
+		if addressProofsErr != "" {
+			model.Errors.Fields["AddressProofFile"] = addressProofsErr
 		}
 
 		if len(model.Errors.Fields) > 0 {
@@ -127,36 +139,16 @@ func RegisterAuthHandlers(e *echo.Echo) {
 			return Render(c, 422, views.RegisterForm(model))
 		}
 
-		userAddressProofSrc, err := addressProofFile.Open()
+		newUser, err = applyInvitationCode(c, newUser)
 		if err != nil {
-			logger.Log.Error().Err(err).Msg("Failed to upload user address proofs dir")
-			model.Errors.Global = "Erreur inatendue durant l'upload du document"
-			return Render(c, 422, views.RegisterForm(model))
-		}
-		defer userAddressProofSrc.Close()
-
-		userAddressProofsDir := addressProofDir(newUser.ID)
-		if err := os.MkdirAll(userAddressProofsDir, 0755); err != nil {
-			logger.Log.Error().Err(err).Str("path", userAddressProofsDir).Msg("Failed to create user address proofs dir")
-			model.Errors.Global = "Erreur inatendue durant l'upload du document"
-			return Render(c, 422, views.RegisterForm(model))
-		}
-
-		userAddressProofPath := path.Join(userAddressProofsDir, addressProofFile.Filename)
-		userAddressProofsDst, err := os.Create(userAddressProofPath)
-		if err != nil {
-			logger.Log.Error().Err(err).Str("path", userAddressProofPath).Msg("Failed to create user address proofs file")
-			model.Errors.Global = "Erreur inatendue durant l'upload du document"
+			logger.Log.Error().Err(err).Msg("Unable to apply invitation code")
+			model.Errors.Global = "Erreur inatendue"
 			return Render(c, 422, views.RegisterForm(model))
 		}
-		defer userAddressProofsDst.Close()
 
-		if _, err := io.Copy(userAddressProofsDst, userAddressProofSrc); err != nil {
-			logger.Log.Error().Err(err).Str("path", userAddressProofPath).Msg("Failed to copy proofs file")
+		if err := saveAddressProofs(c.Request().Context(), newUser.ID, addressProofs); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to save user address proof")
 			model.Errors.Global = "Erreur inatendue durant l'upload du document"
-			if err := os.RemoveAll(userAddressProofsDir); err != nil {
-				logger.Log.Err(err).Str("dir", userAddressProofsDir).Msg("failed to clean up user address proof dir")
-			}
 			return Render(c, 422, views.RegisterForm(model))
 		}
 
@@ -200,6 +192,7 @@ func RegisterAuthHandlers(e *echo.Echo) {
 		user, err := auth.ParseToken(c, verificationToken,
 			auth.WithAudience(auth.EmailVerificationAudience),
 			auth.IssuedAfterLastUserUpdate(2*time.Second),
+			auth.SingleUse(c),
 		)
 		if err != nil {
 			logger.Log.Error().Str("code", verificationToken).Err(err).Msg("Unable to verify user email")
@@ -260,10 +253,41 @@ func RegisterAuthHandlers(e *echo.Echo) {
 			return Render(c, 422, views.LoginForm(components.NewFormError("Erreur inatendue")))
 		}
 		model := components.NewFormModel(rawValues, Validate(c, values))
+		if model.HasError() {
+			return Render(c, 422, views.LoginForm(model))
+		}
+
+		ipFailures, err := countRecentIPAttempts(c, throttle.KindLoginFailure)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to count failed logins")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.LoginForm(model))
+		}
+		if ipFailures >= int64(config.Config.Users.Login.MaxIPFailures) {
+			logger.Log.Warn().Str("ip", c.RealIP()).Int64("failures", ipFailures).Msg("Too many failed logins from this IP")
+			model.Errors.Global = "Trop de tentatives de connexion, veuillez réessayer plus tard"
+			return Render(c, 422, views.LoginForm(model))
+		}
 
 		user, err := db.Q(c).GetUserByEmail(c.Request().Context(), values.Email)
 		if err != nil {
-			model.Errors.Fields["Email"] = "Cet email n'existe pas"
+			auth.SimulatePasswordCheck(values.Password)
+			loginFailed(c, nil, values.Email, ipFailures)
+			model.Errors.Global = invalidCredentialsMessage
+			return Render(c, 422, views.LoginForm(model))
+		}
+
+		locked, err := isAccountLocked(c, user.ID)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to check account lockout")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.LoginForm(model))
+		}
+		if locked {
+			logger.Log.Info().Stringer("user", user.ID).Msg("Login attempt on a locked account")
+			auth.SimulatePasswordCheck(values.Password)
+			loginFailed(c, nil, values.Email, ipFailures)
+			model.Errors.Global = invalidCredentialsMessage
 			return Render(c, 422, views.LoginForm(model))
 		}
 
@@ -274,21 +298,38 @@ func RegisterAuthHandlers(e *echo.Echo) {
 			return Render(c, 422, views.LoginForm(model))
 		}
 		if !ok {
-			model.Errors.Fields["Password"] = "Mot de passe invalide"
+			loginFailed(c, &user, values.Email, ipFailures)
+			model.Errors.Global = invalidCredentialsMessage
 			return Render(c, 422, views.LoginForm(model))
 		}
 
+		if err := db.QGlobal().DeleteLoginLockout(c.Request().Context(), user.ID); err != nil {
+			logger.Log.Error().Err(err).Stringer("user", user.ID).Msg("Unable to reset failed logins")
+		}
+
+		// The clear password is only known now, it's the only time the hash can be upgraded
+		if auth.NeedsRehash(user) {
+			if err := auth.RehashPassword(c.Request().Context(), user, values.Password); err != nil {
+				logger.Log.Error().Err(err).Stringer("user", user.ID).Msg("Unable to upgrade password hash")
+			}
+		}
+
+		if user.TotpEnabled {
+			if err := addTOTPPendingCookie(c, user.ID); err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to add two-factor cookie")
+				model.Errors.Global = "Erreur inatendue"
+				return Render(c, 422, views.LoginForm(model))
+			}
+			return RedirectWitQuery(c, "/login/totp")
+		}
+
 		if err := addAuthenticationCookie(c, user.ID); err != nil {
 			logger.Log.Error().Err(err).Msg("Unable to add authentication cookie")
 			model.Errors.Global = "Erreur inatendue"
 			return Render(c, 422, views.LoginForm(model))
 		}
 
-		redirect := c.QueryParam("redirect")
-		if redirect != "" {
-			Redirect(c, redirect)
-		}
-		return RedirectWitQuery(c, "/user/")
+		return Redirect(c, localRedirect(c.QueryParam("redirect"), "/user/"))
 	})
 
 	authGroup.GET("/pending-registration", func(c echo.Context) error {
@@ -306,6 +347,22 @@ func RegisterAuthHandlers(e *echo.Echo) {
 		}
 
 		if currentUser.RegistrationState == "new" {
+			approved, err := autoApproveRegistration(c, currentUser)
+			if err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to approve registration")
+				return Render(c, 200, views.FailedRegistrationPage())
+			}
+			if approved {
+				if err := db.Commit(c); err != nil {
+					logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+					return Render(c, 200, views.FailedRegistrationPage())
+				}
+				if err := proofs.Delete(c.Request().Context(), currentUser.ID); err != nil {
+					logger.Log.Err(err).Stringer("user", currentUser.ID).Msg("failed to clean up address proof")
+				}
+				return Redirect(c, "/user/")
+			}
+
 			if err := sendRegistrationRequestMail(c, currentUser); err != nil {
 				logger.Log.Error().Err(err).Msg("Unable to send registration request")
 				return Render(c, 200, views.FailedRegistrationPage())
@@ -315,16 +372,97 @@ func RegisterAuthHandlers(e *echo.Echo) {
 				logger.Log.Error().Err(err).Msg("Unable to set registration state to pending")
 				return Render(c, 200, views.FailedRegistrationPage())
 			}
+
+			if err := db.Commit(c); err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+				return Render(c, 200, views.FailedRegistrationPage())
+			}
 		}
 
 		return Render(c, 200, views.PendingRegistrationPage())
 	})
 
+	authGroup.PUT("/pending-registration", func(c echo.Context) error {
+		if !ctx.IsAuthenticated(c) {
+			return RedirectWitQuery(c, "/login")
+		}
+		currentUser := ctx.GetUserFromEcho(c)
+
+		if currentUser.RegistrationState != "rejected" || !currentUser.ResubmissionAllowed {
+			return Redirect(c, "/pending-registration")
+		}
+
+		values, rawValues, err := Bind[views.ResubmitRegistrationFormValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to get form params")
+			return Render(c, 422, views.ResubmitRegistrationForm(components.NewFormError("Erreur inatendue")))
+		}
+
+		model := components.NewFormModel(rawValues, Validate(c, values))
+
+		addressProofs, addressProofsErr := readAddressProofs(c)
+		if addressProofsErr != "" {
+			model.Errors.Fields["AddressProofFile"] = addressProofsErr
+		}
+
+		if len(model.Errors.Fields) != 0 {
+			return Render(c, 422, views.ResubmitRegistrationForm(model))
+		}
+
+		// The documents of the rejected request are normally already deleted, only keep the new ones once saved
+		previous, err := proofs.List(c.Request().Context(), currentUser.ID)
+		if err != nil {
+			logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("failed to list previous address proofs")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.ResubmitRegistrationForm(model))
+		}
+
+		if err := saveAddressProofs(c.Request().Context(), currentUser.ID, addressProofs); err != nil {
+			logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("failed to save resubmitted address proofs")
+			model.Errors.Global = "Erreur inatendue durant l'upload du document"
+			return Render(c, 422, views.ResubmitRegistrationForm(model))
+		}
+
+		user, err := db.Q(c).ResubmitRegistration(c.Request().Context(), db.ResubmitRegistrationParams{
+			ID:        currentUser.ID,
+			FullName:  values.FullName,
+			Apartment: values.Apartment,
+		})
+		if err != nil {
+			logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("failed to resubmit registration")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.ResubmitRegistrationForm(model))
+		}
+
+		if err := sendRegistrationRequestMail(c, user); err != nil {
+			logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("Unable to send registration request")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.ResubmitRegistrationForm(model))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.ResubmitRegistrationForm(model))
+		}
+
+		if err := proofs.DeleteDocuments(c.Request().Context(), currentUser.ID, previous); err != nil {
+			logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("failed to remove previous address proofs")
+		}
+
+		logger.Log.Info().Stringer("user", currentUser.ID).Msg("registration resubmitted")
+		return Redirect(c, "/pending-registration")
+	})
+
 	authGroup.GET("/renew-registration", func(c echo.Context) error {
 		if !ctx.IsAuthenticated(c) {
 			return RedirectWitQuery(c, "/login")
 		}
 
+		if ctx.GetUserFromEcho(c).RenewalRequestedAt.Valid {
+			return Render(c, 200, views.RenewalPendingPage())
+		}
+
 		return Render(c, 200, views.RegistrationRenewalPage(c.QueryParam("code")))
 	})
 
@@ -342,6 +480,11 @@ func RegisterAuthHandlers(e *echo.Echo) {
 		}
 
 		model := components.NewFormModel(rawValues, Validate(c, values))
+
+		if config.Config.Users.RenewalReview {
+			return requestRenewalReview(c, currentUser, model)
+		}
+
 		if len(model.Errors.Fields) != 0 {
 			return Render(c, 422, views.RegistrationRenewalForm(model))
 		}
@@ -352,6 +495,12 @@ func RegisterAuthHandlers(e *echo.Echo) {
 			return Render(c, 422, views.RegistrationRenewalForm(model))
 		}
 
+		if err := recordInvitationCode(c, currentUser); err != nil {
+			logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("failed to record invitation code")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.RegistrationRenewalForm(model))
+		}
+
 		if err := mails.SendMail(c.Request().Context(), currentUser,
 			"Inscription à Woody Wood Gate renouvellée",
 			emails.RegistrationRenewed(),
@@ -395,13 +544,46 @@ func RegisterAuthHandlers(e *echo.Echo) {
 			return Render(c, 422, views.PasswordForgottenForm(model))
 		}
 
-		user, err := db.Q(c).GetUserByEmail(c.Request().Context(), values.Email)
+		ipRequests, err := countRecentIPAttempts(c, throttle.KindPasswordReset)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to count password reset requests")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.PasswordForgottenForm(model))
+		}
+		if ipRequests >= int64(config.Config.Users.Login.MaxIPResetMails) {
+			logger.Log.Warn().Str("ip", c.RealIP()).Int64("requests", ipRequests).Msg("Too many password reset requests from this IP")
+			model.Errors.Global = "Trop de demandes, veuillez réessayer plus tard"
+			return Render(c, 422, views.PasswordForgottenForm(model))
+		}
+
+		recentMails, err := db.QGlobal().CountAuthAttemptsByEmail(c.Request().Context(), db.CountAuthAttemptsByEmailParams{
+			Kind:      throttle.KindPasswordReset,
+			Email:     values.Email,
+			CreatedAt: pgtype.Timestamp{Time: time.Now().Add(-config.Config.Users.Login.ResetMailInterval), Valid: true},
+		})
 		if err != nil {
-			model.Errors.Fields["Email"] = "Cet email n'existe pas"
+			logger.Log.Error().Err(err).Msg("Unable to count password reset requests")
+			model.Errors.Global = "Erreur inatendue"
 			return Render(c, 422, views.PasswordForgottenForm(model))
 		}
 
-		resetToken, err := auth.CreateToken(user.ID, auth.ResetPasswordAudience)
+		recordAuthAttempt(c, throttle.KindPasswordReset, values.Email)
+
+		// The answer is the same whether the account exists or not, to not reveal which emails are registered
+		model.EmailSent = true
+
+		if recentMails > 0 {
+			logger.Log.Info().Msg("Password reset email already sent recently, not sending again")
+			return Render(c, 200, views.PasswordForgottenForm(model))
+		}
+
+		user, err := db.Q(c).GetUserByEmail(c.Request().Context(), values.Email)
+		if err != nil {
+			logger.Log.Info().Err(err).Msg("Password reset requested for an unknown email")
+			return Render(c, 200, views.PasswordForgottenForm(model))
+		}
+
+		resetToken, err := auth.CreateSingleUseToken(user.ID, auth.ResetPasswordAudience, config.Config.Http.JWT.ResetPasswordTTL)
 		if err != nil {
 			logger.Log.Error().Err(err).Msg("Unable to create reset token")
 			model.Errors.Global = "Erreur inatendue"
@@ -417,12 +599,8 @@ func RegisterAuthHandlers(e *echo.Echo) {
 		)
 		if err != nil {
 			logger.Log.Error().Err(err).Msg("Unable to send password reset email")
-			model.Errors.Global = "Erreur inatendue"
-			return Render(c, 422, views.PasswordForgottenForm(model))
 		}
 
-		model.EmailSent = true
-
 		return Render(c, 200, views.PasswordForgottenForm(model))
 	})
 
@@ -435,6 +613,7 @@ func RegisterAuthHandlers(e *echo.Echo) {
 		if _, err := auth.ParseToken(c, code,
 			auth.WithAudience(auth.ResetPasswordAudience),
 			auth.IssuedAfterLastUserUpdate(0),
+			auth.NotUsed(c),
 		); err != nil {
 			logger.Log.Error().Str("code", code).Err(err).Msg("Unable to reset password")
 			return Redirect(c, "/password-forgotten?error="+url.QueryEscape("Code de réinitialisation invalide"))
@@ -452,6 +631,7 @@ func RegisterAuthHandlers(e *echo.Echo) {
 		user, err := auth.ParseToken(c, code,
 			auth.WithAudience(auth.ResetPasswordAudience),
 			auth.IssuedAfterLastUserUpdate(0),
+			auth.SingleUse(c),
 		)
 		if err != nil {
 			logger.Log.Error().Str("code", code).Err(err).Msg("Unable to reset password")
@@ -486,8 +666,38 @@ func RegisterAuthHandlers(e *echo.Echo) {
 			return Render(c, 422, views.ResetPasswordForm(model))
 		}
 
+		// Receiving the reset email proves the ownership of the account, it doesn't have to wait for the lockout to end
+		if err := db.Q(c).DeleteLoginLockout(c.Request().Context(), user.ID); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to unlock account")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.ResetPasswordForm(model))
+		}
+
+		// Someone else may know the old password, sign out all devices
+		if _, err := db.Q(c).RevokeUserSessions(c.Request().Context(), user.ID); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to revoke sessions")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.ResetPasswordForm(model))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.ResetPasswordForm(model))
+		}
+
 		logger.Log.Info().Stringer("user", user.ID).Msg("Password reset")
 
+		// The reset email only proves access to the mailbox, the second factor is still required
+		if user.TotpEnabled {
+			if err := addTOTPPendingCookie(c, user.ID); err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to add two-factor cookie")
+				model.Errors.Global = "Erreur inatendue"
+				return Render(c, 422, views.ResetPasswordForm(model))
+			}
+			return Redirect(c, "/login/totp")
+		}
+
 		if err := addAuthenticationCookie(c, user.ID); err != nil {
 			logger.Log.Error().Err(err).Msg("Unable to add authentication cookie")
 			model.Errors.Global = "Erreur inatendue"
@@ -497,14 +707,96 @@ func RegisterAuthHandlers(e *echo.Echo) {
 		return Redirect(c, "/user/")
 	})
 
+	registerPasskeyLoginHandlers(authGroup)
+	registerTOTPLoginHandlers(authGroup)
+	registerMagicLinkHandlers(authGroup)
+	registerEmailChangeHandlers(authGroup)
+	registerOIDCHandlers(authGroup)
+
 	e.GET("/logout", func(c echo.Context) error {
+		if cookie, err := c.Cookie("authorization"); err == nil {
+			endImpersonationOnLogout(c, cookie.Value)
+			revokeSessionOfToken(c, cookie.Value)
+		}
 		c.SetCookie(createCookie("", -1))
 		return RedirectWitQuery(c, "/login")
 	})
 }
 
+type addressProofUpload struct {
+	Filename string
+	Content  []byte
+}
+
+// readAddressProofs reads and checks the address proof documents uploaded with a registration form,
+// the error message is displayed to the user
+func readAddressProofs(c echo.Context) ([]addressProofUpload, string) {
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["AddressProofFile"]) == 0 {
+		logger.Log.Err(err).Msg("failed to get address proof file from form")
+		return nil, "Le justificatif de domicile est obligatoire"
+	}
+
+	files := form.File["AddressProofFile"]
+	if len(files) > config.Config.Users.AddressProofMaxFiles {
+		return nil, fmt.Sprintf("Vous pouvez envoyer au plus %d documents", config.Config.Users.AddressProofMaxFiles)
+	}
+
+	maxSizeMB := (config.Config.Users.AddressProofMaxSize + 1<<20 - 1) >> 20
+	uploads := make([]addressProofUpload, 0, len(files))
+	for _, file := range files {
+		// Checked before reading the file, and again on the content since the announced size can be wrong
+		if file.Size > config.Config.Users.AddressProofMaxSize {
+			return nil, fmt.Sprintf("Le document %s dépasse la taille maximale de %d Mo", file.Filename, maxSizeMB)
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			logger.Log.Err(err).Msg("failed to open uploaded address proof")
+			return nil, "Impossible de lire le document " + file.Filename
+		}
+		content, err := io.ReadAll(io.LimitReader(src, config.Config.Users.AddressProofMaxSize+1))
+		src.Close()
+		if err != nil {
+			logger.Log.Err(err).Msg("failed to read uploaded address proof")
+			return nil, "Impossible de lire le document " + file.Filename
+		}
+
+		switch _, err := proofs.Validate(content); {
+		case errors.Is(err, proofs.ErrTooLarge):
+			return nil, fmt.Sprintf("Le document %s dépasse la taille maximale de %d Mo", file.Filename, maxSizeMB)
+		case errors.Is(err, proofs.ErrUnsupportedType):
+			return nil, fmt.Sprintf("Le document %s doit être au format %s", file.Filename, proofs.AllowedTypesLabel())
+		}
+
+		uploads = append(uploads, addressProofUpload{Filename: file.Filename, Content: content})
+	}
+
+	return uploads, ""
+}
+
+// saveAddressProofs stores the address proofs uploaded by a new user, for the admins to review the registration
+func saveAddressProofs(c context.Context, userID uuid.UUID, uploads []addressProofUpload) error {
+	for _, upload := range uploads {
+		if _, err := proofs.Save(c, userID, upload.Filename, upload.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func addAuthenticationCookie(c echo.Context, userID uuid.UUID) error {
-	token, err := auth.CreateToken(userID, auth.AuthAudience)
+	// The session is saved outside of the request transaction, so that it exists even if the handler commits later
+	session, err := db.QGlobal().CreateSession(c.Request().Context(), db.CreateSessionParams{
+		UserID:    userID,
+		UserAgent: c.Request().UserAgent(),
+		Ip:        c.RealIP(),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create session: %w", err)
+	}
+
+	token, err := auth.CreateSessionToken(session)
 	if err != nil {
 		return fmt.Errorf("unable to create authentication token: %w", err)
 	}
@@ -514,16 +806,11 @@ func addAuthenticationCookie(c echo.Context, userID uuid.UUID) error {
 }
 
 func createCookie(token string, maxAge int) *http.Cookie {
-	return &http.Cookie{
-		Name:     "authorization",
-		Value:    token,
-		HttpOnly: true,
-		MaxAge:   maxAge * 24 * 60 * 60,
-	}
+	return cookies.New("authorization", token, maxAge*24*60*60)
 }
 
 func sendVerificationMail(c echo.Context, user db.User) error {
-	mailVerifToken, err := auth.CreateToken(user.ID, auth.EmailVerificationAudience)
+	mailVerifToken, err := auth.CreateSingleUseToken(user.ID, auth.EmailVerificationAudience, config.Config.Http.JWT.EmailVerificationTTL)
 	if err != nil {
 		return fmt.Errorf("unable to create email verification token: %w", err)
 	}
@@ -542,8 +829,93 @@ func sendVerificationMail(c echo.Context, user db.User) error {
 	return nil
 }
 
+// requestRenewalReview saves the new address proofs of a registration renewal, for the admins to review them
+func requestRenewalReview(c echo.Context, currentUser db.User, model components.FormModel) error {
+	addressProofs, addressProofsErr := readAddressProofs(c)
+	if addressProofsErr != "" {
+		model.Errors.Fields["AddressProofFile"] = addressProofsErr
+	}
+
+	if len(model.Errors.Fields) != 0 {
+		return Render(c, 422, views.RegistrationRenewalForm(model))
+	}
+
+	// Only the proofs of the pending request are kept, the previous ones are deleted once the request is saved
+	previous, err := proofs.List(c.Request().Context(), currentUser.ID)
+	if err != nil {
+		logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("failed to list previous address proofs")
+		model.Errors.Global = "Erreur inatendue"
+		return Render(c, 422, views.RegistrationRenewalForm(model))
+	}
+
+	if err := saveAddressProofs(c.Request().Context(), currentUser.ID, addressProofs); err != nil {
+		logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("failed to save renewal address proofs")
+		model.Errors.Global = "Erreur inatendue durant l'upload du document"
+		return Render(c, 422, views.RegistrationRenewalForm(model))
+	}
+
+	user, err := db.Q(c).RequestRenewal(c.Request().Context(), currentUser.ID)
+	if err != nil {
+		logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("failed to save renewal request")
+		model.Errors.Global = "Erreur inatendue"
+		return Render(c, 422, views.RegistrationRenewalForm(model))
+	}
+
+	if err := recordInvitationCode(c, user); err != nil {
+		logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("failed to record invitation code")
+		model.Errors.Global = "Erreur inatendue"
+		return Render(c, 422, views.RegistrationRenewalForm(model))
+	}
+
+	if err := sendRenewalRequestMail(c, user); err != nil {
+		logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("failed to send renewal request")
+		model.Errors.Global = "Erreur inatendue"
+		return Render(c, 422, views.RegistrationRenewalForm(model))
+	}
+
+	if err := db.Commit(c); err != nil {
+		logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("failed to commit while requesting registration renewal")
+		model.Errors.Global = "Erreur inatendue"
+		return Render(c, 422, views.RegistrationRenewalForm(model))
+	}
+
+	if err := proofs.DeleteDocuments(c.Request().Context(), currentUser.ID, previous); err != nil {
+		logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("failed to remove previous address proofs")
+		// The request is saved, the previous proofs are removed by the retention purge otherwise
+	}
+
+	return Redirect(c, "/renew-registration")
+}
+
+func sendRenewalRequestMail(c echo.Context, user db.User) error {
+	admins, err := db.Q(c).ListUsersWithPermission(c.Request().Context(), string(permissions.ApproveRegistrations))
+	if err != nil {
+		return fmt.Errorf("unable to list admins: %w", err)
+	}
+
+	adminURL := fmt.Sprintf("%s/admin/users", config.Config.Http.BaseURL)
+
+	sent := 0
+	for _, admin := range admins {
+		if err := mails.SendMail(c.Request().Context(),
+			admin,
+			"Nouvelle demande de renouvellement sur Woody Wood Gate",
+			emails.RenewalRequest(user, templ.SafeURL(adminURL)),
+		); err != nil {
+			logger.Log.Error().Err(err).Stringer("admin", admin.ID).Msg("Unable to send renewal request")
+			continue
+		}
+		sent++
+	}
+
+	if sent == 0 && len(admins) > 0 {
+		return fmt.Errorf("unable to send renewal request email: all mail tentative failed")
+	}
+	return nil
+}
+
 func sendRegistrationRequestMail(c echo.Context, user db.User) error {
-	admins, err := db.Q(c).ListUsersByRole(c.Request().Context(), "admin")
+	admins, err := db.Q(c).ListUsersWithPermission(c.Request().Context(), string(permissions.ApproveRegistrations))
 	if err != nil {
 		return fmt.Errorf("unable to list admins: %w", err)
 	}
@@ -604,19 +976,56 @@ func init() {
 		},
 	}
 
-	customValidations["invitation_code"] = CustomValidation{
-		Message: "Code d'invitation invalide",
-		ValidateCtx: func(c context.Context, fl validator.FieldLevel) bool {
-			code, err := db.Qtempl(c).GetRegistrationCode(c)
-			if err == pgx.ErrNoRows {
-				logger.Log.Info().Msg("No registration code found, allowing registration")
-				return true
-			}
+	customValidations["password_length"] = CustomValidation{
+		Message: fmt.Sprintf("{0} doit contenir au moins %d caractères", config.Config.Users.Password.MinLength),
+		Validate: func(fl validator.FieldLevel) bool {
+			return auth.PasswordLongEnough(fl.Field().String())
+		},
+	}
+
+	customValidations["password_strength"] = CustomValidation{
+		Message: "Ce mot de passe est trop facile à deviner, ajoutez des mots ou des caractères moins prévisibles",
+		Validate: func(fl validator.FieldLevel) bool {
+			return auth.PasswordStrongEnough(fl.Field().String(), passwordUserInputs(fl)...)
+		},
+	}
+
+	customValidations["not_breached"] = CustomValidation{
+		Message: "Ce mot de passe apparaît dans des fuites de données connues, veuillez en choisir un autre",
+		Validate: func(fl validator.FieldLevel) bool {
+			breached, err := auth.PasswordBreached(fl.Field().String())
 			if err != nil {
-				logger.Log.Error().Err(err).Msg("Unable to get registration code")
-				return false
+				// An unreadable list should not prevent users from registering or resetting their password
+				logger.Log.Error().Err(err).Msg("Unable to check breached passwords")
+				return true
 			}
-			return fl.Field().String() == code
+			return !breached
+		},
+	}
+
+	customValidations["invitation_code"] = CustomValidation{
+		Message: "Code d'invitation invalide ou expiré",
+		ValidateCtx: func(c context.Context, fl validator.FieldLevel) bool {
+			return consumeInvitationCode(c, fl.Field().String())
 		},
 	}
 }
+
+// passwordUserInputs returns the personal information filled in the same form, which should not be used in the password
+func passwordUserInputs(fl validator.FieldLevel) []string {
+	parent := fl.Parent()
+	if parent.Kind() == reflect.Pointer {
+		parent = parent.Elem()
+	}
+	if parent.Kind() != reflect.Struct {
+		return nil
+	}
+
+	inputs := []string{}
+	for _, name := range []string{"Email", "FullName", "Apartment"} {
+		if field := parent.FieldByName(name); field.IsValid() && field.Kind() == reflect.String && field.String() != "" {
+			inputs = append(inputs, field.String())
+		}
+	}
+	return inputs
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/decisions"
+	"woody-wood-portail/cmd/services/mails"
+	"woody-wood-portail/cmd/services/proofs"
+	"woody-wood-portail/views"
+	"woody-wood-portail/views/emails"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+func registerDecisionHandlers(registrationsGroup *echo.Group) {
+	registrationsGroup.PUT("/:id/suspend", func(c echo.Context) error {
+		model := &views.AdminUserRowModel{}
+
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			model.Err = errors.New("id d'utilisateur invalide")
+			return Render(c, 422, views.AdminUserSuspension(model))
+		}
+
+		model.User, err = db.Q(c).GetUser(c.Request().Context(), userID)
+		if err != nil {
+			model.Err = errors.New("utilisateur introuvable")
+			return Render(c, 422, views.AdminUserSuspension(model))
+		}
+
+		decision, err := readDecision(c)
+		if err != nil {
+			model.Err = err
+			return Render(c, 422, views.AdminUserSuspension(model))
+		}
+
+		reason, comment := decisions.Params(decision.Reason, decision.Comment)
+		model.User, err = db.Q(c).RegistrationSuspendedByAdmin(c.Request().Context(), db.RegistrationSuspendedByAdminParams{
+			ID:              userID,
+			DecisionReason:  reason,
+			DecisionComment: comment,
+		})
+		if err != nil {
+			model.Err = errors.New("seuls les comptes actifs peuvent être suspendus")
+			logger.Log.Error().Err(err).Stringer("user", userID).Msg("Failed to suspend user")
+			return Render(c, 422, views.AdminUserSuspension(model))
+		}
+
+		if err := db.Commit(c); err != nil {
+			model.Err = errors.New("échec de l'enregistrement")
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			return Render(c, 422, views.AdminUserSuspension(model))
+		}
+
+		// The account is suspended, the proofs of a dropped renewal request are not needed anymore
+		if err := proofs.Delete(c.Request().Context(), userID); err != nil {
+			logger.Log.Err(err).Stringer("user", userID).Msg("failed to clean up address proof")
+			// The proofs are removed by the retention purge otherwise
+		}
+
+		if err := mails.SendMail(c.Request().Context(), model.User,
+			"Votre compte Woody Wood Gate a été suspendu",
+			emails.RegistrationSuspendedByAdmin(model.User),
+		); err != nil {
+			logger.Log.Error().Err(err).Stringer("user", userID).Msg("failed to send suspension email")
+			// Don't report this error, the suspension is saved
+		}
+
+		logger.Log.Info().Stringer("user", userID).Str("reason", decision.Reason).Msg("user account suspended by an admin")
+		return Render(c, 200, views.AdminUserSuspension(model))
+	})
+}
+
+// readDecision reads the reason given by an admin to reject or suspend a registration, the returned error can be
+// shown as is
+func readDecision(c echo.Context) (*views.DecisionFormValues, error) {
+	values, _, err := Bind[views.DecisionFormValues](c)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("Unable to get decision params")
+		return nil, errors.New("motif invalide")
+	}
+
+	if fieldErrors := Validate(c, values); len(fieldErrors) != 0 {
+		messages := make([]string, 0, len(fieldErrors))
+		for _, message := range fieldErrors {
+			messages = append(messages, message)
+		}
+		sort.Strings(messages)
+		return nil, errors.New(strings.Join(messages, ", "))
+	}
+
+	return values, nil
+}
+
+func init() {
+	customValidations["decision_reason"] = CustomValidation{
+		Message: "Motif inconnu",
+		Validate: func(fl validator.FieldLevel) bool {
+			return decisions.Valid(fl.Field().String())
+		},
+	}
+
+	customValidations["decision_comment"] = CustomValidation{
+		Message: "Veuillez préciser le motif",
+		Validate: func(fl validator.FieldLevel) bool {
+			parent := fl.Parent()
+			if parent.Kind() == reflect.Pointer {
+				parent = parent.Elem()
+			}
+			return fl.Field().String() != "" || parent.FieldByName("Reason").String() != decisions.Other
+		},
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"woody-wood-portail/cmd/config"
+	ctx "woody-wood-portail/cmd/ctx/auth"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/auth"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/mails"
+	"woody-wood-portail/views"
+	"woody-wood-portail/views/components"
+	"woody-wood-portail/views/emails"
+
+	"github.com/a-h/templ"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+func registerEmailChangeUserHandlers(userRoutes *echo.Group) {
+	userRoutes.GET("/email", func(c echo.Context) error {
+		return Render(c, 200, views.UserEmailPage(&views.UserEmailFormModel{FormModel: components.NewFormModel(nil, nil)}))
+	})
+
+	userRoutes.POST("/email", func(c echo.Context) error {
+		values, rawValues, err := Bind[views.UserEmailValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to bind values")
+			return Render(c, 422, views.UserEmailForm(&views.UserEmailFormModel{FormModel: components.NewFormError("Erreur inatendue", rawValues)}))
+		}
+
+		model := &views.UserEmailFormModel{FormModel: components.NewFormModel(rawValues, Validate(c, values))}
+		if model.HasError() {
+			return Render(c, 422, views.UserEmailForm(model))
+		}
+
+		currentUser := ctx.GetUserFromEcho(c)
+
+		if throttled, err := emailChangeThrottled(c, currentUser, values.Email); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to count email change requests")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserEmailForm(model))
+		} else if throttled {
+			model.Errors.Global = "Trop de demandes, veuillez réessayer plus tard"
+			return Render(c, 422, views.UserEmailForm(model))
+		}
+
+		ttl := config.Config.Http.JWT.EmailChangeTTL
+		changeToken, err := auth.CreateEmailChangeToken(currentUser.ID, values.Email, ttl)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to create email change token")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserEmailForm(model))
+		}
+
+		confirmURL := fmt.Sprintf("%s/email/confirm?code=%s", config.Config.Http.BaseURL, changeToken)
+
+		// The link goes to the new address, to prove that the user owns it
+		recipient := currentUser
+		recipient.Email = values.Email
+		if err := mails.SendMail(c.Request().Context(),
+			recipient,
+			"Confirmez votre nouvelle adresse email Woody Wood Gate",
+			emails.EmailChangeConfirmation(recipient, templ.SafeURL(confirmURL), ttl),
+		); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to send email change confirmation")
+			model.Errors.Global = "Erreur lors de l'envoi du mail de confirmation"
+			return Render(c, 422, views.UserEmailForm(model))
+		}
+
+		if err := mails.SendMail(c.Request().Context(),
+			currentUser,
+			"Changement d'adresse email sur Woody Wood Gate",
+			emails.EmailChangeRequested(currentUser, values.Email),
+		); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to send email change notice")
+		}
+
+		logger.Log.Info().Stringer("user", currentUser.ID).Msg("Email change requested")
+
+		return Render(c, 200, views.UserEmailForm(&views.UserEmailFormModel{
+			FormModel: components.NewFormModel(nil, nil),
+			SentTo:    values.Email,
+		}))
+	})
+}
+
+// registerEmailChangeHandlers registers the confirmation link, which can be opened without being logged in
+func registerEmailChangeHandlers(authGroup *echo.Group) {
+	authGroup.GET("/email/confirm", func(c echo.Context) error {
+		var newEmail string
+		user, err := auth.ParseToken(c, c.QueryParam("code"),
+			auth.WithAudience(auth.EmailChangeAudience),
+			auth.WithNewEmail(&newEmail),
+			auth.SingleUse(c),
+		)
+		if err != nil {
+			logger.Log.Info().Err(err).Msg("Invalid email change link")
+			return Render(c, 400, views.EmailChangedPage("Lien de confirmation invalide ou expiré, veuillez refaire votre demande."))
+		}
+
+		// The address may have been taken since the request
+		_, err = db.Q(c).GetUserByEmail(c.Request().Context(), newEmail)
+		if err == nil {
+			logger.Log.Info().Stringer("user", user.ID).Msg("Email change to an already used address")
+			return Render(c, 422, views.EmailChangedPage("Un compte utilisant cet email existe déjà"))
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			logger.Log.Error().Err(err).Msg("Unable to get user by email")
+			return Render(c, 500, views.EmailChangedPage("Erreur inatendue"))
+		}
+
+		if _, err := db.Q(c).UpdateUserEmail(c.Request().Context(), db.UpdateUserEmailParams{
+			ID:    user.ID,
+			Email: newEmail,
+		}); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to update email")
+			return Render(c, 500, views.EmailChangedPage("Erreur inatendue"))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+			return Render(c, 500, views.EmailChangedPage("Erreur inatendue"))
+		}
+
+		logger.Log.Info().Stringer("user", user.ID).Msg("Email changed")
+
+		return Render(c, 200, views.EmailChangedPage(""))
+	})
+}
@@ -2,18 +2,19 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path"
 	"strconv"
 	"strings"
 	"time"
 	"woody-wood-portail/cmd/config"
 	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/storage"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -37,10 +38,8 @@ func RegisterGateHandlers(e *echo.Echo, model *Model, openChannel chan struct{})
 			model.RunningVersion = runningVersion[0]
 		}
 
-		if currentVersion, err := getCurrentFirmwareVersion(); err != nil {
-			if !os.IsNotExist(err) {
-				logger.Log.Error().Err(err).Str("running version", model.RunningVersion).Msg("failed to get current firmware version, client will not be updated")
-			}
+		if currentVersion, err := getCurrentFirmwareVersion(c.Request().Context()); err != nil {
+			logger.Log.Error().Err(err).Str("running version", model.RunningVersion).Msg("failed to get current firmware version, client will not be updated")
 		} else if model.RunningVersion != "" && currentVersion != "none" && currentVersion != model.RunningVersion {
 			logger.Log.Info().Str("running version", model.RunningVersion).Str("current version", currentVersion).Msg("running version mismatch, instruct client to upgrade")
 			return c.NoContent(http.StatusUpgradeRequired)
@@ -61,7 +60,7 @@ func RegisterGateHandlers(e *echo.Echo, model *Model, openChannel chan struct{})
 
 	// Don't use the gate group to skip authentication, the firmware can be public
 	e.GET("/gate/firmware", func(c echo.Context) error {
-		firmwareVersion, err := getCurrentFirmwareVersion()
+		firmwareVersion, err := getCurrentFirmwareVersion(c.Request().Context())
 		if err != nil {
 			logger.Log.Error().Err(err).Msg("Failed to get firmware version")
 			return c.NoContent(500)
@@ -78,15 +77,15 @@ func RegisterGateHandlers(e *echo.Echo, model *Model, openChannel chan struct{})
 			}
 		}
 
-		firmwarePath := path.Join(config.Config.Gate.FirmwareDirectory, firmwareVersion+".bin")
-		firmwareFile, err := os.ReadFile(firmwarePath)
+		firmwareKey := firmwareVersion + ".bin"
+		firmwareFile, _, err := storage.Firmwares.Get(c.Request().Context(), firmwareKey)
 
 		if err != nil {
-			if os.IsNotExist(err) {
-				logger.Log.Warn().Str("firmware path", firmwarePath).Msg("No firmware uploaded yet")
+			if errors.Is(err, storage.ErrNotFound) {
+				logger.Log.Warn().Str("firmware", firmwareKey).Msg("No firmware uploaded yet")
 				return c.NoContent(304)
 			}
-			logger.Log.Error().Err(err).Str("firmware path", firmwarePath).Msg("Failed to open firmware file")
+			logger.Log.Error().Err(err).Str("firmware", firmwareKey).Msg("Failed to open firmware file")
 			return c.NoContent(500)
 		}
 
@@ -107,23 +106,19 @@ func RegisterGateHandlers(e *echo.Echo, model *Model, openChannel chan struct{})
 	})
 }
 
-func getCurrentFirmwareVersion() (string, error) {
-	if err := os.MkdirAll(config.Config.Gate.FirmwareDirectory, 0755); err != nil {
-		return "none", fmt.Errorf("failed to open firmware directory: %w", err)
-	}
-
-	dirEntries, err := os.ReadDir(config.Config.Gate.FirmwareDirectory)
+func getCurrentFirmwareVersion(c context.Context) (string, error) {
+	keys, err := storage.Firmwares.List(c, "")
 	if err != nil {
-		return "none", fmt.Errorf("failed to list firmware directory files: %w", err)
+		return "none", fmt.Errorf("failed to list firmware files: %w", err)
 	}
 
-	if len(dirEntries) > 1 {
-		return "none", fmt.Errorf("failed to determine firmware version, more than 1 file present int the firmware directory: %s", config.Config.Gate.FirmwareDirectory)
+	if len(keys) > 1 {
+		return "none", fmt.Errorf("failed to determine firmware version, more than 1 file present in the firmware storage: %v", keys)
 	}
 
-	if len(dirEntries) == 0 {
+	if len(keys) == 0 {
 		return "none", nil
 	}
 
-	return strings.Split(dirEntries[0].Name(), ".bin")[0], nil
+	return strings.Split(keys[0], ".bin")[0], nil
 }
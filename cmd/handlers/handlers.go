@@ -3,6 +3,8 @@ package handlers
 import (
 	"net/url"
 	"reflect"
+	"strings"
+	"unicode"
 	ctx "woody-wood-portail/cmd/ctx/auth"
 	"woody-wood-portail/cmd/logger"
 
@@ -44,6 +46,24 @@ func RedirectWitQuery(c echo.Context, url string) error {
 	return Redirect(c, url)
 }
 
+// localRedirect returns the page to continue to after a login, or the fallback when it is not a path of this site. It
+// prevents sending a freshly logged in user to another site.
+func localRedirect(redirect string, fallback string) string {
+	// Browsers drop the control characters and read backslashes as slashes, "/\t/site" or "/\\site" go to another host
+	if strings.ContainsFunc(redirect, func(r rune) bool { return unicode.IsControl(r) || r == '\\' }) {
+		return fallback
+	}
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		return fallback
+	}
+
+	parsed, err := url.Parse(redirect)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" || parsed.User != nil {
+		return fallback
+	}
+	return redirect
+}
+
 func Bind[T any](c echo.Context) (*T, url.Values, error) {
 	v := new(T)
 	if err := c.Bind(v); err != nil {
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"woody-wood-portail/cmd/config"
+	ctx "woody-wood-portail/cmd/ctx/auth"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/auth"
+	"woody-wood-portail/cmd/services/cookies"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/permissions"
+	"woody-wood-portail/views"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+const impersonationCookie = "impersonation"
+
+// Pages which stay out of reach of an administrator viewing the application as a user, even read only
+var impersonationForbiddenPaths = []string{
+	"/admin",
+	"/user/totp",
+	// The personal data exports (export.json, logs.csv), the profile page itself stays visible
+	"/user/profile/",
+	// The devices and IP addresses of the user, and its API access tokens
+	"/user/sessions",
+	"/user/tokens",
+}
+
+// impersonationMiddleware runs the request as the impersonated user when the administrator has started an impersonation
+// from the current session. The application is read only during the impersonation.
+func impersonationMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cookie, err := c.Cookie(impersonationCookie)
+		if err != nil || cookie.Value == "" {
+			return next(c)
+		}
+
+		impersonationID, err := uuid.Parse(cookie.Value)
+		session, ok := ctx.GetSessionFromEcho(c)
+		if err != nil || !ok {
+			c.SetCookie(cookies.Clear(impersonationCookie))
+			return next(c)
+		}
+
+		row, err := db.Q(c).GetActiveImpersonation(c.Request().Context(), db.GetActiveImpersonationParams{
+			ID:        impersonationID,
+			SessionID: pgtype.UUID{Bytes: session.ID, Valid: true},
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			logger.Log.Debug().Stringer("impersonation", impersonationID).Msg("impersonation ended or expired")
+			c.SetCookie(cookies.Clear(impersonationCookie))
+			return next(c)
+		}
+		if err != nil {
+			return err
+		}
+
+		if !ctx.HasPermission(c, permissions.ImpersonateUsers) {
+			c.SetCookie(cookies.Clear(impersonationCookie))
+			return next(c)
+		}
+
+		ctx.Impersonate(c, ctx.GetUserFromEcho(c), row.User)
+
+		path := c.Request().URL.Path
+		if path == "/impersonation/stop" {
+			return next(c)
+		}
+
+		for _, forbidden := range impersonationForbiddenPaths {
+			if strings.HasPrefix(path, forbidden) {
+				return c.String(403, "Page inaccessible pendant la consultation en tant que "+row.User.FullName)
+			}
+		}
+
+		// Opening the gate, or changing anything on behalf of the user is not allowed
+		if c.Request().Method != echo.GET && c.Request().Method != echo.HEAD {
+			logger.Log.Info().Stringer("impersonation", impersonationID).Str("path", path).Msg("action refused during impersonation")
+			return c.String(403, "Action impossible pendant la consultation en tant que "+row.User.FullName)
+		}
+
+		return next(c)
+	}
+}
+
+func registerImpersonationAdminHandlers(usersGroup *echo.Group) {
+	usersGroup.POST("/:id/impersonate", func(c echo.Context) error {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(404, "Failed to parse user ID: "+err.Error())
+		}
+
+		admin := ctx.GetUserFromEcho(c)
+		session, ok := ctx.GetSessionFromEcho(c)
+		if !ok {
+			return c.String(401, "Session introuvable")
+		}
+
+		if userID == admin.ID {
+			return c.String(422, "Vous ne pouvez pas vous consulter vous-même")
+		}
+
+		user, err := db.Q(c).GetUser(c.Request().Context(), userID)
+		if err != nil {
+			return c.String(404, "Utilisateur introuvable")
+		}
+		if !user.EmailVerified || user.RegistrationState != "accepted" {
+			return c.String(422, "Seuls les comptes actifs peuvent être consultés")
+		}
+
+		// Viewing the application as the user must not show more than the admin can already access
+		role, err := db.Q(c).GetRole(c.Request().Context(), user.Role)
+		if err != nil {
+			logger.Log.Error().Err(err).Str("role", user.Role).Msg("Failed to load user role")
+			return c.String(500, "Erreur inatendue")
+		}
+		if !permissions.HasAll(ctx.GetPermissionsFromEcho(c), role.Permissions) {
+			logger.Log.Info().Stringer("admin", admin.ID).Stringer("user", user.ID).Msg("impersonation of a user with more permissions refused")
+			return c.String(403, "Vous ne pouvez pas consulter un compte ayant des permissions que vous n'avez pas")
+		}
+
+		duration := config.Config.Users.ImpersonationDuration
+		impersonation, err := db.Q(c).CreateImpersonation(c.Request().Context(), db.CreateImpersonationParams{
+			AdminID:   pgtype.UUID{Bytes: admin.ID, Valid: true},
+			UserID:    user.ID,
+			SessionID: pgtype.UUID{Bytes: session.ID, Valid: true},
+			ExpiresAt: pgtype.Timestamp{Time: time.Now().Add(duration), Valid: true},
+		})
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to start impersonation")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		logger.Log.Info().Stringer("admin", admin.ID).Stringer("user", user.ID).Stringer("impersonation", impersonation.ID).Msg("Impersonation started")
+
+		c.SetCookie(cookies.New(impersonationCookie, impersonation.ID.String(), int(duration.Seconds())))
+		return Redirect(c, "/user")
+	}, RequirePermissionMiddleware(permissions.ImpersonateUsers))
+}
+
+// RegisterImpersonationHandlers registers the route used by the administrators to stop viewing the application as a user
+func RegisterImpersonationHandlers(e RequireAuth) {
+	e.POST("/impersonation/stop", func(c echo.Context) error {
+		cookie, cookieErr := c.Cookie(impersonationCookie)
+		c.SetCookie(cookies.Clear(impersonationCookie))
+
+		admin, ok := ctx.GetImpersonatorFromEcho(c)
+		if !ok || cookieErr != nil {
+			return Redirect(c, "/user")
+		}
+		user := ctx.GetUserFromEcho(c)
+		session, _ := ctx.GetSessionFromEcho(c)
+
+		// The cookie has already been checked by the middleware
+		impersonationID, _ := uuid.Parse(cookie.Value)
+		if err := db.Q(c).EndImpersonation(c.Request().Context(), db.EndImpersonationParams{
+			ID:        impersonationID,
+			SessionID: pgtype.UUID{Bytes: session.ID, Valid: true},
+		}); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to end impersonation")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		logger.Log.Info().Stringer("admin", admin.ID).Stringer("user", user.ID).Stringer("impersonation", impersonationID).Msg("Impersonation ended")
+
+		return Redirect(c, "/admin/users/"+user.ID.String())
+	})
+}
+
+func registerImpersonationLogHandlers(adminGroup *echo.Group) {
+	adminGroup.GET("/impersonations", func(c echo.Context) error {
+		impersonations, err := db.Q(c).ListImpersonations(c.Request().Context())
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to list impersonations")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		downloads, err := db.Q(c).ListAddressProofDownloads(c.Request().Context())
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to list address proof downloads")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		return Render(c, 200, views.AdminImpersonationsPage(impersonations, downloads))
+	}, RequirePermissionMiddleware(permissions.ViewLogs))
+}
+
+// endImpersonationOnLogout ends the impersonation of the session being logged out, outside of the request transaction
+func endImpersonationOnLogout(c echo.Context, token string) {
+	cookie, err := c.Cookie(impersonationCookie)
+	if err != nil {
+		return
+	}
+	c.SetCookie(cookies.Clear(impersonationCookie))
+
+	impersonationID, err := uuid.Parse(cookie.Value)
+	if err != nil {
+		return
+	}
+	sessionID, err := auth.ParseSessionID(token)
+	if err != nil {
+		return
+	}
+
+	if err := db.QGlobal().EndImpersonation(c.Request().Context(), db.EndImpersonationParams{
+		ID:        impersonationID,
+		SessionID: pgtype.UUID{Bytes: sessionID, Valid: true},
+	}); err != nil {
+		logger.Log.Error().Err(err).Stringer("impersonation", impersonationID).Msg("Failed to end impersonation on logout")
+	}
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+	"woody-wood-portail/cmd/config"
+	echoctx "woody-wood-portail/cmd/ctx"
+	ctx "woody-wood-portail/cmd/ctx/auth"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/invitations"
+	"woody-wood-portail/cmd/services/permissions"
+	"woody-wood-portail/cmd/services/poster"
+	"woody-wood-portail/cmd/timezone"
+	"woody-wood-portail/views"
+	"woody-wood-portail/views/components"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"github.com/skip2/go-qrcode"
+)
+
+const invitationCodeEchoKey = "invitation_code"
+
+func registerInvitationAdminHandlers(adminGroup *echo.Group) {
+	invitationGroup := adminGroup.Group("/invitation", RequirePermissionMiddleware(permissions.ManageInvitations))
+
+	invitationGroup.GET("", func(c echo.Context) error {
+		codes, err := db.Q(c).ListInvitationCodes(c.Request().Context())
+		if err != nil {
+			return fmt.Errorf("failed to list invitation codes: %w", err)
+		}
+
+		roles, err := db.Q(c).ListRoles(c.Request().Context())
+		if err != nil {
+			return fmt.Errorf("failed to list roles: %w", err)
+		}
+
+		return Render(c, 200, views.AdminInvitationsPage(&views.AdminInvitationsModel{
+			Codes: codes,
+			Form:  views.AdminInvitationFormModel{FormModel: components.NewFormModel(nil, nil), Roles: roles},
+		}))
+	})
+
+	invitationGroup.POST("", func(c echo.Context) error {
+		values, rawValues, err := Bind[views.AdminInvitationValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to bind values")
+			return Render(c, 422, views.AdminInvitationForm(&views.AdminInvitationFormModel{FormModel: components.NewFormError("Erreur inatendue", rawValues)}, nil))
+		}
+
+		model := &views.AdminInvitationFormModel{FormModel: components.NewFormModel(rawValues, Validate(c, values))}
+		model.Roles, err = db.Q(c).ListRoles(c.Request().Context())
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to list roles")
+			model.Errors.Global = "Une erreur inatendue est survenue lors du chargement des rôles"
+			return Render(c, 422, views.AdminInvitationForm(model, nil))
+		}
+
+		params := db.CreateInvitationCodeParams{
+			Label:       values.Label,
+			Apartment:   pgtype.Text{String: values.Apartment, Valid: values.Apartment != ""},
+			Role:        pgtype.Text{String: values.Role, Valid: values.Role != ""},
+			AutoApprove: values.AutoApprove,
+			Rotates:     values.Rotates,
+		}
+
+		if values.ExpiresAt != "" {
+			day, err := time.ParseInLocation("2006-01-02", values.ExpiresAt, timezone.TZ)
+			if err != nil || !day.AddDate(0, 0, 1).After(time.Now()) {
+				model.Errors.Fields["ExpiresAt"] = "Date d'expiration invalide"
+			}
+			// The code can be used until the end of the day
+			params.ExpiresAt = pgtype.Timestamp{Time: day.AddDate(0, 0, 1), Valid: true}
+		}
+
+		if values.MaxUses != "" {
+			maxUses, err := strconv.Atoi(values.MaxUses)
+			if err != nil || maxUses < 1 {
+				model.Errors.Fields["MaxUses"] = "Le nombre d'utilisations doit être positif"
+			}
+			params.MaxUses = pgtype.Int4{Int32: int32(maxUses), Valid: true}
+		}
+
+		if values.Role != "" && !ctx.HasPermission(c, permissions.ManageRoles) {
+			model.Errors.Fields["Role"] = "Vous n'avez pas la permission d'attribuer un rôle"
+		}
+
+		if model.HasError() {
+			return Render(c, 422, views.AdminInvitationForm(model, nil))
+		}
+
+		code, err := invitations.Create(c.Request().Context(), db.Q(c), params)
+		if errors.Is(err, invitations.ErrNoCodeAvailable) {
+			logger.Log.Error().Err(err).Msg("Failed to draw an unused invitation code")
+			model.Errors.Global = "Impossible de trouver un code d'invitation libre, veuillez réessayer"
+			return Render(c, 422, views.AdminInvitationForm(model, nil))
+		} else if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to create invitation code")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.AdminInvitationForm(model, nil))
+		}
+
+		codes, err := db.Q(c).ListInvitationCodes(c.Request().Context())
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to list invitation codes")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.AdminInvitationForm(model, nil))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.AdminInvitationForm(model, nil))
+		}
+
+		logger.Log.Info().Stringer("code", code.ID).Str("label", code.Label).Msg("Invitation code created")
+
+		return Render(c, 200, views.AdminInvitationForm(&views.AdminInvitationFormModel{
+			FormModel: components.NewFormModel(nil, nil),
+			Roles:     model.Roles,
+		}, codes))
+	})
+
+	invitationGroup.GET("/:id", func(c echo.Context) error {
+		codeID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(404, "Failed to parse code ID: "+err.Error())
+		}
+
+		code, err := db.Q(c).GetInvitationCode(c.Request().Context(), codeID)
+		if err != nil {
+			return c.String(404, "Code introuvable")
+		}
+
+		model := &views.AdminInvitationPosterModel{Code: code}
+		model.QrCode, err = invitationQrCodeHandler(code.Code)
+		if err != nil {
+			return fmt.Errorf("failed to render QR code: %w", err)
+		}
+
+		return Render(c, 200, views.AdminInvitationPosterPage(model))
+	})
+
+	invitationGroup.GET("/:id/poster.pdf", func(c echo.Context) error {
+		codeID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(404, "Failed to parse code ID: "+err.Error())
+		}
+
+		code, err := db.Q(c).GetInvitationCode(c.Request().Context(), codeID)
+		if err != nil {
+			return c.String(404, "Code introuvable")
+		}
+
+		// Large enough to stay sharp once printed
+		qrPNG, err := invitationQrCode(code.Code, 1024)
+		if err != nil {
+			return fmt.Errorf("failed to render QR code: %w", err)
+		}
+
+		c.Response().Header().Set(echo.HeaderContentType, "application/pdf")
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="invitation-`+code.Code+`.pdf"`)
+		c.Response().WriteHeader(200)
+		return poster.Invitation(c.Response(), code.Code, qrPNG)
+	})
+
+	invitationGroup.DELETE("/:id", func(c echo.Context) error {
+		codeID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(404, "Failed to parse code ID: "+err.Error())
+		}
+
+		revoked, err := db.Q(c).RevokeInvitationCode(c.Request().Context(), codeID)
+		if err != nil {
+			logger.Log.Error().Err(err).Stringer("code", codeID).Msg("Failed to revoke invitation code")
+			return c.String(500, "Erreur inatendue")
+		}
+		if revoked == 0 {
+			return c.String(404, "Code introuvable")
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		logger.Log.Info().Stringer("code", codeID).Msg("Invitation code revoked")
+
+		return c.NoContent(200)
+	})
+}
+
+func invitationQrCodeHandler(code string) (string, error) {
+	qrPNG, err := invitationQrCode(code, 256)
+	if err != nil {
+		return "", err
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrPNG), nil
+}
+
+// invitationQrCode returns the PNG of a QR code opening the registration page with the code filled in
+func invitationQrCode(code string, size int) ([]byte, error) {
+	return qrcode.Encode(config.Config.Http.BaseURL+"/register?code="+code, qrcode.Medium, size)
+}
+
+// consumeInvitationCode counts a use of the code, if it is still valid, and remembers it to apply it to the user.
+// The use is part of the request transaction, it is only counted if the registration succeeds.
+func consumeInvitationCode(templCtx context.Context, code string) bool {
+	c := echoctx.GetEchoFromTempl(templCtx)
+
+	if code == "" {
+		return registrationWithoutCodeAllowed(c)
+	}
+	if !invitationCodeRegexp.MatchString(code) {
+		return false
+	}
+
+	invitation, err := db.Q(c).UseInvitationCode(c.Request().Context(), code)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("Unable to use invitation code")
+		return false
+	}
+
+	c.Set(invitationCodeEchoKey, invitation)
+	return true
+}
+
+var invitationCodeRegexp = regexp.MustCompile(`^[0-9]{6}$`)
+
+// registrationWithoutCodeAllowed is true when the configuration opens the registration while no invitation code is
+// active, like before the first code is created
+func registrationWithoutCodeAllowed(c echo.Context) bool {
+	if !config.Config.Users.Invitation.Optional {
+		return false
+	}
+
+	count, err := db.Q(c).CountActiveInvitationCodes(c.Request().Context())
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("Unable to count invitation codes")
+		return false
+	}
+	if count > 0 {
+		return false
+	}
+
+	logger.Log.Info().Msg("No active invitation code, allowing registration without code")
+	return true
+}
+
+// applyInvitationCode records the invitation code consumed during the validation on the user,
+// with the apartment and role it assigns
+func applyInvitationCode(c echo.Context, user db.User) (db.User, error) {
+	invitation, ok := c.Get(invitationCodeEchoKey).(db.InvitationCode)
+	if !ok {
+		return user, nil
+	}
+
+	user, err := db.Q(c).ApplyInvitationCode(c.Request().Context(), db.ApplyInvitationCodeParams{
+		UserID:           user.ID,
+		InvitationCodeID: invitation.ID,
+	})
+	if err != nil {
+		return user, fmt.Errorf("failed to apply invitation code: %w", err)
+	}
+	return user, nil
+}
+
+// recordInvitationCode records the invitation code consumed during a renewal. The apartment and role of the code are
+// not applied, they would change an existing account without any review.
+func recordInvitationCode(c echo.Context, user db.User) error {
+	invitation, ok := c.Get(invitationCodeEchoKey).(db.InvitationCode)
+	if !ok {
+		return nil
+	}
+
+	if _, err := db.Q(c).RecordInvitationCode(c.Request().Context(), db.RecordInvitationCodeParams{
+		ID:               user.ID,
+		InvitationCodeID: pgtype.UUID{Bytes: invitation.ID, Valid: true},
+	}); err != nil {
+		return fmt.Errorf("failed to record invitation code: %w", err)
+	}
+	return nil
+}
+
+// autoApproveRegistration accepts the registration of a user invited with a code which doesn't require a review
+func autoApproveRegistration(c echo.Context, user db.User) (bool, error) {
+	if !user.InvitationCodeID.Valid {
+		return false, nil
+	}
+
+	invitation, err := db.Q(c).GetInvitationCode(c.Request().Context(), user.InvitationCodeID.Bytes)
+	if err != nil {
+		return false, fmt.Errorf("failed to get invitation code: %w", err)
+	}
+	if !invitation.AutoApprove {
+		return false, nil
+	}
+
+	if _, err := db.Q(c).RegistrationAccepted(c.Request().Context(), user.ID); err != nil {
+		return false, fmt.Errorf("failed to accept registration: %w", err)
+	}
+
+	logger.Log.Info().Stringer("user", user.ID).Stringer("code", invitation.ID).Msg("Registration approved by its invitation code")
+	return true, nil
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	ctx "woody-wood-portail/cmd/ctx/auth"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/mails"
+	"woody-wood-portail/cmd/services/permissions"
+	"woody-wood-portail/views"
+	"woody-wood-portail/views/components"
+	"woody-wood-portail/views/emails"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+func registerLockdownHandlers(adminGroup *echo.Group, openChannel chan struct{}) {
+	lockdownGroup := adminGroup.Group("/lockdown", RequirePermissionMiddleware(permissions.ManageGate))
+
+	lockdownGroup.GET("", func(c echo.Context) error {
+		model := &views.AdminLockdownPageModel{}
+
+		var err error
+		model.Form.Lockdown, err = getLockdown(c)
+		if err != nil {
+			return fmt.Errorf("failed to get lockdown state: %w", err)
+		}
+
+		model.Events, err = db.Q(c).ListLockdownEvents(c.Request().Context())
+		if err != nil {
+			return fmt.Errorf("failed to list lockdown events: %w", err)
+		}
+
+		model.Exempt, err = db.Q(c).ListLockdownExemptUsers(c.Request().Context())
+		if err != nil {
+			return fmt.Errorf("failed to list lockdown exempt users: %w", err)
+		}
+
+		return Render(c, 200, views.AdminLockdownPage(model))
+	})
+
+	lockdownGroup.PUT("", func(c echo.Context) error {
+		values, rawValues, err := Bind[views.AdminLockdownValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to bind values")
+			return Render(c, 422, views.AdminLockdownForm(&views.AdminLockdownFormModel{FormModel: components.NewFormError("Erreur inatendue", rawValues)}))
+		}
+
+		model := &views.AdminLockdownFormModel{
+			FormModel: components.NewFormModel(rawValues, Validate(c, values)),
+		}
+
+		model.Lockdown, err = getLockdown(c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to get lockdown state")
+			model.Errors.Global = "Une erreur inatendue est survenue"
+			return Render(c, 422, views.AdminLockdownForm(model))
+		}
+
+		if model.HasError() {
+			return Render(c, 422, views.AdminLockdownForm(model))
+		}
+
+		if model.Lockdown.Enabled == values.Enabled {
+			// Nothing changed, probably a stale page
+			return Render(c, 200, views.AdminLockdownForm(&views.AdminLockdownFormModel{Lockdown: model.Lockdown}))
+		}
+
+		currentUser := ctx.GetUserFromEcho(c)
+		message := values.Message
+		if !values.Enabled {
+			message = ""
+		}
+
+		model.Lockdown, err = db.Q(c).CreateLockdownEvent(c.Request().Context(), db.CreateLockdownEventParams{
+			Enabled: values.Enabled,
+			Message: message,
+			UserID:  pgtype.UUID{Bytes: currentUser.ID, Valid: true},
+		})
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to save lockdown state")
+			model.Errors.Global = "Une erreur inatendue lors de la sauvegarde"
+			return Render(c, 422, views.AdminLockdownForm(model))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			model.Errors.Global = "Une erreur inatendue lors de la sauvegarde"
+			return Render(c, 422, views.AdminLockdownForm(model))
+		}
+
+		if values.Enabled {
+			// Drop any opening request that has not been picked up by the gate yet
+			select {
+			case <-openChannel:
+				logger.Log.Info().Msg("pending gate opening cancelled by lockdown")
+			default:
+			}
+		}
+
+		logger.Log.Info().Stringer("user", currentUser.ID).Bool("enabled", values.Enabled).Msg("Lockdown toggled")
+
+		sendLockdownMail(c, model.Lockdown, currentUser)
+
+		return Render(c, 200, views.AdminLockdownForm(&views.AdminLockdownFormModel{Lockdown: model.Lockdown}))
+	})
+}
+
+// getLockdown returns the current lockdown state, which is disabled if it has never been toggled
+func getLockdown(c echo.Context) (db.LockdownEvent, error) {
+	lockdown, err := db.Q(c).GetLockdown(c.Request().Context())
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.LockdownEvent{}, nil
+	}
+	return lockdown, err
+}
+
+// isLockedDownFor returns true if the gate can't be opened by the given user because of a lockdown
+func isLockedDownFor(c echo.Context, user db.User) (bool, db.LockdownEvent, error) {
+	lockdown, err := getLockdown(c)
+	if err != nil {
+		return true, lockdown, err
+	}
+
+	return lockdown.Enabled && !user.LockdownExempt, lockdown, nil
+}
+
+func sendLockdownMail(c echo.Context, event db.LockdownEvent, by db.User) {
+	admins, err := db.QGlobal().ListUsersWithPermission(c.Request().Context(), string(permissions.ManageGate))
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("Unable to list admins to notify about lockdown")
+		return
+	}
+
+	subject := "Confinement du portail Woody Wood Gate désactivé"
+	if event.Enabled {
+		subject = "Confinement du portail Woody Wood Gate activé"
+	}
+
+	for _, admin := range admins {
+		if err := mails.SendMail(c.Request().Context(), admin, subject, emails.LockdownChanged(event, by)); err != nil {
+			logger.Log.Error().Err(err).Str("admin", admin.Email).Msg("Unable to send lockdown notification")
+		}
+	}
+}
+
+// lockdownMessage returns the message displayed to users when the gate is locked down
+func lockdownMessage(lockdown db.LockdownEvent) string {
+	if lockdown.Message == "" {
+		return "Le portail est temporairement confiné, aucune ouverture à distance n'est possible."
+	}
+	return lockdown.Message
+}
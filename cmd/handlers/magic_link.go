@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+	"woody-wood-portail/cmd/config"
+	ctx "woody-wood-portail/cmd/ctx/auth"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/auth"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/mails"
+	"woody-wood-portail/cmd/services/throttle"
+	"woody-wood-portail/views"
+	"woody-wood-portail/views/components"
+	"woody-wood-portail/views/emails"
+
+	"github.com/a-h/templ"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+func registerMagicLinkHandlers(authGroup *echo.Group) {
+	authGroup.GET("/login/magic-link", func(c echo.Context) error {
+		code := c.QueryParam("code")
+		if code == "" {
+			if ctx.IsAuthenticated(c) {
+				return RedirectWitQuery(c, "/user/")
+			}
+			return Render(c, 200, views.MagicLinkPage(c.QueryParam("error")))
+		}
+
+		user, err := auth.ParseToken(c, code,
+			auth.WithAudience(auth.MagicLinkAudience),
+			auth.SingleUse(c),
+		)
+		if err != nil {
+			logger.Log.Info().Err(err).Msg("Invalid login link")
+			return Redirect(c, "/login/magic-link?error="+url.QueryEscape("Lien de connexion invalide ou expiré, veuillez en demander un nouveau."))
+		}
+
+		locked, err := isAccountLocked(c, user.ID)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to check account lockout")
+			return Redirect(c, "/login/magic-link?error="+url.QueryEscape("Erreur inatendue"))
+		}
+		if locked {
+			logger.Log.Info().Stringer("user", user.ID).Msg("Login link used on a locked account")
+			return Redirect(c, "/login/magic-link?error="+url.QueryEscape("Votre compte est temporairement verrouillé, veuillez réessayer plus tard."))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+			return Redirect(c, "/login/magic-link?error="+url.QueryEscape("Erreur inatendue"))
+		}
+
+		logger.Log.Info().Stringer("user", user.ID).Msg("Logged in with a login link")
+
+		// The link only proves access to the mailbox, the second factor is still required
+		if user.TotpEnabled {
+			if err := addTOTPPendingCookie(c, user.ID); err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to add two-factor cookie")
+				return Redirect(c, "/login/magic-link?error="+url.QueryEscape("Erreur inatendue"))
+			}
+			return Redirect(c, "/login/totp")
+		}
+
+		if err := addAuthenticationCookie(c, user.ID); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to add authentication cookie")
+			return Redirect(c, "/login/magic-link?error="+url.QueryEscape("Erreur inatendue"))
+		}
+
+		// The user routes redirect to the verification or pending registration pages when needed
+		return Redirect(c, "/user/")
+	})
+
+	authGroup.POST("/login/magic-link", func(c echo.Context) error {
+		values, rawValues, err := Bind[views.MagicLinkFormValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to get form params")
+			return Render(c, 422, views.MagicLinkForm(views.MagicLinkModel{
+				FormModel: components.NewFormError("Erreur inatendue"),
+			}))
+		}
+
+		model := views.MagicLinkModel{FormModel: components.NewFormModel(rawValues, Validate(c, values))}
+
+		if len(model.Errors.Fields) > 0 {
+			logger.Log.Info().Any("errors", model.Errors).Msg("Invalid form")
+			return Render(c, 422, views.MagicLinkForm(model))
+		}
+
+		ipRequests, err := countRecentIPAttempts(c, throttle.KindMagicLink)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to count login link requests")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.MagicLinkForm(model))
+		}
+		if ipRequests >= int64(config.Config.Users.Login.MaxIPResetMails) {
+			logger.Log.Warn().Str("ip", c.RealIP()).Int64("requests", ipRequests).Msg("Too many login link requests from this IP")
+			model.Errors.Global = "Trop de demandes, veuillez réessayer plus tard"
+			return Render(c, 422, views.MagicLinkForm(model))
+		}
+
+		recentMails, err := db.QGlobal().CountAuthAttemptsByEmail(c.Request().Context(), db.CountAuthAttemptsByEmailParams{
+			Kind:      throttle.KindMagicLink,
+			Email:     values.Email,
+			CreatedAt: pgtype.Timestamp{Time: time.Now().Add(-config.Config.Users.Login.ResetMailInterval), Valid: true},
+		})
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to count login link requests")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.MagicLinkForm(model))
+		}
+
+		recordAuthAttempt(c, throttle.KindMagicLink, values.Email)
+
+		// The answer is the same whether the account exists or not, to not reveal which emails are registered
+		model.EmailSent = true
+
+		if recentMails > 0 {
+			logger.Log.Info().Msg("Login link already sent recently, not sending again")
+			return Render(c, 200, views.MagicLinkForm(model))
+		}
+
+		user, err := db.Q(c).GetUserByEmail(c.Request().Context(), values.Email)
+		if err != nil {
+			logger.Log.Info().Err(err).Msg("Login link requested for an unknown email")
+			return Render(c, 200, views.MagicLinkForm(model))
+		}
+
+		ttl := config.Config.Http.JWT.MagicLinkTTL
+		loginToken, err := auth.CreateSingleUseToken(user.ID, auth.MagicLinkAudience, ttl)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to create login link token")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.MagicLinkForm(model))
+		}
+
+		loginURL := fmt.Sprintf("%s/login/magic-link?code=%s", config.Config.Http.BaseURL, loginToken)
+
+		err = mails.SendMail(c.Request().Context(),
+			user,
+			"Votre lien de connexion à Woody Wood Gate",
+			emails.MagicLink(user, templ.SafeURL(loginURL), ttl),
+		)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to send login link email")
+		}
+
+		return Render(c, 200, views.MagicLinkForm(model))
+	})
+}
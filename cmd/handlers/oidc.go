@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/auth"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/oidc"
+	"woody-wood-portail/views"
+	"woody-wood-portail/views/components"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+func registerOIDCHandlers(authGroup *echo.Group) {
+	if !oidc.Enabled() {
+		return
+	}
+
+	authGroup.GET("/login/oidc", func(c echo.Context) error {
+		authURL, err := oidc.AuthCodeURL(c, c.QueryParam("redirect"))
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to start OIDC login")
+			return Render(c, 500, views.OIDCErrorPage("Le fournisseur d'identité est indisponible, veuillez réessayer plus tard."))
+		}
+
+		return c.Redirect(302, authURL)
+	})
+
+	authGroup.GET("/login/oidc/callback", func(c echo.Context) error {
+		if providerError := c.QueryParam("error"); providerError != "" {
+			logger.Log.Info().Str("error", providerError).Str("description", c.QueryParam("error_description")).Msg("OIDC login refused by the provider")
+			return Render(c, 422, views.OIDCErrorPage("La connexion a été refusée par le fournisseur d'identité."))
+		}
+
+		identity, redirect, err := oidc.Exchange(c)
+		if err != nil {
+			logger.Log.Info().Err(err).Msg("OIDC login failed")
+			return Render(c, 422, views.OIDCErrorPage("La connexion a échoué ou a expiré, veuillez réessayer."))
+		}
+
+		user, err := db.Q(c).GetUserByIdentity(c.Request().Context(), db.GetUserByIdentityParams{
+			Issuer:  identity.Issuer,
+			Subject: identity.Subject,
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			user, err = linkOIDCIdentity(c, identity)
+			if errors.Is(err, pgx.ErrNoRows) {
+				if err := oidc.SaveRegistration(c, identity); err != nil {
+					logger.Log.Error().Err(err).Msg("Unable to save OIDC registration")
+					return Render(c, 500, views.OIDCErrorPage("Erreur inatendue"))
+				}
+				return Redirect(c, "/register/oidc")
+			}
+		}
+		if errors.Is(err, errOIDCEmailNotVerified) {
+			return Render(c, 422, views.OIDCErrorPage(
+				"Votre email n'est pas vérifié par le fournisseur d'identité. "+
+					"Connectez-vous avec votre mot de passe, ou vérifiez votre email auprès du fournisseur d'identité.",
+			))
+		}
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to find OIDC user")
+			return Render(c, 500, views.OIDCErrorPage("Erreur inatendue"))
+		}
+
+		if err := db.Q(c).UpdateUserIdentityUsage(c.Request().Context(), db.UpdateUserIdentityUsageParams{
+			Issuer:  identity.Issuer,
+			Subject: identity.Subject,
+			Email:   identity.Email,
+		}); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to save OIDC identity usage")
+			return Render(c, 500, views.OIDCErrorPage("Erreur inatendue"))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+			return Render(c, 500, views.OIDCErrorPage("Erreur inatendue"))
+		}
+
+		redirect = localRedirect(redirect, "/user/")
+
+		// Accounts are linked by email, the provider account must not be enough to get past the second factor
+		if user.TotpEnabled {
+			if err := addTOTPPendingCookie(c, user.ID); err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to add two-factor cookie")
+				return Render(c, 500, views.OIDCErrorPage("Erreur inatendue"))
+			}
+			logger.Log.Info().Stringer("user", user.ID).Str("issuer", identity.Issuer).Msg("OIDC login waiting for the second factor")
+			return c.Redirect(302, "/login/totp?redirect="+url.QueryEscape(redirect))
+		}
+
+		if err := addAuthenticationCookie(c, user.ID); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to add authentication cookie")
+			return Render(c, 500, views.OIDCErrorPage("Erreur inatendue"))
+		}
+
+		logger.Log.Info().Stringer("user", user.ID).Str("issuer", identity.Issuer).Msg("Logged in with OIDC")
+
+		return c.Redirect(302, redirect)
+	})
+
+	authGroup.GET("/register/oidc", func(c echo.Context) error {
+		identity, err := oidc.GetRegistration(c)
+		if err != nil {
+			return RedirectWitQuery(c, "/login")
+		}
+
+		return Render(c, 200, views.OIDCRegisterPage(identity.Email, identity.Name))
+	})
+
+	authGroup.POST("/register/oidc", func(c echo.Context) error {
+		identity, err := oidc.GetRegistration(c)
+		if err != nil {
+			return Redirect(c, "/login")
+		}
+
+		values, rawValues, err := Bind[views.OIDCRegisterFormValues](c)
+		if err != nil {
+			return Render(c, 422, views.OIDCRegisterForm(&views.OIDCRegisterModel{FormModel: components.NewFormError("Erreur inatendue"), Email: identity.Email}))
+		}
+
+		model := &views.OIDCRegisterModel{
+			FormModel: components.NewFormModel(rawValues, Validate(c, values)),
+			Email:     identity.Email,
+		}
+
+		addressProofs, addressProofsErr := readAddressProofs(c)
+		if addressProofsErr != "" {
+			model.Errors.Fields["AddressProofFile"] = addressProofsErr
+		}
+
+		if model.HasError() {
+			logger.Log.Info().Any("errors", model.Errors).Msg("Invalid form")
+			return Render(c, 422, views.OIDCRegisterForm(model))
+		}
+
+		if _, err := db.Q(c).GetUserByEmail(c.Request().Context(), identity.Email); err == nil {
+			model.Errors.Global = "Un compte existe déjà avec cet email, connectez-vous avec votre mot de passe."
+			return Render(c, 422, views.OIDCRegisterForm(model))
+		}
+
+		createUserParams := db.CreateUserParams{
+			Email:     identity.Email,
+			FullName:  values.FullName,
+			Apartment: values.Apartment,
+		}
+
+		// The user logs in through the provider, the password is unknown until they choose one with a password reset
+		if err := auth.CreateHash(randomPassword(), &createUserParams); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to hash password")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.OIDCRegisterForm(model))
+		}
+
+		newUser, err := db.Q(c).CreateUser(c.Request().Context(), createUserParams)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to create user")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.OIDCRegisterForm(model))
+		}
+
+		newUser, err = applyInvitationCode(c, newUser)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to apply invitation code")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.OIDCRegisterForm(model))
+		}
+
+		if _, err := db.Q(c).CreateUserIdentity(c.Request().Context(), db.CreateUserIdentityParams{
+			UserID:  newUser.ID,
+			Issuer:  identity.Issuer,
+			Subject: identity.Subject,
+			Email:   identity.Email,
+		}); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to link OIDC identity")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.OIDCRegisterForm(model))
+		}
+
+		if identity.EmailVerified {
+			if err := db.Q(c).EmailVerified(c.Request().Context(), newUser.ID); err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to verify user email")
+				model.Errors.Global = "Erreur inatendue"
+				return Render(c, 422, views.OIDCRegisterForm(model))
+			}
+		}
+
+		if err := saveAddressProofs(c.Request().Context(), newUser.ID, addressProofs); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to save user address proof")
+			model.Errors.Global = "Erreur inatendue durant l'upload du document"
+			return Render(c, 422, views.OIDCRegisterForm(model))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.OIDCRegisterForm(model))
+		}
+
+		oidc.EndRegistration(c)
+
+		logger.Log.Info().Stringer("user", newUser.ID).Str("issuer", identity.Issuer).Msg("User created with OIDC")
+
+		if err := addAuthenticationCookie(c, newUser.ID); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to add authentication cookie")
+			return Redirect(c, "/login")
+		}
+
+		if identity.EmailVerified {
+			return Redirect(c, "/pending-registration")
+		}
+
+		if err := sendVerificationMail(c, newUser); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to send verification email")
+			return Redirect(c, "/verify?error="+url.QueryEscape("Une erreur est survenue, veuillez réssayer."))
+		}
+
+		return Redirect(c, "/verify")
+	})
+}
+
+var errOIDCEmailNotVerified = errors.New("email not verified by the OIDC provider")
+
+// linkOIDCIdentity links the identity to the existing account using the same email.
+// The email has to be verified by the provider, otherwise anyone could take over an account.
+func linkOIDCIdentity(c echo.Context, identity oidc.Identity) (db.User, error) {
+	user, err := db.Q(c).GetUserByEmail(c.Request().Context(), identity.Email)
+	if err != nil {
+		return user, err
+	}
+
+	if !identity.EmailVerified {
+		logger.Log.Info().Stringer("user", user.ID).Msg("Not linking OIDC identity with an unverified email")
+		return user, errOIDCEmailNotVerified
+	}
+
+	if _, err := db.Q(c).CreateUserIdentity(c.Request().Context(), db.CreateUserIdentityParams{
+		UserID:  user.ID,
+		Issuer:  identity.Issuer,
+		Subject: identity.Subject,
+		Email:   identity.Email,
+	}); err != nil {
+		return user, err
+	}
+
+	logger.Log.Info().Stringer("user", user.ID).Str("issuer", identity.Issuer).Msg("OIDC identity linked to existing account")
+	return user, nil
+}
+
+func randomPassword() string {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		panic(err)
+	}
+	return base64.RawStdEncoding.EncodeToString(raw)
+}
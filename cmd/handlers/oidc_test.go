@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"woody-wood-portail/cmd/config"
+	"woody-wood-portail/cmd/services/auth"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// The handlers need a database, the tests are skipped when TEST_DATABASE_URL is not set
+var (
+	testServer *echo.Echo
+	issuer     *oidctest.Issuer
+)
+
+func TestMain(m *testing.M) {
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		os.Exit(m.Run())
+	}
+
+	issuer = oidctest.NewIssuer("gate", "gate-secret")
+
+	config.Config.Database.URL = databaseURL
+	config.Config.Database.MigrateOnStart = true
+	config.Config.Http.OIDC.Issuer = issuer.URL
+	config.Config.Http.OIDC.ClientID = issuer.ClientID
+	config.Config.Http.OIDC.ClientSecret = issuer.ClientSecret
+	config.Config.Http.JWT.EncryptionKeys = randomKey()
+
+	db.Migrate()
+	pool, _ := db.Connect()
+	if err := auth.LoadKeyring(); err != nil {
+		panic(err)
+	}
+
+	testServer = echo.New()
+	testServer.Use(db.TransactionMiddleware())
+	RegisterAuthHandlers(testServer)
+
+	code := m.Run()
+	pool.Close()
+	issuer.Close()
+	os.Exit(code)
+}
+
+func randomKey() string {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func randomHex(n int) string {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(raw)
+}
+
+func requireDatabase(t *testing.T) {
+	if testServer == nil {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+}
+
+// createTestUser creates an account with a unique email, deleted at the end of the test
+func createTestUser(t *testing.T) db.User {
+	t.Helper()
+
+	params := db.CreateUserParams{
+		Email:     "oidc-" + randomHex(6) + "@example.com",
+		FullName:  "Resident",
+		Apartment: "A1",
+	}
+	if err := auth.CreateHash(randomPassword(), &params); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := db.QGlobal().CreateUser(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = db.QGlobal().DeleteUser(context.Background(), user.ID)
+	})
+	return user
+}
+
+func serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	testServer.ServeHTTP(rec, req)
+	return rec
+}
+
+// oidcLogin goes through /login/oidc, the provider login with the given claims, and returns the callback response
+func oidcLogin(t *testing.T, claims jwt.MapClaims) *httptest.ResponseRecorder {
+	t.Helper()
+
+	start := serve(httptest.NewRequest(http.MethodGet, "/login/oidc?redirect="+url.QueryEscape("/user/gate"), nil))
+	if start.Code != 302 {
+		t.Fatalf("got status %d when starting the login, expected a redirect to the provider", start.Code)
+	}
+
+	state, code := issuer.Authorize(t, start.Header().Get("Location"), claims)
+
+	query := url.Values{"state": {state}, "code": {code}}
+	req := httptest.NewRequest(http.MethodGet, "/login/oidc/callback?"+query.Encode(), nil)
+	for _, cookie := range start.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	return serve(req)
+}
+
+func responseCookie(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == name && cookie.Value != "" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func linkedUser(t *testing.T, subject string) (db.User, error) {
+	t.Helper()
+	return db.QGlobal().GetUserByIdentity(context.Background(), db.GetUserByIdentityParams{
+		Issuer:  issuer.URL,
+		Subject: subject,
+	})
+}
+
+func TestOIDCLinkVerifiedEmail(t *testing.T) {
+	requireDatabase(t)
+	user := createTestUser(t)
+	subject := "linked-" + user.ID.String()
+
+	rec := oidcLogin(t, jwt.MapClaims{"sub": subject, "email": user.Email, "email_verified": true})
+
+	if rec.Code != 302 || rec.Header().Get("Location") != "/user/gate" {
+		t.Fatalf("got status %d to %q, expected a redirect to /user/gate", rec.Code, rec.Header().Get("Location"))
+	}
+	if responseCookie(rec, "authorization") == nil {
+		t.Error("the user is not logged in")
+	}
+	if linked, err := linkedUser(t, subject); err != nil || linked.ID != user.ID {
+		t.Errorf("the identity is not linked to the account: %v", err)
+	}
+}
+
+func TestOIDCRefuseUnverifiedEmail(t *testing.T) {
+	requireDatabase(t)
+	user := createTestUser(t)
+	subject := "unverified-" + user.ID.String()
+
+	rec := oidcLogin(t, jwt.MapClaims{"sub": subject, "email": user.Email, "email_verified": false})
+
+	if rec.Code != 422 {
+		t.Fatalf("got status %d, expected 422", rec.Code)
+	}
+	if responseCookie(rec, "authorization") != nil {
+		t.Error("the user is logged in with an unverified email")
+	}
+	if _, err := linkedUser(t, subject); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("the identity was linked with an unverified email: %v", err)
+	}
+}
+
+func TestOIDCRequireTOTP(t *testing.T) {
+	requireDatabase(t)
+	user := createTestUser(t)
+	subject := "totp-" + user.ID.String()
+
+	ctx := context.Background()
+	if err := db.QGlobal().SetTOTPSecret(ctx, db.SetTOTPSecretParams{ID: user.ID, TotpSecret: "JBSWY3DPEHPK3PXP"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QGlobal().EnableTOTP(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := oidcLogin(t, jwt.MapClaims{"sub": subject, "email": user.Email, "email_verified": true})
+
+	expected := "/login/totp?redirect=" + url.QueryEscape("/user/gate")
+	if rec.Code != 302 || rec.Header().Get("Location") != expected {
+		t.Fatalf("got status %d to %q, expected a redirect to %q", rec.Code, rec.Header().Get("Location"), expected)
+	}
+	if responseCookie(rec, "authorization") != nil {
+		t.Error("the provider login skipped the second factor")
+	}
+	if responseCookie(rec, totpCookieName) == nil {
+		t.Error("no two-factor cookie set")
+	}
+}
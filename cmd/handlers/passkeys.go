@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	ctx "woody-wood-portail/cmd/ctx/auth"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/passkeys"
+	"woody-wood-portail/views"
+	"woody-wood-portail/views/components"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+func registerPasskeyLoginHandlers(authGroup *echo.Group) {
+	authGroup.POST("/login/passkey/begin", func(c echo.Context) error {
+		assertion, session, err := passkeys.WebAuthn.BeginDiscoverableLogin(
+			webauthn.WithUserVerification(protocol.VerificationPreferred),
+		)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to begin passkey login")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		if err := passkeys.SaveSession(c, session); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to save passkey login session")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		return c.JSON(200, assertion)
+	})
+
+	authGroup.POST("/login/passkey/finish", func(c echo.Context) error {
+		session, err := passkeys.PopSession(c)
+		if err != nil {
+			logger.Log.Info().Err(err).Msg("Unable to get passkey login session")
+			return c.String(422, "La connexion a expiré, veuillez réessayer")
+		}
+
+		var user *passkeys.User
+		credential, err := passkeys.WebAuthn.FinishDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			userID, err := uuid.FromBytes(userHandle)
+			if err != nil {
+				return nil, fmt.Errorf("invalid user handle: %w", err)
+			}
+
+			user, err = loadPasskeyUser(c, userID)
+			return user, err
+		}, session, c.Request())
+		if err != nil {
+			logger.Log.Info().Err(err).Msg("Passkey login failed")
+			return c.String(422, "Clé d'accès invalide")
+		}
+
+		if credential.Authenticator.CloneWarning {
+			logger.Log.Warn().Stringer("user", user.ID).Msg("Passkey sign counter went backward, the authenticator may have been cloned")
+			return c.String(422, "Clé d'accès invalide")
+		}
+
+		if err := savePasskeyUsage(c, credential); err != nil {
+			logger.Log.Error().Err(err).Stringer("user", user.ID).Msg("Unable to save passkey usage")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		redirect := localRedirect(c.QueryParam("redirect"), "/user/")
+
+		// A passkey verifying the user (PIN or biometrics) already is two factors, a security key only proving its
+		// possession is not enough to skip the TOTP
+		if user.TotpEnabled && !credential.Flags.UserVerified {
+			if err := addTOTPPendingCookie(c, user.ID); err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to add two-factor cookie")
+				return c.String(500, "Erreur inatendue")
+			}
+			logger.Log.Info().Stringer("user", user.ID).Msg("Passkey login without user verification waiting for the second factor")
+			return c.JSON(200, map[string]string{"redirect": "/login/totp?redirect=" + url.QueryEscape(redirect)})
+		}
+
+		if err := addAuthenticationCookie(c, user.ID); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to add authentication cookie")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		logger.Log.Info().Stringer("user", user.ID).Msg("Logged in with a passkey")
+
+		return c.JSON(200, map[string]string{"redirect": redirect})
+	})
+}
+
+func registerPasskeyUserHandlers(userRoutes *echo.Group) {
+	passkeysRoutes := userRoutes.Group("/passkeys")
+
+	passkeysRoutes.GET("", func(c echo.Context) error {
+		currentUser := ctx.GetUserFromEcho(c)
+
+		credentials, err := db.Q(c).ListWebauthnCredentialsByUser(c.Request().Context(), currentUser.ID)
+		if err != nil {
+			return fmt.Errorf("failed to list passkeys: %w", err)
+		}
+
+		return Render(c, 200, views.UserPasskeysPage(credentials))
+	})
+
+	passkeysRoutes.POST("/begin", func(c echo.Context) error {
+		currentUser := ctx.GetUserFromEcho(c)
+
+		user, err := loadPasskeyUser(c, currentUser.ID)
+		if err != nil {
+			logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("Unable to load passkeys")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		exclusions := make([]protocol.CredentialDescriptor, 0, len(user.Credentials))
+		for _, credential := range user.Credentials {
+			exclusions = append(exclusions, credential.Descriptor())
+		}
+
+		creation, session, err := passkeys.WebAuthn.BeginRegistration(user,
+			webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+			webauthn.WithExclusions(exclusions),
+		)
+		if err != nil {
+			logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("Unable to begin passkey registration")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		if err := passkeys.SaveSession(c, session); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to save passkey registration session")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		return c.JSON(200, creation)
+	})
+
+	passkeysRoutes.POST("/finish", func(c echo.Context) error {
+		currentUser := ctx.GetUserFromEcho(c)
+
+		session, err := passkeys.PopSession(c)
+		if err != nil {
+			logger.Log.Info().Err(err).Msg("Unable to get passkey registration session")
+			return c.String(422, "L'enregistrement a expiré, veuillez réessayer")
+		}
+
+		user, err := loadPasskeyUser(c, currentUser.ID)
+		if err != nil {
+			logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("Unable to load passkeys")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		credential, err := passkeys.WebAuthn.FinishRegistration(user, session, c.Request())
+		if err != nil {
+			logger.Log.Info().Err(err).Stringer("user", currentUser.ID).Msg("Passkey registration failed")
+			return c.String(422, "L'enregistrement de la clé d'accès a échoué")
+		}
+
+		encoded, err := passkeys.EncodeCredential(credential)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to encode passkey")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		name := c.QueryParam("name")
+		if name == "" {
+			name = "Clé d'accès"
+		}
+
+		stored, err := db.Q(c).CreateWebauthnCredential(c.Request().Context(), db.CreateWebauthnCredentialParams{
+			UserID:       currentUser.ID,
+			CredentialID: credential.ID,
+			Name:         name,
+			Credential:   encoded,
+		})
+		if err != nil {
+			logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("Unable to save passkey")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		logger.Log.Info().Stringer("user", currentUser.ID).Stringer("passkey", stored.ID).Msg("Passkey registered")
+
+		return c.JSON(200, map[string]string{"redirect": "/user/passkeys"})
+	})
+
+	passkeysRoutes.PUT("/:id", func(c echo.Context) error {
+		currentUser := ctx.GetUserFromEcho(c)
+
+		passkeyID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(404, "Failed to parse passkey ID: "+err.Error())
+		}
+
+		values, rawValues, err := Bind[views.PasskeyValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to bind values")
+			return Render(c, 422, views.PasskeyForm(&views.PasskeyFormModel{FormModel: components.NewFormError("Erreur inatendue", rawValues)}))
+		}
+
+		model := &views.PasskeyFormModel{
+			FormModel: components.NewFormModel(rawValues, Validate(c, values)),
+		}
+		model.Passkey.ID = passkeyID
+
+		if model.HasError() {
+			return Render(c, 422, views.PasskeyForm(model))
+		}
+
+		model.Passkey, err = db.Q(c).RenameWebauthnCredential(c.Request().Context(), db.RenameWebauthnCredentialParams{
+			ID:     passkeyID,
+			UserID: currentUser.ID,
+			Name:   values.Name,
+		})
+		if err != nil {
+			logger.Log.Error().Err(err).Stringer("passkey", passkeyID).Msg("Failed to rename passkey")
+			return c.NoContent(404)
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			model.Errors.Global = "Une erreur inatendue lors de la sauvegarde"
+			return Render(c, 422, views.PasskeyForm(model))
+		}
+
+		return Render(c, 200, views.PasskeyForm(&views.PasskeyFormModel{Passkey: model.Passkey}))
+	})
+
+	passkeysRoutes.DELETE("/:id", func(c echo.Context) error {
+		currentUser := ctx.GetUserFromEcho(c)
+
+		passkeyID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(404, "Failed to parse passkey ID: "+err.Error())
+		}
+
+		if _, err := db.Q(c).DeleteWebauthnCredential(c.Request().Context(), db.DeleteWebauthnCredentialParams{
+			ID:     passkeyID,
+			UserID: currentUser.ID,
+		}); err != nil {
+			logger.Log.Error().Err(err).Stringer("passkey", passkeyID).Msg("Failed to revoke passkey")
+			return c.NoContent(404)
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		logger.Log.Info().Stringer("user", currentUser.ID).Stringer("passkey", passkeyID).Msg("Passkey revoked")
+
+		return c.NoContent(200)
+	})
+}
+
+func loadPasskeyUser(c echo.Context, userID uuid.UUID) (*passkeys.User, error) {
+	user, err := db.Q(c).GetUser(c.Request().Context(), userID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+
+	credentials, err := db.Q(c).ListWebauthnCredentialsByUser(c.Request().Context(), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+
+	return passkeys.NewUser(user, credentials)
+}
+
+// savePasskeyUsage stores the updated sign counter of the credential used to login
+func savePasskeyUsage(c echo.Context, credential *webauthn.Credential) error {
+	if len(credential.ID) == 0 {
+		return errors.New("missing credential id")
+	}
+
+	encoded, err := passkeys.EncodeCredential(credential)
+	if err != nil {
+		return err
+	}
+
+	return db.Q(c).UpdateWebauthnCredentialUsage(c.Request().Context(), db.UpdateWebauthnCredentialUsageParams{
+		CredentialID: credential.ID,
+		Credential:   encoded,
+	})
+}
@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+	"woody-wood-portail/cmd/config"
+	ctx "woody-wood-portail/cmd/ctx/auth"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/auth"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/mails"
+	"woody-wood-portail/cmd/services/proofs"
+	"woody-wood-portail/cmd/timezone"
+	"woody-wood-portail/views"
+	"woody-wood-portail/views/components"
+	"woody-wood-portail/views/emails"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+func registerProfileUserHandlers(userRoutes *echo.Group) {
+	profileRoutes := userRoutes.Group("/profile")
+
+	profileRoutes.GET("", func(c echo.Context) error {
+		return Render(c, 200, views.UserProfilePage())
+	})
+
+	profileRoutes.PUT("", func(c echo.Context) error {
+		values, rawValues, err := Bind[views.UserProfileValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to bind values")
+			return Render(c, 422, views.UserProfileForm(&views.UserProfileFormModel{FormModel: components.NewFormError("Erreur inatendue", rawValues)}))
+		}
+
+		model := &views.UserProfileFormModel{FormModel: components.NewFormModel(rawValues, Validate(c, values))}
+		if model.HasError() {
+			return Render(c, 422, views.UserProfileForm(model))
+		}
+
+		currentUser := ctx.GetUserFromEcho(c)
+		if _, err := db.Q(c).UpdateUserFullName(c.Request().Context(), db.UpdateUserFullNameParams{
+			ID:       currentUser.ID,
+			FullName: values.FullName,
+		}); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to update full name")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserProfileForm(model))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserProfileForm(model))
+		}
+
+		logger.Log.Info().Stringer("user", currentUser.ID).Msg("Profile updated")
+
+		model.Saved = true
+		return Render(c, 200, views.UserProfileForm(model))
+	})
+
+	profileRoutes.POST("/password", func(c echo.Context) error {
+		values, _, err := Bind[views.UserPasswordValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to bind values")
+			return Render(c, 422, views.UserPasswordForm(&views.UserProfileFormModel{FormModel: components.NewFormError("Erreur inatendue")}))
+		}
+
+		// The passwords are never sent back to the browser
+		model := &views.UserProfileFormModel{FormModel: components.NewFormModel(nil, Validate(c, values))}
+		if model.HasError() {
+			return Render(c, 422, views.UserPasswordForm(model))
+		}
+
+		currentUser := ctx.GetUserFromEcho(c)
+		valid, err := auth.CompareHashAgainstPassword(currentUser, values.CurrentPassword)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to check password")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserPasswordForm(model))
+		}
+		if !valid {
+			logger.Log.Info().Stringer("user", currentUser.ID).Msg("Invalid current password on password change")
+			model.Errors.Fields["CurrentPassword"] = "Mot de passe incorrect"
+			return Render(c, 422, views.UserPasswordForm(model))
+		}
+
+		updatePasswordParams := db.UpdatePasswordParams{ID: currentUser.ID}
+		if err := auth.CreateHash(values.Password, &updatePasswordParams); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to hash password")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserPasswordForm(model))
+		}
+
+		if err := db.Q(c).UpdatePassword(c.Request().Context(), updatePasswordParams); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to update password")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserPasswordForm(model))
+		}
+
+		// Someone else may know the old password, sign out the other devices
+		current, _ := ctx.GetSessionFromEcho(c)
+		if _, err := db.Q(c).RevokeOtherSessions(c.Request().Context(), db.RevokeOtherSessionsParams{
+			UserID: currentUser.ID,
+			ID:     current.ID,
+		}); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to revoke sessions")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserPasswordForm(model))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserPasswordForm(model))
+		}
+
+		logger.Log.Info().Stringer("user", currentUser.ID).Msg("Password changed")
+
+		model.Saved = true
+		return Render(c, 200, views.UserPasswordForm(model))
+	})
+
+	profileRoutes.GET("/export.json", func(c echo.Context) error {
+		export, err := exportUserData(c, ctx.GetUserFromEcho(c))
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to export user data")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="woody-wood-gate.json"`)
+		return c.JSONPretty(200, export, "  ")
+	})
+
+	profileRoutes.GET("/logs.csv", func(c echo.Context) error {
+		logs, err := db.Q(c).ListLogsByUser(c.Request().Context(), ctx.GetUserFromEcho(c).ID)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to list logs")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="woody-wood-gate-ouvertures.csv"`)
+		c.Response().WriteHeader(200)
+
+		w := csv.NewWriter(c.Response())
+		if err := w.Write([]string{"date", "distance (m)", "jeton"}); err != nil {
+			return err
+		}
+		for _, log := range logs {
+			distance := ""
+			if log.Distance.Valid {
+				distance = strconv.FormatFloat(log.Distance.Float64, 'f', 0, 64)
+			}
+			if err := w.Write([]string{
+				log.CreatedAt.Time.In(timezone.TZ).Format(time.RFC3339),
+				distance,
+				log.AccessTokenName.String,
+			}); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+
+	profileRoutes.POST("/delete", func(c echo.Context) error {
+		values, rawValues, err := Bind[views.UserDeleteValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to bind values")
+			return Render(c, 422, views.UserDeleteForm(components.NewFormError("Erreur inatendue", rawValues)))
+		}
+
+		model := components.NewFormModel(rawValues, Validate(c, values))
+		if model.HasError() {
+			return Render(c, 422, views.UserDeleteForm(model))
+		}
+
+		deleted, err := db.Q(c).DeleteUser(c.Request().Context(), ctx.GetUserFromEcho(c).ID)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to delete user")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserDeleteForm(model))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserDeleteForm(model))
+		}
+
+		logger.Log.Info().Stringer("user", deleted.ID).Msg("Account deleted by its user")
+
+		if err := CleanupDeletedAccount(c.Request().Context(), deleted.ID); err != nil {
+			logger.Log.Error().Err(err).Stringer("user", deleted.ID).Msg("Failed to clean up deleted account")
+		}
+
+		if err := mails.SendMail(c.Request().Context(), deleted,
+			"Votre compte Woody Wood Gate a été supprimé",
+			emails.AccountDeletedByUser(),
+		); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to send deleted account notification")
+		}
+
+		c.SetCookie(createCookie("", -1))
+		return Redirect(c, "/login")
+	})
+}
+
+// CleanupDeletedAccount removes the files of a deleted account, the database rows are removed by cascade
+func CleanupDeletedAccount(c context.Context, userID uuid.UUID) error {
+	return proofs.Delete(c, userID)
+}
+
+type exportedAccount struct {
+	Email             string     `json:"email"`
+	FullName          string     `json:"full_name"`
+	Apartment         string     `json:"apartment"`
+	Role              string     `json:"role"`
+	EmailVerified     bool       `json:"email_verified"`
+	RegistrationState string     `json:"registration_state"`
+	CreatedAt         time.Time  `json:"created_at"`
+	LastRegistration  *time.Time `json:"last_registration,omitempty"`
+	TOTPEnabled       bool       `json:"totp_enabled"`
+}
+
+type exportedItem struct {
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type exportedSession struct {
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+type exportedLog struct {
+	CreatedAt time.Time `json:"created_at"`
+	Distance  *float64  `json:"distance,omitempty"`
+	Token     string    `json:"token,omitempty"`
+}
+
+type userExport struct {
+	Account      exportedAccount   `json:"account"`
+	Identities   []exportedItem    `json:"identities"`
+	Passkeys     []exportedItem    `json:"passkeys"`
+	AccessTokens []exportedItem    `json:"access_tokens"`
+	Sessions     []exportedSession `json:"sessions"`
+	Logs         []exportedLog     `json:"logs"`
+}
+
+// exportUserData gathers the personal data of the user, without the secrets (password hash, keys...)
+func exportUserData(c echo.Context, user db.User) (*userExport, error) {
+	q := db.Q(c)
+	reqCtx := c.Request().Context()
+
+	export := &userExport{
+		Account: exportedAccount{
+			Email:             user.Email,
+			FullName:          user.FullName,
+			Apartment:         user.Apartment,
+			Role:              user.Role,
+			EmailVerified:     user.EmailVerified,
+			RegistrationState: user.RegistrationState,
+			CreatedAt:         user.CreatedAt.Time,
+			LastRegistration:  optionalTime(user.LastRegistration),
+			TOTPEnabled:       user.TotpEnabled,
+		},
+		Identities:   []exportedItem{},
+		Passkeys:     []exportedItem{},
+		AccessTokens: []exportedItem{},
+		Sessions:     []exportedSession{},
+		Logs:         []exportedLog{},
+	}
+
+	identities, err := q.ListUserIdentitiesByUser(reqCtx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	for _, identity := range identities {
+		export.Identities = append(export.Identities, exportedItem{
+			Name:       identity.Issuer + " (" + identity.Email + ")",
+			CreatedAt:  identity.CreatedAt.Time,
+			LastUsedAt: optionalTime(identity.LastUsedAt),
+		})
+	}
+
+	passkeys, err := q.ListWebauthnCredentialsByUser(reqCtx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+	for _, passkey := range passkeys {
+		export.Passkeys = append(export.Passkeys, exportedItem{
+			Name:       passkey.Name,
+			CreatedAt:  passkey.CreatedAt.Time,
+			LastUsedAt: optionalTime(passkey.LastUsedAt),
+		})
+	}
+
+	accessTokens, err := q.ListActiveAccessTokensByUser(reqCtx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list access tokens: %w", err)
+	}
+	for _, token := range accessTokens {
+		export.AccessTokens = append(export.AccessTokens, exportedItem{
+			Name:       token.Name,
+			CreatedAt:  token.CreatedAt.Time,
+			LastUsedAt: optionalTime(token.LastUsedAt),
+		})
+	}
+
+	sessions, err := q.ListActiveSessionsByUser(reqCtx, db.ListActiveSessionsByUserParams{
+		UserID:     user.ID,
+		MaxAgeDays: int32(config.Config.Http.JWT.MaxAge),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, exportedSession{
+			UserAgent:  session.UserAgent,
+			IP:         session.Ip,
+			CreatedAt:  session.CreatedAt.Time,
+			LastSeenAt: session.LastSeenAt.Time,
+		})
+	}
+
+	logs, err := q.ListLogsByUser(reqCtx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list logs: %w", err)
+	}
+	for _, log := range logs {
+		l := exportedLog{CreatedAt: log.CreatedAt.Time, Token: log.AccessTokenName.String}
+		if log.Distance.Valid {
+			l.Distance = &log.Distance.Float64
+		}
+		export.Logs = append(export.Logs, l)
+	}
+
+	return export, nil
+}
+
+func optionalTime(t pgtype.Timestamp) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/decisions"
+	"woody-wood-portail/cmd/services/mails"
+	"woody-wood-portail/cmd/services/proofs"
+	"woody-wood-portail/views"
+	"woody-wood-portail/views/components"
+	"woody-wood-portail/views/emails"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+func registerRenewalHandlers(registrationsGroup *echo.Group) {
+	registrationsGroup.PUT("/:id/renewal/:action", func(c echo.Context) error {
+		model := &views.AdminUserRowModel{}
+
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			model.Err = errors.New("id d'utilisateur invalide")
+			return Render(c, 422, views.AdminRenewalRow(model))
+		}
+
+		model.User, err = db.Q(c).GetUser(c.Request().Context(), userID)
+		if err != nil || !model.User.RenewalRequestedAt.Valid {
+			model.Err = errors.New("demande de renouvellement introuvable")
+			return Render(c, 422, views.AdminRenewalRow(model))
+		}
+
+		switch c.Param("action") {
+		case "accept":
+			model.User, err = db.Q(c).RenewRegistration(c.Request().Context(), userID)
+			if err != nil {
+				model.Err = errors.New("échec du renouvellement")
+				logger.Log.Error().Err(err).Msg("Failed to accept registration renewal")
+				return Render(c, 422, views.AdminRenewalRow(model))
+			}
+		case "reject":
+			decision, err := readDecision(c)
+			if err != nil {
+				model.Err = err
+				return Render(c, 422, views.AdminRenewalRow(model))
+			}
+
+			reason, comment := decisions.Params(decision.Reason, decision.Comment)
+			model.User, err = db.Q(c).RenewalRejected(c.Request().Context(), db.RenewalRejectedParams{
+				ID:                  userID,
+				DecisionReason:      reason,
+				DecisionComment:     comment,
+				ResubmissionAllowed: decision.AllowResubmission,
+			})
+			if err != nil {
+				model.Err = errors.New("échec du refus")
+				logger.Log.Error().Err(err).Msg("Failed to reject registration renewal")
+				return Render(c, 422, views.AdminRenewalRow(model))
+			}
+		default:
+			model.Err = errors.New("action inconnue")
+			return Render(c, 422, views.AdminRenewalRow(model))
+		}
+
+		if err := db.Commit(c); err != nil {
+			model.Err = errors.New("échec de l'enregistrement")
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			return Render(c, 422, views.AdminRenewalRow(model))
+		}
+
+		// The review is saved, the cleanup and the notification can't roll it back
+		if err := proofs.Delete(c.Request().Context(), userID); err != nil {
+			logger.Log.Err(err).Stringer("user", userID).Msg("failed to clean up address proof")
+		}
+
+		subject, mail := "Inscription à Woody Wood Gate renouvellée", emails.RegistrationRenewed()
+		if model.User.RegistrationState == "rejected" {
+			subject, mail = "Renouvellement de votre inscription à Woody Wood Gate refusé", emails.RenewalRejected(model.User)
+		}
+		if err := mails.SendMail(c.Request().Context(), model.User, subject, mail); err != nil {
+			logger.Log.Error().Err(err).Stringer("user", userID).Msg("failed to send renewal review email")
+		}
+
+		logger.Log.Info().Stringer("user", userID).Str("action", c.Param("action")).Msg("registration renewal reviewed")
+		if model.User.RegistrationState == "rejected" {
+			return Render(c, 200, components.OOB("beforeend:#rejected-list", views.AdminRejectedRow(model)))
+		}
+		return c.NoContent(200)
+	})
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"woody-wood-portail/cmd/config"
+	"woody-wood-portail/cmd/ctx"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/cookies"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	csrfHeader    = "X-CSRF-Token"
+	csrfCookie    = "_csrf"
+	hstsMaxAge    = 365 * 24 * 60 * 60
+	cspDirectives = "default-src 'self'; script-src 'self' 'nonce-%s'; style-src 'self'; img-src 'self' data:; " +
+		"connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+)
+
+// SecurityMiddlewares returns the middlewares adding the security headers and checking the CSRF token of the requests
+func SecurityMiddlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{
+		middleware.SecureWithConfig(middleware.SecureConfig{
+			XSSProtection:      "0",
+			ContentTypeNosniff: "nosniff",
+			XFrameOptions:      "DENY",
+			HSTSMaxAge:         hstsMaxAge,
+			ReferrerPolicy:     "same-origin",
+		}),
+		contentSecurityPolicyMiddleware,
+		middleware.CSRFWithConfig(middleware.CSRFConfig{
+			// The gate and the API are authenticated by a header, which can't be sent by another website
+			Skipper: func(c echo.Context) bool {
+				path := c.Request().URL.Path
+				return strings.HasPrefix(path, "/gate") || strings.HasPrefix(path, "/api/")
+			},
+			TokenLookup:    "header:" + csrfHeader + ",form:_csrf",
+			ContextKey:     ctx.CSRFEchoKey,
+			CookieName:     csrfCookie,
+			CookiePath:     "/",
+			CookieHTTPOnly: true,
+			CookieSecure:   cookies.Secure(),
+			CookieSameSite: http.SameSiteLaxMode,
+			ErrorHandler: func(err error, c echo.Context) error {
+				logger.Log.Info().Err(err).Str("path", c.Request().URL.Path).Msg("Invalid CSRF token")
+				return c.String(403, "Votre session a expiré, veuillez recharger la page")
+			},
+		}),
+	}
+}
+
+// contentSecurityPolicyMiddleware only allows the scripts of the application, inline scripts must use the nonce of the request
+func contentSecurityPolicyMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		raw := make([]byte, 16)
+		if _, err := rand.Read(raw); err != nil {
+			return fmt.Errorf("failed to generate CSP nonce: %w", err)
+		}
+		nonce := base64.StdEncoding.EncodeToString(raw)
+
+		c.Set(ctx.NonceEchoKey, nonce)
+		c.Response().Header().Set("Content-Security-Policy", fmt.Sprintf(cspDirectives, nonce))
+		return next(c)
+	}
+}
+
+// RequireAllowedIPMiddleware rejects the requests which are not coming from the configured IP addresses
+func RequireAllowedIPMiddleware() echo.MiddlewareFunc {
+	networks := parseAllowedIPs(config.Config.Http.AdminAllowedIPs)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if len(networks) == 0 {
+				return next(c)
+			}
+
+			ip := net.ParseIP(c.RealIP())
+			for _, network := range networks {
+				if ip != nil && network.Contains(ip) {
+					return next(c)
+				}
+			}
+
+			logger.Log.Warn().Str("ip", c.RealIP()).Str("path", c.Request().URL.Path).Msg("Administration access refused from this IP")
+			return c.String(403, "Accès refusé depuis cette adresse IP")
+		}
+	}
+}
+
+func parseAllowedIPs(allowed string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, entry := range strings.Split(allowed, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Str("entry", entry).Msg("Invalid IP address in HTTP.ADMIN_ALLOWED_IPS")
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
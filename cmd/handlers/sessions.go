@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"fmt"
+	"woody-wood-portail/cmd/config"
+	ctx "woody-wood-portail/cmd/ctx/auth"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/auth"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/permissions"
+	"woody-wood-portail/views"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+func registerSessionUserHandlers(userRoutes *echo.Group) {
+	sessionsRoutes := userRoutes.Group("/sessions")
+
+	sessionsRoutes.GET("", func(c echo.Context) error {
+		model, err := newUserSessionsModel(c)
+		if err != nil {
+			return err
+		}
+
+		return Render(c, 200, views.UserSessionsPage(model))
+	})
+
+	sessionsRoutes.DELETE("", func(c echo.Context) error {
+		currentUser := ctx.GetUserFromEcho(c)
+		current, _ := ctx.GetSessionFromEcho(c)
+
+		revoked, err := db.Q(c).RevokeOtherSessions(c.Request().Context(), db.RevokeOtherSessionsParams{
+			UserID: currentUser.ID,
+			ID:     current.ID,
+		})
+		if err != nil {
+			logger.Log.Error().Err(err).Stringer("user", currentUser.ID).Msg("Failed to revoke sessions")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		model, err := newUserSessionsModel(c)
+		if err != nil {
+			return err
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		logger.Log.Info().Stringer("user", currentUser.ID).Int64("revoked", revoked).Msg("Other sessions revoked")
+
+		return Render(c, 200, views.UserSessionsList(model))
+	})
+
+	sessionsRoutes.DELETE("/:id", func(c echo.Context) error {
+		currentUser := ctx.GetUserFromEcho(c)
+
+		sessionID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(404, "Failed to parse session ID: "+err.Error())
+		}
+
+		if _, err := db.Q(c).RevokeSession(c.Request().Context(), db.RevokeSessionParams{
+			ID:     sessionID,
+			UserID: currentUser.ID,
+		}); err != nil {
+			logger.Log.Error().Err(err).Stringer("session", sessionID).Msg("Failed to revoke session")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		logger.Log.Info().Stringer("user", currentUser.ID).Stringer("session", sessionID).Msg("Session revoked")
+
+		if current, _ := ctx.GetSessionFromEcho(c); current.ID == sessionID {
+			return Redirect(c, "/logout")
+		}
+
+		return c.NoContent(200)
+	})
+}
+
+func registerSessionAdminHandlers(usersGroup *echo.Group) {
+	usersGroup.DELETE("/:id/sessions", func(c echo.Context) error {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(404, "Failed to parse user ID: "+err.Error())
+		}
+
+		revoked, err := db.Q(c).RevokeUserSessions(c.Request().Context(), userID)
+		if err != nil {
+			logger.Log.Error().Err(err).Stringer("user", userID).Msg("Failed to revoke user sessions")
+			return Render(c, 422, views.AdminSessionsRevoked("Une erreur inatendue est survenue", 0))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			return Render(c, 422, views.AdminSessionsRevoked("Une erreur inatendue est survenue", 0))
+		}
+
+		logger.Log.Info().Stringer("user", userID).Stringer("admin", ctx.GetUserFromEcho(c).ID).Int64("revoked", revoked).Msg("User sessions revoked by an admin")
+
+		return Render(c, 200, views.AdminSessionsRevoked("", revoked))
+	}, RequirePermissionMiddleware(permissions.ManageUsers))
+}
+
+// revokeSessionOfToken revokes the session of an authentication token, if it is still valid
+func revokeSessionOfToken(c echo.Context, token string) {
+	sessionID, err := auth.ParseSessionID(token)
+	if err != nil {
+		logger.Log.Debug().Err(err).Msg("no session to revoke")
+		return
+	}
+
+	user, err := auth.ParseToken(c, token, auth.WithAudience(auth.AuthAudience))
+	if err != nil {
+		logger.Log.Debug().Err(err).Msg("no session to revoke")
+		return
+	}
+
+	if _, err := db.QGlobal().RevokeSession(c.Request().Context(), db.RevokeSessionParams{
+		ID:     sessionID,
+		UserID: user.ID,
+	}); err != nil {
+		logger.Log.Error().Err(err).Stringer("session", sessionID).Msg("Failed to revoke session on logout")
+	}
+}
+
+func newUserSessionsModel(c echo.Context) (*views.UserSessionsModel, error) {
+	sessions, err := db.Q(c).ListActiveSessionsByUser(c.Request().Context(), db.ListActiveSessionsByUserParams{
+		UserID:     ctx.GetUserFromEcho(c).ID,
+		MaxAgeDays: int32(config.Config.Http.JWT.MaxAge),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	current, _ := ctx.GetSessionFromEcho(c)
+	return &views.UserSessionsModel{Sessions: sessions, CurrentID: current.ID}, nil
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+	"woody-wood-portail/cmd/config"
+	ctx "woody-wood-portail/cmd/ctx/auth"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/mails"
+	"woody-wood-portail/cmd/services/permissions"
+	"woody-wood-portail/cmd/services/throttle"
+	"woody-wood-portail/views/emails"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// Same message for unknown emails, wrong passwords and locked accounts, to not reveal which accounts exist
+const invalidCredentialsMessage = "Email ou mot de passe invalide"
+
+func registerLockoutAdminHandlers(usersGroup *echo.Group) {
+	usersGroup.DELETE("/:id/lockout", func(c echo.Context) error {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(404, "Failed to parse user ID: "+err.Error())
+		}
+
+		if err := db.Q(c).DeleteLoginLockout(c.Request().Context(), userID); err != nil {
+			logger.Log.Error().Err(err).Stringer("user", userID).Msg("Failed to unlock account")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			return c.String(500, "Erreur inatendue")
+		}
+
+		logger.Log.Info().Stringer("user", userID).Stringer("admin", ctx.GetUserFromEcho(c).ID).Msg("Account unlocked by an admin")
+
+		return c.NoContent(200)
+	}, RequirePermissionMiddleware(permissions.ManageUsers))
+}
+
+// countRecentIPAttempts returns the number of attempts of the given kind made recently from the client IP address
+func countRecentIPAttempts(c echo.Context, kind string) (int64, error) {
+	return db.QGlobal().CountAuthAttemptsByIP(c.Request().Context(), db.CountAuthAttemptsByIPParams{
+		Kind:      kind,
+		Ip:        c.RealIP(),
+		CreatedAt: pgtype.Timestamp{Time: throttle.Since(), Valid: true},
+	})
+}
+
+// recordAuthAttempt is saved outside of the request transaction, since failed attempts are never committed
+func recordAuthAttempt(c echo.Context, kind string, email string) {
+	if err := db.QGlobal().CreateAuthAttempt(c.Request().Context(), db.CreateAuthAttemptParams{
+		Kind:  kind,
+		Ip:    c.RealIP(),
+		Email: email,
+	}); err != nil {
+		logger.Log.Error().Err(err).Str("kind", kind).Msg("Unable to record authentication attempt")
+	}
+}
+
+// emailChangeThrottled returns true if the user asked for too many email changes recently, or if a confirmation was
+// already sent to the target address, so that the form can't be used to send mails to any address
+func emailChangeThrottled(c echo.Context, user db.User, target string) (bool, error) {
+	accountRequests, err := db.QGlobal().CountAuthAttemptsByEmail(c.Request().Context(), db.CountAuthAttemptsByEmailParams{
+		Kind:      throttle.KindEmailChangeAccount,
+		Email:     user.ID.String(),
+		CreatedAt: pgtype.Timestamp{Time: throttle.Since(), Valid: true},
+	})
+	if err != nil {
+		return true, err
+	}
+
+	targetRequests, err := db.QGlobal().CountAuthAttemptsByEmail(c.Request().Context(), db.CountAuthAttemptsByEmailParams{
+		Kind:      throttle.KindEmailChange,
+		Email:     target,
+		CreatedAt: pgtype.Timestamp{Time: time.Now().Add(-config.Config.Users.Login.ResetMailInterval), Valid: true},
+	})
+	if err != nil {
+		return true, err
+	}
+
+	if accountRequests >= int64(config.Config.Users.Login.MaxIPResetMails) || targetRequests > 0 {
+		logger.Log.Warn().Stringer("user", user.ID).Int64("requests", accountRequests).Int64("target_requests", targetRequests).Msg("Too many email change requests")
+		return true, nil
+	}
+
+	recordAuthAttempt(c, throttle.KindEmailChangeAccount, user.ID.String())
+	recordAuthAttempt(c, throttle.KindEmailChange, target)
+	return false, nil
+}
+
+// isAccountLocked returns true if the account is locked because of too many failed logins
+func isAccountLocked(c echo.Context, userID uuid.UUID) (bool, error) {
+	lockout, err := db.QGlobal().GetLoginLockout(c.Request().Context(), userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	return lockout.LockedUntil.Valid && lockout.LockedUntil.Time.After(time.Now()), nil
+}
+
+// recordLoginFailure counts a failed login of an account, and locks it if there were too many consecutive failures
+func recordLoginFailure(c echo.Context, user db.User) {
+	lockout, err := db.QGlobal().RecordLoginFailure(c.Request().Context(), user.ID)
+	if err != nil {
+		logger.Log.Error().Err(err).Stringer("user", user.ID).Msg("Unable to record login failure")
+		return
+	}
+
+	duration := throttle.LockDuration(lockout.FailedLogins)
+	if duration == 0 {
+		return
+	}
+
+	lockedUntil := time.Now().Add(duration)
+	if err := db.QGlobal().LockAccount(c.Request().Context(), db.LockAccountParams{
+		UserID:      user.ID,
+		LockedUntil: pgtype.Timestamp{Time: lockedUntil, Valid: true},
+	}); err != nil {
+		logger.Log.Error().Err(err).Stringer("user", user.ID).Msg("Unable to lock account")
+		return
+	}
+
+	logger.Log.Warn().Stringer("user", user.ID).Int32("failures", lockout.FailedLogins).Time("until", lockedUntil).Msg("Account locked after too many failed logins")
+
+	if err := mails.SendMail(c.Request().Context(), user,
+		"Votre compte Woody Wood Gate a été verrouillé",
+		emails.AccountLocked(user, lockedUntil),
+	); err != nil {
+		logger.Log.Error().Err(err).Stringer("user", user.ID).Msg("Unable to send account locked email")
+	}
+}
+
+// loginFailed records the failure, then waits longer as failures from the same IP address accumulate
+func loginFailed(c echo.Context, user *db.User, email string, recentIPFailures int64) {
+	recordAuthAttempt(c, throttle.KindLoginFailure, email)
+	if user != nil {
+		recordLoginFailure(c, *user)
+	}
+
+	<-time.After(throttle.Delay(recentIPFailures + 1))
+}
@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+	"woody-wood-portail/cmd/config"
+	ctx "woody-wood-portail/cmd/ctx/auth"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/auth"
+	"woody-wood-portail/cmd/services/cookies"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/permissions"
+	"woody-wood-portail/views"
+	"woody-wood-portail/views/components"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	totpCookieName = "totp"
+	// Time allowed to enter the TOTP code after a successful password check
+	totpLoginTimeout = 5 * time.Minute
+)
+
+func registerTOTPLoginHandlers(authGroup *echo.Group) {
+	authGroup.GET("/login/totp", func(c echo.Context) error {
+		if _, err := getTOTPPendingUser(c); err != nil {
+			logger.Log.Debug().Err(err).Msg("no pending two-factor login")
+			return RedirectWitQuery(c, "/login")
+		}
+
+		return Render(c, 200, views.LoginTOTPPage())
+	})
+
+	authGroup.POST("/login/totp", func(c echo.Context) error {
+		user, err := getTOTPPendingUser(c)
+		if err != nil {
+			logger.Log.Debug().Err(err).Msg("no pending two-factor login")
+			return RedirectWitQuery(c, "/login")
+		}
+
+		if locked, err := isAccountLocked(c, user.ID); err != nil || locked {
+			logger.Log.Info().Err(err).Stringer("user", user.ID).Msg("Two-factor login attempt on a locked account")
+			c.SetCookie(cookies.Clear(totpCookieName))
+			return RedirectWitQuery(c, "/login")
+		}
+
+		values, rawValues, err := Bind[views.TOTPFormValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to get form params")
+			return Render(c, 422, views.LoginTOTPForm(components.NewFormError("Erreur inatendue")))
+		}
+
+		model := components.NewFormModel(rawValues, Validate(c, values))
+		if model.HasError() {
+			return Render(c, 422, views.LoginTOTPForm(model))
+		}
+
+		valid, err := auth.ValidateTOTP(c.Request().Context(), db.Q(c), *user, values.Code)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to check two-factor code")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.LoginTOTPForm(model))
+		}
+
+		if !valid {
+			used, err := db.Q(c).UseTOTPRecoveryCode(c.Request().Context(), db.UseTOTPRecoveryCodeParams{
+				UserID:   user.ID,
+				CodeHash: auth.HashRecoveryCode(values.Code),
+			})
+			if err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to check recovery code")
+				model.Errors.Global = "Erreur inatendue"
+				return Render(c, 422, views.LoginTOTPForm(model))
+			}
+			if used == 0 {
+				logger.Log.Info().Stringer("user", user.ID).Msg("Invalid two-factor code")
+				recordLoginFailure(c, *user)
+				model.Errors.Fields["Code"] = "Code invalide"
+				return Render(c, 422, views.LoginTOTPForm(model))
+			}
+
+			logger.Log.Info().Stringer("user", user.ID).Msg("Recovery code used to login")
+		}
+
+		// Consumes the TOTP time step or the recovery code
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.LoginTOTPForm(model))
+		}
+
+		c.SetCookie(cookies.Clear(totpCookieName))
+		if err := addAuthenticationCookie(c, user.ID); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to add authentication cookie")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.LoginTOTPForm(model))
+		}
+
+		return Redirect(c, localRedirect(c.QueryParam("redirect"), "/user/"))
+	})
+}
+
+func registerTOTPUserHandlers(userRoutes *echo.Group) {
+	totpRoutes := userRoutes.Group("/totp")
+
+	totpRoutes.GET("", func(c echo.Context) error {
+		model, err := newUserTOTPModel(c, ctx.GetUserFromEcho(c))
+		if err != nil {
+			return err
+		}
+
+		return Render(c, 200, views.UserTOTPPage(model))
+	})
+
+	// Draws the secret of a new enrolment, it is only enabled once a code is verified
+	totpRoutes.PUT("", func(c echo.Context) error {
+		currentUser, err := db.Q(c).GetUser(c.Request().Context(), ctx.GetUserFromEcho(c).ID)
+		if err != nil {
+			return fmt.Errorf("failed to get current user: %w", err)
+		}
+
+		if !currentUser.TotpEnabled {
+			key, err := auth.GenerateTOTPKey(currentUser)
+			if err != nil {
+				return fmt.Errorf("failed to generate TOTP key: %w", err)
+			}
+
+			if err := db.Q(c).SetTOTPSecret(c.Request().Context(), db.SetTOTPSecretParams{
+				ID:         currentUser.ID,
+				TotpSecret: key.Secret(),
+			}); err != nil {
+				return fmt.Errorf("failed to save TOTP secret: %w", err)
+			}
+
+			if err := db.Commit(c); err != nil {
+				return fmt.Errorf("failed to save TOTP secret: %w", err)
+			}
+			currentUser.TotpSecret = key.Secret()
+		}
+
+		model, err := newUserTOTPModel(c, currentUser)
+		if err != nil {
+			return err
+		}
+
+		return Render(c, 200, views.UserTOTPForm(model))
+	})
+
+	totpRoutes.POST("", func(c echo.Context) error {
+		currentUser, err := db.Q(c).GetUser(c.Request().Context(), ctx.GetUserFromEcho(c).ID)
+		if err != nil {
+			return fmt.Errorf("failed to get current user: %w", err)
+		}
+
+		values, rawValues, err := Bind[views.TOTPFormValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to get form params")
+			return Render(c, 422, views.UserTOTPForm(&views.UserTOTPModel{FormModel: components.NewFormError("Erreur inatendue")}))
+		}
+
+		model, err := newUserTOTPModel(c, currentUser)
+		if err != nil {
+			return err
+		}
+		model.FormModel = components.NewFormModel(rawValues, Validate(c, values))
+
+		if model.Enabled {
+			return Render(c, 200, views.UserTOTPForm(model))
+		}
+
+		if model.Secret == "" {
+			model.Errors.Global = "La configuration a expiré, veuillez recommencer"
+			return Render(c, 422, views.UserTOTPForm(model))
+		}
+		if !model.HasError() {
+			valid, err := auth.ValidateTOTP(c.Request().Context(), db.Q(c), currentUser, values.Code)
+			if err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to check two-factor code")
+				model.Errors.Global = "Erreur inatendue"
+			} else if !valid {
+				model.Errors.Fields["Code"] = "Code invalide"
+			}
+		}
+		if model.HasError() {
+			return Render(c, 422, views.UserTOTPForm(model))
+		}
+
+		if err := db.Q(c).EnableTOTP(c.Request().Context(), currentUser.ID); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to enable TOTP")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserTOTPForm(model))
+		}
+
+		codes, hashes, err := auth.GenerateRecoveryCodes()
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to generate recovery codes")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserTOTPForm(model))
+		}
+
+		for _, hash := range hashes {
+			if err := db.Q(c).CreateTOTPRecoveryCode(c.Request().Context(), db.CreateTOTPRecoveryCodeParams{
+				UserID:   currentUser.ID,
+				CodeHash: hash,
+			}); err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to save recovery codes")
+				model.Errors.Global = "Erreur inatendue"
+				return Render(c, 422, views.UserTOTPForm(model))
+			}
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserTOTPForm(model))
+		}
+
+		logger.Log.Info().Stringer("user", currentUser.ID).Msg("Two-factor authentication enabled")
+
+		return Render(c, 200, views.UserTOTPForm(&views.UserTOTPModel{Enabled: true, RecoveryCodes: codes}))
+	})
+
+	totpRoutes.DELETE("", func(c echo.Context) error {
+		currentUser, err := db.Q(c).GetUser(c.Request().Context(), ctx.GetUserFromEcho(c).ID)
+		if err != nil {
+			return fmt.Errorf("failed to get current user: %w", err)
+		}
+
+		values, rawValues, err := Bind[views.TOTPFormValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to get form params")
+			return Render(c, 422, views.UserTOTPForm(&views.UserTOTPModel{Enabled: true, FormModel: components.NewFormError("Erreur inatendue")}))
+		}
+
+		model := &views.UserTOTPModel{
+			FormModel: components.NewFormModel(rawValues, Validate(c, values)),
+			Enabled:   true,
+		}
+
+		if !model.HasError() {
+			valid, err := auth.ValidateTOTP(c.Request().Context(), db.Q(c), currentUser, values.Code)
+			if err != nil {
+				logger.Log.Error().Err(err).Msg("Unable to check two-factor code")
+				model.Errors.Global = "Erreur inatendue"
+			} else if !valid {
+				model.Errors.Fields["Code"] = "Code invalide"
+			}
+		}
+		if model.HasError() {
+			return Render(c, 422, views.UserTOTPForm(model))
+		}
+
+		if err := resetTOTP(c, currentUser.ID); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to disable TOTP")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserTOTPForm(model))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Unable to commit transaction")
+			model.Errors.Global = "Erreur inatendue"
+			return Render(c, 422, views.UserTOTPForm(model))
+		}
+
+		logger.Log.Info().Stringer("user", currentUser.ID).Msg("Two-factor authentication disabled")
+
+		return Redirect(c, "/user/totp")
+	})
+}
+
+func registerTOTPAdminHandlers(usersGroup *echo.Group) {
+	usersGroup.DELETE("/:id/totp", func(c echo.Context) error {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return c.String(404, "Failed to parse user ID: "+err.Error())
+		}
+
+		if err := resetTOTP(c, userID); err != nil {
+			logger.Log.Error().Err(err).Stringer("user", userID).Msg("Failed to reset TOTP")
+			return Render(c, 422, views.AdminTOTPReset("Une erreur inatendue est survenue"))
+		}
+
+		if err := db.Commit(c); err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+			return Render(c, 422, views.AdminTOTPReset("Une erreur inatendue est survenue"))
+		}
+
+		logger.Log.Info().Stringer("user", userID).Stringer("admin", ctx.GetUserFromEcho(c).ID).Msg("Two-factor authentication reset by an admin")
+
+		return Render(c, 200, views.AdminTOTPReset(""))
+	}, RequirePermissionMiddleware(permissions.ManageUsers))
+}
+
+// RequireTOTPMiddleware forces users with administration permissions to enable two-factor authentication, if configured
+func RequireTOTPMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := ctx.GetUserFromEcho(c)
+		if config.Config.Users.RequireAdminTOTP && !user.TotpEnabled {
+			logger.Log.Debug().Stringer("user", user.ID).Msg("two-factor authentication required to access admin pages")
+			return Redirect(c, "/user/totp")
+		}
+		return next(c)
+	}
+}
+
+// addTOTPPendingCookie remembers for a short time that the user has entered a valid password
+func addTOTPPendingCookie(c echo.Context, userID uuid.UUID) error {
+	token, err := auth.CreateToken(userID, auth.TOTPAudience)
+	if err != nil {
+		return fmt.Errorf("unable to create two-factor token: %w", err)
+	}
+
+	c.SetCookie(cookies.New(totpCookieName, token, int(totpLoginTimeout.Seconds())))
+	return nil
+}
+
+func getTOTPPendingUser(c echo.Context) (*db.User, error) {
+	cookie, err := c.Cookie(totpCookieName)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.ParseToken(c, cookie.Value,
+		auth.WithAudience(auth.TOTPAudience),
+		auth.IssuedWithin(totpLoginTimeout),
+	)
+}
+
+func newUserTOTPModel(c echo.Context, user db.User) (*views.UserTOTPModel, error) {
+	model := &views.UserTOTPModel{
+		FormModel: components.NewFormModel(nil, nil),
+		Enabled:   user.TotpEnabled,
+		Required:  config.Config.Users.RequireAdminTOTP && ctx.HasPermission(c, permissions.All...),
+	}
+
+	if user.TotpEnabled {
+		remaining, err := db.Q(c).CountUnusedTOTPRecoveryCodes(c.Request().Context(), user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count recovery codes: %w", err)
+		}
+		model.RemainingRecoveryCodes = remaining
+		return model, nil
+	}
+
+	// The pending secret is kept to not invalidate an already scanned QR code, a new one is only drawn on request
+	if user.TotpSecret == "" {
+		return model, nil
+	}
+	key, err := auth.TOTPKeyFromSecret(user)
+	if err != nil {
+		logger.Log.Error().Err(err).Stringer("user", user.ID).Msg("invalid pending TOTP secret")
+		return model, nil
+	}
+
+	qrPNG, err := qrcode.Encode(key.URL(), qrcode.Medium, 256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render TOTP QR code: %w", err)
+	}
+
+	model.Secret = key.Secret()
+	model.QrCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrPNG)
+	return model, nil
+}
+
+func resetTOTP(c echo.Context, userID uuid.UUID) error {
+	if err := db.Q(c).ResetTOTP(c.Request().Context(), userID); err != nil {
+		return err
+	}
+	return db.Q(c).DeleteTOTPRecoveryCodes(c.Request().Context(), userID)
+}
@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	ctx "woody-wood-portail/cmd/ctx/auth"
 	"woody-wood-portail/cmd/logger"
 	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/geofence"
 	"woody-wood-portail/views"
 
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/labstack/echo/v4"
 )
 
@@ -13,28 +17,107 @@ func RegisterUserHandlers(e RequireAuth, model *Model, openChannel chan struct{}
 	userRoutes := e.Group.Group("/user")
 
 	userHandler := func(c echo.Context) error {
-		return Render(c, 200, views.UserPage(len(model.Gates) > 0))
+		user := ctx.GetUserFromEcho(c)
+
+		lockedDown, lockdown, err := isLockedDownFor(c, user)
+		if err != nil {
+			return fmt.Errorf("failed to get lockdown state: %w", err)
+		}
+
+		pageModel := views.UserPageModel{
+			IsOnline:        len(model.Gates) > 0,
+			RequirePosition: geofence.Required(user.Role),
+			Lockdown:        lockdown.Enabled,
+		}
+		if lockedDown {
+			pageModel.LockdownMessage = lockdownMessage(lockdown)
+		}
+
+		return Render(c, 200, views.UserPage(pageModel))
 	}
 	userRoutes.GET("", userHandler)
 	userRoutes.GET("/", userHandler)
 
 	userRoutes.PUT("/open", func(c echo.Context) error {
-		if len(openChannel) != 0 {
-			return Render(c, 200, views.OpenResult("La porte est déjà en train de s'ouvrir", true))
+		message, ok := openGate(c, ctx.GetUserFromEcho(c), pgtype.UUID{}, openChannel)
+		if !ok {
+			return Render(c, 422, views.OpenResult(message, false))
 		}
+		return Render(c, 200, views.OpenResult(message, true))
+	})
+	registerPasskeyUserHandlers(userRoutes)
+	registerTOTPUserHandlers(userRoutes)
+	registerSessionUserHandlers(userRoutes)
+	registerAccessTokenUserHandlers(userRoutes)
+	registerEmailChangeUserHandlers(userRoutes)
+	registerProfileUserHandlers(userRoutes)
+}
 
-		user := ctx.GetUserFromEcho(c)
-		if _, err := db.Q(c).CreateLog(c.Request().Context(), user.ID); err != nil {
-			logger.Log.Error().Err(err).Msg("Failed to create log")
-			return Render(c, 422, views.OpenResult("Une erreur est survenue", false))
+// openGate checks that the user is allowed to open the gate, logs the request and opens it.
+// The access token is set when the request comes from an automation.
+// The returned message is meant to be displayed to the user.
+func openGate(c echo.Context, user db.User, accessTokenID pgtype.UUID, openChannel chan struct{}) (string, bool) {
+	if len(openChannel) != 0 {
+		return "La porte est déjà en train de s'ouvrir", true
+	}
+
+	lockedDown, lockdown, err := isLockedDownFor(c, user)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("Failed to get lockdown state")
+		return "Une erreur est survenue", false
+	}
+	if lockedDown {
+		logger.Log.Info().Stringer("user", user.ID).Msg("open refused by lockdown")
+		return lockdownMessage(lockdown), false
+	}
+
+	var distance pgtype.Float8
+	if geofence.Required(user.Role) {
+		values, _, err := Bind[views.OpenGateValues](c)
+		if err != nil {
+			logger.Log.Error().Err(err).Msg("Failed to bind position")
+			return "Une erreur est survenue", false
 		}
 
-		if err := db.Commit(c); err != nil {
-			logger.Log.Error().Err(err).Msg("Failed to commit transaction")
-			return Render(c, 422, views.OpenResult("Une érreur est survenue", false))
+		distance.Float64, err = geofence.Check(geofence.Position{
+			Latitude:  values.Latitude,
+			Longitude: values.Longitude,
+			Accuracy:  values.Accuracy,
+		})
+		if err != nil {
+			logger.Log.Info().Err(err).Stringer("user", user.ID).Float64("distance", distance.Float64).Float64("accuracy", values.Accuracy).Msg("open refused by geofence")
+			return geofenceErrorMessage(err), false
 		}
+		distance.Valid = true
+	}
 
-		openChannel <- struct{}{}
-		return Render(c, 200, views.OpenResult("La porte s'ouvre", true))
-	})
+	if _, err := db.Q(c).CreateLog(c.Request().Context(), db.CreateLogParams{
+		UserID:        user.ID,
+		Distance:      distance,
+		AccessTokenID: accessTokenID,
+	}); err != nil {
+		logger.Log.Error().Err(err).Msg("Failed to create log")
+		return "Une erreur est survenue", false
+	}
+
+	if err := db.Commit(c); err != nil {
+		logger.Log.Error().Err(err).Msg("Failed to commit transaction")
+		return "Une érreur est survenue", false
+	}
+
+	openChannel <- struct{}{}
+	return "La porte s'ouvre", true
+}
+
+func geofenceErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, geofence.ErrPositionMissing):
+		return "Votre position est nécessaire pour ouvrir le portail, veuillez autoriser la géolocalisation"
+	case errors.Is(err, geofence.ErrPositionInaccurate):
+		return "Votre position n'est pas assez précise, veuillez réessayer"
+	case errors.Is(err, geofence.ErrTooFar):
+		return "Vous êtes trop loin du portail pour l'ouvrir"
+	default:
+		return "Une erreur est survenue"
+	}
 }
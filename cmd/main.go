@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -11,12 +13,19 @@ import (
 	"woody-wood-portail/cmd/config"
 	"woody-wood-portail/cmd/handlers"
 	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/auth"
 	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/invitations"
 	"woody-wood-portail/cmd/services/mails"
+	"woody-wood-portail/cmd/services/permissions"
+	"woody-wood-portail/cmd/services/proofs"
+	"woody-wood-portail/cmd/services/storage"
 	"woody-wood-portail/cmd/timezone"
 	"woody-wood-portail/views/emails"
 
+	"github.com/a-h/templ"
 	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/robfig/cron"
 	"github.com/rs/zerolog"
 
@@ -26,12 +35,25 @@ import (
 
 var dailyCronJobs = map[string]func(){
 	"logs cleanup":                 db.DeleteOldLogs,
+	"used tokens cleanup":          db.DeleteOldUsedTokens,
+	"auth attempts cleanup":        db.DeleteOldAuthAttempts,
+	"sessions cleanup":             db.DeleteExpiredSessions,
+	"jwt keys rotation":            auth.RotateKeys,
+	"outdated passwords report":    auth.ReportOutdatedPasswords,
 	"registration expiration mail": sendExpiredRegistrationMails,
+	"invitation codes rotation":    rotateInvitationCodes,
 	"disable expired accounts":     disableExpiredAccounts,
 	"delete old accounts":          deleteOldAccounts,
+	"address proofs purge":         proofs.Purge,
+	"address proofs re-encryption": proofs.Reencrypt,
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate-storage" {
+		migrateStorage(os.Args[2:])
+		return
+	}
+
 	db.Migrate()
 
 	pool, err := db.Connect()
@@ -40,6 +62,20 @@ func main() {
 	}
 	defer pool.Close()
 
+	if err := auth.LoadKeyring(); err != nil {
+		log.Fatalf("Unable to load JWT keys: %v", err)
+	}
+
+	if err := storage.Open(); err != nil {
+		log.Fatalf("Unable to open the storage: %v", err)
+	}
+
+	if err := proofs.LoadKeys(); err != nil {
+		log.Fatalf("Unable to load address proof keys: %v", err)
+	}
+	// Encrypts the proofs stored before the encryption, and the ones of a rotated key, without waiting for the daily job
+	go proofs.Reencrypt()
+
 	c := cron.NewWithLocation(timezone.TZ)
 
 	// Register all cron jobs as daily jobs.
@@ -54,6 +90,8 @@ func main() {
 	c.Start()
 
 	e := echo.New()
+	// Only trust X-Forwarded-For when set by a proxy of a private network, otherwise the client could choose its IP address
+	e.IPExtractor = echo.ExtractIPFromXFFHeader()
 
 	e.Use(logger.LoggerMiddleware())
 	e.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{
@@ -62,6 +100,7 @@ func main() {
 			return err
 		},
 	}))
+	e.Use(handlers.SecurityMiddlewares()...)
 	e.Use(db.TransactionMiddleware())
 
 	e.Static("/static", "static")
@@ -75,10 +114,12 @@ func main() {
 
 	handlers.RegisterAuthHandlers(e)
 	handlers.RegisterGateHandlers(e, &model, openChannel)
+	handlers.RegisterAPIHandlers(e, openChannel)
 
 	requireAuth := handlers.RequireAuthGroup(e)
 	handlers.RegisterUserHandlers(requireAuth, &model, openChannel)
-	handlers.RegisterAdminHandlers(requireAuth, &model)
+	handlers.RegisterAdminHandlers(requireAuth, &model, openChannel)
+	handlers.RegisterImpersonationHandlers(requireAuth)
 
 	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
@@ -100,6 +141,18 @@ func main() {
 	logger.Log.Info().Msg("Server stopped")
 }
 
+// migrateStorage copies the files of the local directories to the configured storage backend:
+// app migrate-storage [-delete-local]
+func migrateStorage(args []string) {
+	flags := flag.NewFlagSet("migrate-storage", flag.ExitOnError)
+	deleteLocal := flags.Bool("delete-local", false, "delete the local files once copied")
+	_ = flags.Parse(args)
+
+	if err := storage.Migrate(context.Background(), *deleteLocal); err != nil {
+		log.Fatalf("Unable to migrate the storage: %v", err)
+	}
+}
+
 type CustomValidator struct {
 	validator *validator.Validate
 }
@@ -127,6 +180,11 @@ func sendExpiredRegistrationMailsFor(days string) {
 	logger.Log.Debug().Str("days", days).Array("users", zerolog.Arr().Interface(usersToRemind)).Msg("sending reminder mail to renew registration")
 
 	for _, user := range usersToRemind {
+		if user.RenewalRequestedAt.Valid {
+			// The renewal already waits for its review
+			continue
+		}
+
 		err := mails.SendMail(context.Background(), user,
 			"Votre inscription à Woody Wood gate va expirer dans "+days+" jours",
 			emails.RegistrationWillExpire(days+" jours"),
@@ -151,12 +209,17 @@ func disableExpiredAccounts() {
 	}
 
 	for _, user := range usersToDisable {
-		if _, err := q.RegistrationSuspended(context.Background(), user.ID); err != nil {
+		suspended, err := q.RegistrationSuspended(context.Background(), user.ID)
+		if err != nil {
 			logger.Log.Error().Err(err).Stringer("user", user.ID).Msg("failed to suspend user")
 			continue
 		}
+		if suspended.RegistrationState == "renewal_pending" {
+			logger.Log.Info().Stringer("user", user.ID).Msg("user account waiting for its renewal review")
+			continue
+		}
 
-		err := mails.SendMail(context.Background(), user,
+		err = mails.SendMail(context.Background(), user,
 			"Votre compte Woody Wood Gate a été suspendu",
 			emails.RegistrationSuspended(),
 		)
@@ -195,6 +258,9 @@ func deleteOldAccounts() {
 		}
 
 		logger.Log.Info().Interface("deleted user", deleted).Msg("old user deleted")
+		if err := handlers.CleanupDeletedAccount(context.Background(), deleted.ID); err != nil {
+			logger.Log.Error().Err(err).Stringer("user", deleted.ID).Msg("failed to clean up deleted user")
+		}
 		if err := mails.SendMail(context.Background(), deleted,
 			"Votre compte Woody Wood Gate a été supprimé",
 			emails.AccountDeleted(),
@@ -206,3 +272,50 @@ func deleteOldAccounts() {
 
 	logger.Log.Info().Array("deleted users", zerolog.Arr().Interface(usersToDelete)).Msg("old users deleted")
 }
+
+func rotateInvitationCodes() {
+	interval := config.Config.Users.Invitation.RotationInterval
+	if interval <= 0 {
+		return
+	}
+
+	q := db.QGlobal()
+	due, err := q.ListInvitationCodesToRotate(context.Background(), pgtype.Timestamp{Time: time.Now().Add(-interval), Valid: true})
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("failed to list invitation codes to rotate")
+		return
+	}
+
+	rotated := make([]db.InvitationCode, 0, len(due))
+	for _, code := range due {
+		newCode, err := invitations.Rotate(context.Background(), q, code.ID)
+		if err != nil {
+			logger.Log.Error().Err(err).Stringer("code", code.ID).Msg("failed to rotate invitation code")
+			continue
+		}
+		rotated = append(rotated, newCode)
+	}
+	if len(rotated) == 0 {
+		return
+	}
+
+	admins, err := q.ListUsersWithPermission(context.Background(), string(permissions.ManageInvitations))
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("failed to list admins to notify of the new invitation codes")
+		return
+	}
+
+	for _, code := range rotated {
+		logger.Log.Info().Stringer("code", code.ID).Str("label", code.Label).Msg("invitation code rotated")
+
+		posterURL := fmt.Sprintf("%s/admin/invitation/%s/poster.pdf", config.Config.Http.BaseURL, code.ID)
+		for _, admin := range admins {
+			if err := mails.SendMail(context.Background(), admin,
+				"Nouveau code d'invitation Woody Wood Gate",
+				emails.InvitationCodeRotated(code, templ.SafeURL(posterURL)),
+			); err != nil {
+				logger.Log.Error().Err(err).Stringer("admin", admin.ID).Msg("failed to send new invitation code mail")
+			}
+		}
+	}
+}
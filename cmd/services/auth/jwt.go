@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"time"
-	"woody-wood-portail/cmd/config"
 	"woody-wood-portail/cmd/logger"
 	"woody-wood-portail/cmd/services/db"
 
@@ -22,16 +21,49 @@ var (
 	AuthAudience              = audience("auth")
 	EmailVerificationAudience = audience("email_verification")
 	ResetPasswordAudience     = audience("reset_password")
+	TOTPAudience              = audience("totp")
+	MagicLinkAudience         = audience("magic_link")
+	EmailChangeAudience       = audience("email_change")
 )
 
 func CreateToken(userID uuid.UUID, audience audience) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.RegisteredClaims{
+	return signToken(&jwt.RegisteredClaims{
 		Subject:  userID.String(),
 		Audience: jwt.ClaimStrings{string(audience)},
 		IssuedAt: &jwt.NumericDate{Time: time.Now()},
 	})
+}
+
+// CreateSingleUseToken creates a token which expires after the given duration, and can be consumed only once with SingleUse
+func CreateSingleUseToken(userID uuid.UUID, audience audience, ttl time.Duration) (string, error) {
+	now := time.Now()
+	return signToken(&jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Subject:   userID.String(),
+		Audience:  jwt.ClaimStrings{string(audience)},
+		IssuedAt:  &jwt.NumericDate{Time: now},
+		ExpiresAt: &jwt.NumericDate{Time: now.Add(ttl)},
+	})
+}
 
-	return token.SignedString([]byte(config.Config.Http.JWT.Secret))
+type emailChangeClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+}
+
+// CreateEmailChangeToken creates a single use token carrying the new email address of the user, to be read with WithNewEmail
+func CreateEmailChangeToken(userID uuid.UUID, email string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	return signToken(&emailChangeClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   userID.String(),
+			Audience:  jwt.ClaimStrings{string(EmailChangeAudience)},
+			IssuedAt:  &jwt.NumericDate{Time: now},
+			ExpiresAt: &jwt.NumericDate{Time: now.Add(ttl)},
+		},
+		Email: email,
+	})
 }
 
 func JWTMiddleware(errorHandler func(c echo.Context, err error) error) echo.MiddlewareFunc {
@@ -39,7 +71,7 @@ func JWTMiddleware(errorHandler func(c echo.Context, err error) error) echo.Midd
 		TokenLookup:            "cookie:authorization",
 		ContinueOnIgnoredError: true,
 		ParseTokenFunc: func(c echo.Context, tokenString string) (interface{}, error) {
-			user, err := ParseToken(c, tokenString, WithAudience(AuthAudience))
+			user, err := ParseToken(c, tokenString, WithAudience(AuthAudience), WithActiveSession(c))
 			if err != nil {
 				return nil, err
 			}
@@ -95,14 +127,6 @@ func ParseToken(c echo.Context, tokenString string, rules ...TokenRule) (*db.Use
 	return &user, nil
 }
 
-func getJwtKey(token *jwt.Token) (interface{}, error) {
-	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-	}
-
-	return []byte(config.Config.Http.JWT.Secret), nil
-}
-
 type audience string
 
 type TokenRule func(user *db.User, token *jwt.Token) error
@@ -136,3 +160,97 @@ func IssuedAfterLastUserUpdate(allowedOverlapping time.Duration) TokenRule {
 		return nil
 	}
 }
+
+func IssuedWithin(maxAge time.Duration) TokenRule {
+	return func(user *db.User, token *jwt.Token) error {
+		issuedAt, err := token.Claims.GetIssuedAt()
+		if err != nil {
+			return &echojwt.TokenError{Token: token, Err: errors.New("missing token issued at")}
+		}
+
+		if issuedAt.Time.Add(maxAge).Before(time.Now()) {
+			return &echojwt.TokenError{Token: token, Err: errors.New("token expired")}
+		}
+
+		return nil
+	}
+}
+
+// WithNewEmail reads the new email address of an email change token into email
+func WithNewEmail(email *string) TokenRule {
+	return func(user *db.User, token *jwt.Token) error {
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			return &echojwt.TokenError{Token: token, Err: errors.New("invalid token claims")}
+		}
+
+		newEmail, _ := claims["email"].(string)
+		if newEmail == "" {
+			return &echojwt.TokenError{Token: token, Err: errors.New("missing token email")}
+		}
+
+		*email = newEmail
+		return nil
+	}
+}
+
+// NotUsed rejects single use tokens which have already been consumed, without consuming it
+func NotUsed(c echo.Context) TokenRule {
+	return func(user *db.User, token *jwt.Token) error {
+		tokenID, err := singleUseTokenID(token)
+		if err != nil {
+			return err
+		}
+
+		used, err := db.Q(c).IsTokenUsed(c.Request().Context(), tokenID)
+		if err != nil {
+			return fmt.Errorf("failed to check if token is used: %w", err)
+		}
+		if used {
+			return &echojwt.TokenError{Token: token, Err: errors.New("token already used")}
+		}
+
+		return nil
+	}
+}
+
+// SingleUse consumes the token, rejecting it if it has already been consumed.
+// The consumption is part of the request transaction, it is only effective once committed.
+func SingleUse(c echo.Context) TokenRule {
+	return func(user *db.User, token *jwt.Token) error {
+		tokenID, err := singleUseTokenID(token)
+		if err != nil {
+			return err
+		}
+
+		inserted, err := db.Q(c).UseToken(c.Request().Context(), db.UseTokenParams{
+			UserID: user.ID,
+			Token:  tokenID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to consume token: %w", err)
+		}
+		if inserted == 0 {
+			return &echojwt.TokenError{Token: token, Err: errors.New("token already used")}
+		}
+
+		return nil
+	}
+}
+
+func singleUseTokenID(token *jwt.Token) (string, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", &echojwt.TokenError{Token: token, Err: errors.New("invalid token claims")}
+	}
+
+	tokenID, _ := claims["jti"].(string)
+	if tokenID == "" {
+		// Tokens created before expiration and single use were introduced have no ID
+		return "", &echojwt.TokenError{Token: token, Err: errors.New("missing token ID")}
+	}
+	if expiresAt, err := claims.GetExpirationTime(); err != nil || expiresAt == nil {
+		return "", &echojwt.TokenError{Token: token, Err: errors.New("missing token expiration")}
+	}
+	return tokenID, nil
+}
@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"woody-wood-portail/cmd/config"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/db"
+	"woody-wood-portail/cmd/services/encryption"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Minimum delay between two reloads triggered by an unknown key ID, to not hit the database on each forged token
+const keyringReloadInterval = time.Minute
+
+type signingKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{}
+	VerifyKey interface{}
+	CreatedAt time.Time
+}
+
+// keyring holds the keys allowed to verify tokens, the first one is used to sign new tokens
+var keyring struct {
+	sync.RWMutex
+	keys     map[string]*signingKey
+	current  *signingKey
+	loadedAt time.Time
+	// Tokens without kid are signed with the legacy secret, they are verified until this date
+	legacyUntil time.Time
+}
+
+// encryptionKeys encrypt the signing keys stored in the database
+var encryptionKeys encryption.Keys
+
+// LoadKeyring loads the signing keys from the database, and creates one if there is no usable key yet
+func LoadKeyring() error {
+	ctx := context.Background()
+
+	var err error
+	encryptionKeys, err = encryption.ParseKeys(config.Config.Http.JWT.EncryptionKeys)
+	if err != nil {
+		return fmt.Errorf("invalid JWT encryption keys: %w", err)
+	}
+
+	if err := reloadKeyring(ctx); err != nil {
+		return err
+	}
+
+	keyring.RLock()
+	current := keyring.current
+	keyring.RUnlock()
+
+	if current == nil || current.Method.Alg() != config.Config.Http.JWT.Algorithm {
+		return rotateKeys(ctx)
+	}
+	return nil
+}
+
+// RotateKeys replaces the signing key if it is older than the rotation interval.
+// Previous keys are kept to verify tokens until all tokens they have signed are expired.
+func RotateKeys() {
+	ctx := context.Background()
+
+	if deleted, err := db.QGlobal().DeleteExpiredJWTKeys(ctx); err != nil {
+		logger.Log.Error().Err(err).Msg("failed to delete expired JWT keys")
+	} else if deleted > 0 {
+		logger.Log.Info().Int64("deleted", deleted).Msg("Expired JWT keys deleted")
+	}
+
+	keyring.RLock()
+	current := keyring.current
+	keyring.RUnlock()
+
+	interval := config.Config.Http.JWT.RotationInterval
+	if current != nil && (interval == 0 || time.Since(current.CreatedAt) < interval) {
+		return
+	}
+
+	if err := rotateKeys(ctx); err != nil {
+		logger.Log.Error().Err(err).Msg("failed to rotate JWT keys")
+	}
+}
+
+func rotateKeys(ctx context.Context) error {
+	id, secret, err := generateKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate JWT key: %w", err)
+	}
+
+	encrypted, err := encryptionKeys.EncryptSecret(secret, []byte(id))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt JWT key: %w", err)
+	}
+
+	key, err := db.QGlobal().CreateJWTKey(ctx, db.CreateJWTKeyParams{
+		ID:        id,
+		Algorithm: config.Config.Http.JWT.Algorithm,
+		Secret:    encrypted,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save JWT key: %w", err)
+	}
+
+	if err := db.QGlobal().RetireJWTKeys(ctx, db.RetireJWTKeysParams{
+		ID:        key.ID,
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().Add(maxTokenLifetime()), Valid: true},
+	}); err != nil {
+		return fmt.Errorf("failed to retire previous JWT keys: %w", err)
+	}
+
+	logger.Log.Info().Str("kid", key.ID).Str("alg", key.Algorithm).Msg("New JWT signing key")
+
+	return reloadKeyring(ctx)
+}
+
+func reloadKeyring(ctx context.Context) error {
+	stored, err := db.QGlobal().ListJWTKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list JWT keys: %w", err)
+	}
+
+	keys := make(map[string]*signingKey, len(stored))
+	var current *signingKey
+	var oldest time.Time
+	for _, s := range stored {
+		// Expired keys are deleted, but the oldest remaining key was created at least a token lifetime after the
+		// keyring was introduced once its first key is gone, so the legacy tokens stay refused
+		if oldest.IsZero() || s.CreatedAt.Time.Before(oldest) {
+			oldest = s.CreatedAt.Time
+		}
+
+		if err := decryptKey(ctx, &s); err != nil {
+			logger.Log.Error().Err(err).Str("kid", s.ID).Msg("ignoring undecryptable JWT key")
+			continue
+		}
+
+		key, err := decodeKey(s)
+		if err != nil {
+			logger.Log.Error().Err(err).Str("kid", s.ID).Msg("ignoring invalid JWT key")
+			continue
+		}
+		keys[key.ID] = key
+		if current == nil && !s.RetiredAt.Valid {
+			current = key
+		}
+	}
+
+	keyring.Lock()
+	defer keyring.Unlock()
+	keyring.keys = keys
+	keyring.current = current
+	keyring.loadedAt = time.Now()
+	keyring.legacyUntil = oldest.Add(maxTokenLifetime())
+	return nil
+}
+
+// decryptKey replaces the stored secret by its clear value. The secrets stored in clear, or encrypted with a previous
+// encryption key, are encrypted again with the current one.
+func decryptKey(ctx context.Context, stored *db.JwtKey) error {
+	secret, stale := stored.Secret, true
+	if encryption.IsEncryptedSecret(stored.Secret) {
+		var err error
+		secret, stale, err = encryptionKeys.DecryptSecret(stored.Secret, []byte(stored.ID))
+		if err != nil {
+			return err
+		}
+	}
+
+	if stale {
+		encrypted, err := encryptionKeys.EncryptSecret(secret, []byte(stored.ID))
+		if err != nil {
+			return err
+		}
+		if err := db.QGlobal().UpdateJWTKeySecret(ctx, db.UpdateJWTKeySecretParams{ID: stored.ID, Secret: encrypted}); err != nil {
+			// The key stays usable, the encryption is tried again on the next load
+			logger.Log.Error().Err(err).Str("kid", stored.ID).Msg("failed to encrypt JWT key")
+		} else {
+			logger.Log.Info().Str("kid", stored.ID).Msg("JWT key encrypted with the current key")
+		}
+	}
+
+	stored.Secret = secret
+	return nil
+}
+
+func generateKey() (string, []byte, error) {
+	rawID := make([]byte, 8)
+	if _, err := rand.Read(rawID); err != nil {
+		return "", nil, err
+	}
+
+	// HS256 secret or Ed25519 private key seed, both are 32 random bytes
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+
+	return hex.EncodeToString(rawID), secret, nil
+}
+
+func decodeKey(stored db.JwtKey) (*signingKey, error) {
+	key := &signingKey{ID: stored.ID, CreatedAt: stored.CreatedAt.Time}
+
+	switch stored.Algorithm {
+	case jwt.SigningMethodHS256.Alg():
+		key.Method = jwt.SigningMethodHS256
+		key.SignKey = stored.Secret
+		key.VerifyKey = stored.Secret
+	case jwt.SigningMethodEdDSA.Alg():
+		if len(stored.Secret) != ed25519.SeedSize {
+			return nil, errors.New("invalid Ed25519 seed size")
+		}
+		privateKey := ed25519.NewKeyFromSeed(stored.Secret)
+		key.Method = jwt.SigningMethodEdDSA
+		key.SignKey = privateKey
+		key.VerifyKey = privateKey.Public()
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", stored.Algorithm)
+	}
+
+	return key, nil
+}
+
+// maxTokenLifetime is the time a retired key must be kept to verify every token it has signed
+func maxTokenLifetime() time.Duration {
+	return max(
+		time.Duration(config.Config.Http.JWT.MaxAge)*24*time.Hour,
+		config.Config.Http.JWT.EmailVerificationTTL,
+		config.Config.Http.JWT.ResetPasswordTTL,
+		config.Config.Http.JWT.MagicLinkTTL,
+		config.Config.Http.JWT.EmailChangeTTL,
+	)
+}
+
+// signToken signs the claims with the current key, whose ID is given in the kid header
+func signToken(claims jwt.Claims) (string, error) {
+	keyring.RLock()
+	current := keyring.current
+	keyring.RUnlock()
+
+	if current == nil {
+		return "", errors.New("no JWT signing key loaded")
+	}
+
+	token := jwt.NewWithClaims(current.Method, claims)
+	token.Header["kid"] = current.ID
+	return token.SignedString(current.SignKey)
+}
+
+func getJwtKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return legacyKey(token)
+	}
+
+	key := findKey(kid)
+	if key == nil && canReloadKeyring() {
+		// The key may have been created by another instance since the last reload
+		if err := reloadKeyring(context.Background()); err != nil {
+			return nil, err
+		}
+		key = findKey(kid)
+	}
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.VerifyKey, nil
+}
+
+// legacyKey returns the shared secret signing the tokens issued before the keyring, until they are all expired.
+// TODO: remove with the Http.JWT.Secret setting once the keyring is deployed for longer than the token lifetimes
+func legacyKey(token *jwt.Token) (interface{}, error) {
+	secret := config.Config.Http.JWT.Secret
+	if secret == "" {
+		return nil, ErrUnknownKey
+	}
+
+	keyring.RLock()
+	legacyUntil := keyring.legacyUntil
+	keyring.RUnlock()
+	if legacyUntil.IsZero() || time.Now().After(legacyUntil) {
+		return nil, ErrUnknownKey
+	}
+
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return []byte(secret), nil
+}
+
+func findKey(kid string) *signingKey {
+	keyring.RLock()
+	defer keyring.RUnlock()
+	return keyring.keys[kid]
+}
+
+func canReloadKeyring() bool {
+	keyring.RLock()
+	defer keyring.RUnlock()
+	return time.Since(keyring.loadedAt) > keyringReloadInterval
+}
@@ -1,11 +1,14 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"runtime"
+	"woody-wood-portail/cmd/config"
+	"woody-wood-portail/cmd/logger"
 	"woody-wood-portail/cmd/services/db"
 
 	"golang.org/x/crypto/argon2"
@@ -25,13 +28,8 @@ func CreateHash(password string, params db.WithPassword) error {
 		return fmt.Errorf("failed to generate salt: %w", err)
 	}
 
-	argon2Password := db.Argon2Password{
-		Salt:        base64.RawStdEncoding.EncodeToString(salt),
-		Iterations:  3,
-		Memory:      64 * 1024,
-		Parallelism: int16(runtime.NumCPU()),
-		Version:     argon2.Version,
-	}
+	argon2Password := targetParams()
+	argon2Password.Salt = base64.RawStdEncoding.EncodeToString(salt)
 
 	argon2Password.Hash = base64.RawStdEncoding.EncodeToString(argon2.IDKey(
 		[]byte(password),
@@ -57,16 +55,84 @@ func CompareHashAgainstPassword(user db.User, password string) (bool, error) {
 		return false, fmt.Errorf("failed to decode salt: %w", err)
 	}
 
-	hash := base64.RawStdEncoding.EncodeToString(argon2.IDKey(
+	expected, err := base64.RawStdEncoding.DecodeString(user.PwdHash)
+	if err != nil || len(expected) == 0 {
+		return false, ErrInvalidHash
+	}
+
+	hash := argon2.IDKey(
 		[]byte(password),
 		salt,
 		uint32(user.PwdIterations),
 		uint32(user.PwdMemory),
 		uint8(user.PwdParallelism),
-		KEY_LENGTH,
-	))
+		uint32(len(expected)),
+	)
+
+	return subtle.ConstantTimeCompare(hash, expected) == 1, nil
+}
+
+// NeedsRehash returns true if the password of the user was hashed with other parameters than the configured ones
+func NeedsRehash(user db.User) bool {
+	target := targetParams()
+	return user.PwdIterations != target.Iterations ||
+		user.PwdMemory != target.Memory ||
+		user.PwdParallelism != target.Parallelism ||
+		user.PwdVersion != target.Version
+}
+
+// RehashPassword hashes again the password of the user with the configured parameters.
+// It must only be called with a password which has just been checked.
+func RehashPassword(ctx context.Context, user db.User, password string) error {
+	params := db.UpdatePasswordParams{ID: user.ID}
+	if err := CreateHash(password, &params); err != nil {
+		return err
+	}
+
+	// Not in the request transaction, since the login handler never commits it
+	if err := db.QGlobal().UpdatePassword(ctx, params); err != nil {
+		return fmt.Errorf("failed to update password hash: %w", err)
+	}
 
-	return hash == user.PwdHash, nil
+	logger.Log.Info().Stringer("user", user.ID).Msg("Password hash upgraded to the configured parameters")
+	return nil
+}
+
+// CountOutdatedPasswords returns the number of accounts whose password still uses other parameters than the configured ones
+func CountOutdatedPasswords(ctx context.Context, queries *db.Queries) (int64, error) {
+	target := targetParams()
+	return queries.CountOutdatedPasswords(ctx, db.CountOutdatedPasswordsParams{
+		Iterations:  target.Iterations,
+		Memory:      target.Memory,
+		Parallelism: target.Parallelism,
+		Version:     target.Version,
+	})
+}
+
+// ReportOutdatedPasswords logs how many accounts will have their password hash upgraded on their next login
+func ReportOutdatedPasswords() {
+	outdated, err := CountOutdatedPasswords(context.Background(), db.QGlobal())
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("failed to count outdated password hashes")
+		return
+	}
+
+	logger.Log.Info().Int64("outdated", outdated).Msg("Accounts with outdated password hash parameters")
+}
+
+// SimulatePasswordCheck takes the same time as checking a password, to not reveal that an account doesn't exist
+func SimulatePasswordCheck(password string) {
+	target := targetParams()
+	argon2.IDKey([]byte(password), make([]byte, SALT_LENGTH), uint32(target.Iterations), uint32(target.Memory), uint8(target.Parallelism), KEY_LENGTH)
+}
+
+func targetParams() db.Argon2Password {
+	return db.Argon2Password{
+		Iterations:  int32(config.Config.Users.Password.Iterations),
+		Memory:      int32(config.Config.Users.Password.Memory),
+		Parallelism: int16(config.Config.Users.Password.Parallelism),
+		Version:     argon2.Version,
+	}
 }
 
 func generateRandomSalt() ([]byte, error) {
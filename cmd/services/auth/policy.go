@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+	"woody-wood-portail/cmd/config"
+
+	"github.com/ccojocar/zxcvbn-go"
+)
+
+// Only the beginning of the password is scored, the estimation is slow on long inputs and they are strong enough anyway
+const maxScoredLength = 100
+
+// PasswordLongEnough returns true if the password has at least the configured number of characters
+func PasswordLongEnough(password string) bool {
+	return utf8.RuneCountInString(password) >= config.Config.Users.Password.MinLength
+}
+
+// PasswordStrongEnough returns true if the password reaches the configured zxcvbn score.
+// The user inputs (email, name...) are considered as easy to guess.
+func PasswordStrongEnough(password string, userInputs ...string) bool {
+	if utf8.RuneCountInString(password) > maxScoredLength {
+		password = string([]rune(password)[:maxScoredLength])
+	}
+	return zxcvbn.PasswordStrength(password, userInputs).Score >= config.Config.Users.Password.MinScore
+}
+
+// PasswordBreached looks for the password in the local breached passwords list.
+// Only the file of the 5 first characters of the hash is read, like with the haveibeenpwned range API.
+func PasswordBreached(password string) (bool, error) {
+	directory := config.Config.Users.Password.BreachedDirectory
+	if directory == "" {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(directory, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open breached passwords range %s: %w", prefix, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Lines are formatted as SUFFIX:COUNT
+		line, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(line), suffix) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read breached passwords range %s: %w", prefix, err)
+	}
+
+	return false, nil
+}
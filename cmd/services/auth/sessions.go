@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"woody-wood-portail/cmd/config"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/db"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	echojwt "github.com/labstack/echo-jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+const SessionEchoKey = "session"
+
+// Minimum delay between two updates of the last seen date of a session, to avoid a write on each request
+const sessionTouchInterval = time.Minute
+
+// CreateSessionToken creates an authentication token bound to a server side session, allowing to revoke it
+func CreateSessionToken(session db.Session) (string, error) {
+	now := time.Now()
+	return signToken(&jwt.RegisteredClaims{
+		ID:        session.ID.String(),
+		Subject:   session.UserID.String(),
+		Audience:  jwt.ClaimStrings{string(AuthAudience)},
+		IssuedAt:  &jwt.NumericDate{Time: now},
+		ExpiresAt: &jwt.NumericDate{Time: now.Add(time.Duration(config.Config.Http.JWT.MaxAge) * 24 * time.Hour)},
+	})
+}
+
+// WithActiveSession rejects tokens which are not bound to a session, or bound to a revoked session.
+// The session is stored in the echo context.
+func WithActiveSession(c echo.Context) TokenRule {
+	return func(user *db.User, token *jwt.Token) error {
+		sessionID, err := sessionIDFromToken(token)
+		if err != nil {
+			return err
+		}
+
+		session, err := db.Q(c).GetActiveSession(c.Request().Context(), db.GetActiveSessionParams{
+			ID:         sessionID,
+			UserID:     user.ID,
+			MaxAgeDays: int32(config.Config.Http.JWT.MaxAge),
+		})
+		if err != nil {
+			return &echojwt.TokenError{Token: token, Err: errors.New("session not found, expired or revoked")}
+		}
+
+		if session.LastSeenAt.Time.Add(sessionTouchInterval).Before(time.Now()) || session.Ip != c.RealIP() {
+			// Not in the request transaction, since most handlers never commit it
+			if err := db.QGlobal().TouchSession(c.Request().Context(), db.TouchSessionParams{
+				ID: session.ID,
+				Ip: c.RealIP(),
+			}); err != nil {
+				logger.Log.Error().Err(err).Stringer("session", session.ID).Msg("failed to update session last seen date")
+			}
+		}
+
+		c.Set(SessionEchoKey, session)
+		return nil
+	}
+}
+
+// ParseSessionID returns the session of an authentication token, without checking if it has been revoked
+func ParseSessionID(tokenString string) (uuid.UUID, error) {
+	token, err := jwt.Parse(tokenString, getJwtKey)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return sessionIDFromToken(token)
+}
+
+func sessionIDFromToken(token *jwt.Token) (uuid.UUID, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return uuid.Nil, &echojwt.TokenError{Token: token, Err: errors.New("invalid token claims")}
+	}
+
+	jti, _ := claims["jti"].(string)
+	sessionID, err := uuid.Parse(jti)
+	if err != nil {
+		return uuid.Nil, &echojwt.TokenError{Token: token, Err: errors.New("missing or invalid token session")}
+	}
+	return sessionID, nil
+}
+
+// DeviceLabel gives a human readable description of the device from its user agent
+func DeviceLabel(userAgent string) string {
+	browser := "Navigateur inconnu"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"SamsungBrowser/", "Samsung Internet"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	system := "système inconnu"
+	for _, s := range []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	return browser + " sur " + system
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"woody-wood-portail/cmd/config"
+	"woody-wood-portail/cmd/services/db"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	recoveryCodesCount = 10
+	// Default period of totp.Generate, in seconds
+	totpPeriod = 30
+)
+
+func GenerateTOTPKey(user db.User) (*otp.Key, error) {
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      config.Config.Mail.Sender.Name,
+		AccountName: user.Email,
+	})
+}
+
+// TOTPKeyFromSecret rebuilds the key of a pending enrolment, to display it again
+func TOTPKeyFromSecret(user db.User) (*otp.Key, error) {
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(user.TotpSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode TOTP secret: %w", err)
+	}
+
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      config.Config.Mail.Sender.Name,
+		AccountName: user.Email,
+		Secret:      secret,
+	})
+}
+
+// ValidateTOTP checks the code of the user and records its time step, so that the code is refused if it is used again.
+// The step is recorded in the given queries, their transaction must be committed for the code to be consumed.
+func ValidateTOTP(ctx context.Context, q *db.Queries, user db.User, code string) (bool, error) {
+	if user.TotpSecret == "" {
+		return false, nil
+	}
+
+	step, ok := totpStep(user.TotpSecret, strings.ReplaceAll(code, " ", ""), time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	used, err := q.UseTOTPStep(ctx, db.UseTOTPStepParams{ID: user.ID, TotpLastStep: step})
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP code: %w", err)
+	}
+	return used == 1, nil
+}
+
+// totpStep returns the time step matching the code, with one step of clock drift allowed like totp.Validate
+func totpStep(secret string, code string, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for _, step := range []int64{current - 1, current, current + 1} {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes returns new single use recovery codes, and the hashes to store in database
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+
+	for range recoveryCodesCount {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+
+		code := base32.StdEncoding.EncodeToString(raw)
+		code = code[:4] + "-" + code[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode normalizes the code as the user may have typed it, and hashes it.
+// Recovery codes are random enough to not need a salted password hash.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
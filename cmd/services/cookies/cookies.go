@@ -0,0 +1,31 @@
+package cookies
+
+import (
+	"net/http"
+	"strings"
+	"woody-wood-portail/cmd/config"
+)
+
+// New creates a cookie hidden from scripts, only sent over HTTPS when the application is served over HTTPS.
+// Lax is required for the cookies to be sent when following a link from an email or an identity provider.
+func New(name string, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   Secure(),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   maxAge,
+	}
+}
+
+// Clear creates a cookie removing the existing one from the browser
+func Clear(name string) *http.Cookie {
+	return New(name, "", -1)
+}
+
+// Secure returns true if the application is served over HTTPS, according to its base URL
+func Secure() bool {
+	return strings.HasPrefix(config.Config.Http.BaseURL, "https://")
+}
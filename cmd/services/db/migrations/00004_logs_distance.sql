@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table "logs" add column distance double precision;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table "logs" drop column distance;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists "roles" (
+  name varchar(255) primary key,
+  label varchar(255) not null,
+  permissions text[] not null default '{}',
+  builtin boolean not null default false,
+  created_at timestamp not null default current_timestamp,
+  updated_at timestamp not null default current_timestamp
+);
+
+CREATE OR REPLACE TRIGGER trigger_updated_at_roles
+  BEFORE UPDATE ON "roles"
+  FOR EACH ROW
+  EXECUTE PROCEDURE trigger_set_timestamp ();
+
+insert into "roles" (name, label, permissions, builtin) values
+  ('user', 'Utilisateur', '{}', true),
+  ('admin', 'Administrateur', '{approve_registrations,manage_users,manage_roles,view_logs,manage_gate,manage_firmware,manage_invitations}', true);
+
+-- Keep roles previously entered by hand, without any permission
+insert into "roles" (name, label)
+  select distinct "role", "role" from "users"
+  on conflict (name) do nothing;
+
+alter table "users" add constraint users_role_fkey foreign key ("role") references "roles" (name);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table "users" drop constraint if exists users_role_fkey;
+drop table if exists "roles";
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists "lockdown_events" (
+  id uuid primary key default gen_random_uuid(),
+  enabled boolean not null,
+  message text not null default '',
+  user_id uuid references "users" (id) on delete set null,
+  created_at timestamp not null default current_timestamp
+);
+
+alter table "users" add column lockdown_exempt boolean not null default false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table "users" drop column lockdown_exempt;
+drop table if exists "lockdown_events";
+-- +goose StatementEnd
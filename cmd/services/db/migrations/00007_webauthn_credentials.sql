@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists "webauthn_credentials" (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid not null references "users" (id) on delete cascade,
+  credential_id bytea not null,
+  name varchar(255) not null,
+  credential jsonb not null,
+  created_at timestamp not null default current_timestamp,
+  last_used_at timestamp
+);
+create unique index if not exists webauthn_credentials_credential_id_key on "webauthn_credentials" (credential_id);
+create index if not exists webauthn_credentials_user_id_idx on "webauthn_credentials" (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists "webauthn_credentials";
+-- +goose StatementEnd
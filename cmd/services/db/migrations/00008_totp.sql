@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table "users" add column totp_secret varchar(255) not null default '';
+alter table "users" add column totp_enabled boolean not null default false;
+
+create table if not exists "totp_recovery_codes" (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid not null references "users" (id) on delete cascade,
+  code_hash varchar(255) not null,
+  used_at timestamp,
+  created_at timestamp not null default current_timestamp
+);
+create index if not exists totp_recovery_codes_user_id_idx on "totp_recovery_codes" (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists "totp_recovery_codes";
+alter table "users" drop column totp_enabled;
+alter table "users" drop column totp_secret;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists "sessions" (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid not null references "users" (id) on delete cascade,
+  user_agent text not null default '',
+  ip varchar(255) not null default '',
+  created_at timestamp not null default current_timestamp,
+  last_seen_at timestamp not null default current_timestamp,
+  revoked_at timestamp
+);
+create index if not exists sessions_user_id_idx on "sessions" (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists "sessions";
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists "auth_attempts" (
+  id uuid primary key default gen_random_uuid(),
+  kind varchar(255) not null,
+  ip varchar(255) not null,
+  email varchar(255) not null,
+  created_at timestamp not null default current_timestamp
+);
+create index if not exists auth_attempts_ip_idx on "auth_attempts" (kind, ip, created_at);
+create index if not exists auth_attempts_email_idx on "auth_attempts" (kind, lower(email), created_at);
+
+-- Kept out of the users table to not bump users.updated_at, which would invalidate pending email links
+create table if not exists "login_lockouts" (
+  user_id uuid primary key references "users" (id) on delete cascade,
+  failed_logins integer not null default 0,
+  last_failure_at timestamp not null default current_timestamp,
+  locked_until timestamp
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists "login_lockouts";
+drop table if exists "auth_attempts";
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists "jwt_keys" (
+  id varchar(255) primary key,
+  algorithm varchar(255) not null,
+  secret bytea not null,
+  created_at timestamp not null default current_timestamp,
+  -- Retired keys are no longer used to sign tokens, but still verify them until they expire
+  retired_at timestamp,
+  expires_at timestamp
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists "jwt_keys";
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists "user_identities" (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid not null references "users" (id) on delete cascade,
+  issuer varchar(255) not null,
+  subject varchar(255) not null,
+  email varchar(255) not null,
+  created_at timestamp not null default current_timestamp,
+  last_used_at timestamp
+);
+create unique index if not exists user_identities_issuer_subject_key on "user_identities" (issuer, subject);
+create index if not exists user_identities_user_id_idx on "user_identities" (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists "user_identities";
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists "access_tokens" (
+  id uuid primary key default gen_random_uuid(),
+  user_id uuid not null references "users" (id) on delete cascade,
+  name varchar(100) not null,
+  token_hash bytea not null unique,
+  scopes text[] not null default '{}',
+  created_at timestamp not null default current_timestamp,
+  expires_at timestamp not null,
+  last_used_at timestamp,
+  revoked_at timestamp
+);
+create index if not exists access_tokens_user_id_idx on "access_tokens" (user_id);
+
+alter table "logs" add column access_token_id uuid references "access_tokens" (id) on delete set null;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table "logs" drop column access_token_id;
+drop table if exists "access_tokens";
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists "impersonations" (
+  id uuid primary key default gen_random_uuid(),
+  -- Kept when the administrator account is deleted, for the audit trail
+  admin_id uuid references "users" (id) on delete set null,
+  user_id uuid not null references "users" (id) on delete cascade,
+  session_id uuid references "sessions" (id) on delete set null,
+  started_at timestamp not null default current_timestamp,
+  expires_at timestamp not null,
+  ended_at timestamp
+);
+create index if not exists impersonations_started_at_idx on "impersonations" (started_at);
+
+update "roles" set permissions = array_append(permissions, 'impersonate_users')
+  where name = 'admin' and not ('impersonate_users' = any(permissions));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+update "roles" set permissions = array_remove(permissions, 'impersonate_users');
+drop table if exists "impersonations";
+-- +goose StatementEnd
@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists "invitation_codes" (
+  id uuid primary key default gen_random_uuid(),
+  code varchar(255) not null unique,
+  label varchar(255) not null,
+  -- Never expires when null
+  expires_at timestamp,
+  -- Unlimited when null
+  max_uses integer,
+  uses integer not null default 0,
+  -- Assigned to the users registering with the code, instead of the ones they entered
+  apartment varchar(255),
+  "role" varchar(255) references "roles" (name) on delete set null,
+  -- Registrations with the code don't need to be reviewed by an administrator
+  auto_approve boolean not null default false,
+  created_at timestamp not null default current_timestamp,
+  revoked_at timestamp
+);
+
+insert into "invitation_codes" (code, label) select code, 'Affiche du hall' from "registration_code";
+drop table if exists "registration_code";
+
+alter table "users" add column invitation_code_id uuid references "invitation_codes" (id) on delete set null;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table "users" drop column invitation_code_id;
+
+create table if not exists "registration_code" (
+  id smallint primary key default 1,
+  code varchar(255) not null,
+  updated_at timestamp not null default current_timestamp
+);
+
+CREATE OR REPLACE TRIGGER trigger_updated_at_registration_code
+  BEFORE UPDATE ON "registration_code"
+  FOR EACH ROW
+  EXECUTE PROCEDURE trigger_set_timestamp ();
+
+insert into "registration_code" (id, code)
+  select 1, code from "invitation_codes" where revoked_at is null order by created_at desc limit 1;
+
+drop table if exists "invitation_codes";
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table "invitation_codes" add column rotates boolean not null default false;
+alter table "invitation_codes" add column rotated_at timestamp;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table "invitation_codes" drop column rotated_at;
+alter table "invitation_codes" drop column rotates;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists "address_proof_downloads" (
+  id uuid primary key default gen_random_uuid(),
+  -- Kept when the administrator account is deleted, for the audit trail
+  admin_id uuid references "users" (id) on delete set null,
+  user_id uuid not null references "users" (id) on delete cascade,
+  ip text not null,
+  created_at timestamp not null default current_timestamp
+);
+create index if not exists address_proof_downloads_created_at_idx on "address_proof_downloads" (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists "address_proof_downloads";
+-- +goose StatementEnd
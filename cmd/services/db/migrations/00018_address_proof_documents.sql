@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table "address_proof_downloads" add column if not exists document text;
+alter table "address_proof_downloads" add column if not exists preview boolean not null default false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table "address_proof_downloads" drop column if exists preview;
+alter table "address_proof_downloads" drop column if exists document;
+-- +goose StatementEnd
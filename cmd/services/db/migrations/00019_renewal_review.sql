@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Set when the user sent a new address proof to renew the registration, until an admin reviews it
+alter table "users" add column if not exists renewal_requested_at timestamp;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+update "users" set registration_state = 'suspended' where registration_state = 'renewal_pending';
+alter table "users" drop column if exists renewal_requested_at;
+-- +goose StatementEnd
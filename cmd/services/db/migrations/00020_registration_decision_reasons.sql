@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Reason given to the user when the registration was rejected or suspended by an admin
+alter table "users" add column if not exists decision_reason text;
+alter table "users" add column if not exists decision_comment text;
+-- Whether a rejected user can correct the registration and send it again
+alter table "users" add column if not exists resubmission_allowed boolean not null default false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table "users" drop column if exists resubmission_allowed;
+alter table "users" drop column if exists decision_comment;
+alter table "users" drop column if exists decision_reason;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Time step of the last accepted TOTP code, a code is only accepted once
+alter table "users" add column if not exists totp_last_step bigint not null default 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table "users" drop column if exists totp_last_step;
+-- +goose StatementEnd
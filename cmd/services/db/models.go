@@ -9,16 +9,119 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-type Log struct {
+type AccessToken struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	TokenHash  []byte
+	Scopes     []string
+	CreatedAt  pgtype.Timestamp
+	ExpiresAt  pgtype.Timestamp
+	LastUsedAt pgtype.Timestamp
+	RevokedAt  pgtype.Timestamp
+}
+
+type AddressProofDownload struct {
+	ID        uuid.UUID
+	AdminID   pgtype.UUID
+	UserID    uuid.UUID
+	Ip        string
+	CreatedAt pgtype.Timestamp
+	Document  pgtype.Text
+	Preview   bool
+}
+
+type AuthAttempt struct {
+	ID        uuid.UUID
+	Kind      string
+	Ip        string
+	Email     string
+	CreatedAt pgtype.Timestamp
+}
+
+type Impersonation struct {
 	ID        uuid.UUID
+	AdminID   pgtype.UUID
 	UserID    uuid.UUID
+	SessionID pgtype.UUID
+	StartedAt pgtype.Timestamp
+	ExpiresAt pgtype.Timestamp
+	EndedAt   pgtype.Timestamp
+}
+
+type InvitationCode struct {
+	ID          uuid.UUID
+	Code        string
+	Label       string
+	ExpiresAt   pgtype.Timestamp
+	MaxUses     pgtype.Int4
+	Uses        int32
+	Apartment   pgtype.Text
+	Role        pgtype.Text
+	AutoApprove bool
+	CreatedAt   pgtype.Timestamp
+	RevokedAt   pgtype.Timestamp
+	Rotates     bool
+	RotatedAt   pgtype.Timestamp
+}
+
+type JwtKey struct {
+	ID        string
+	Algorithm string
+	Secret    []byte
+	CreatedAt pgtype.Timestamp
+	RetiredAt pgtype.Timestamp
+	ExpiresAt pgtype.Timestamp
+}
+
+type LockdownEvent struct {
+	ID        uuid.UUID
+	Enabled   bool
+	Message   string
+	UserID    pgtype.UUID
 	CreatedAt pgtype.Timestamp
 }
 
-type RegistrationCode struct {
-	ID        int16
-	Code      string
-	UpdatedAt pgtype.Timestamp
+type Log struct {
+	ID            uuid.UUID
+	UserID        uuid.UUID
+	CreatedAt     pgtype.Timestamp
+	Distance      pgtype.Float8
+	AccessTokenID pgtype.UUID
+}
+
+type LoginLockout struct {
+	UserID        uuid.UUID
+	FailedLogins  int32
+	LastFailureAt pgtype.Timestamp
+	LockedUntil   pgtype.Timestamp
+}
+
+type Role struct {
+	Name        string
+	Label       string
+	Permissions []string
+	Builtin     bool
+	CreatedAt   pgtype.Timestamp
+	UpdatedAt   pgtype.Timestamp
+}
+
+type Session struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	UserAgent  string
+	Ip         string
+	CreatedAt  pgtype.Timestamp
+	LastSeenAt pgtype.Timestamp
+	RevokedAt  pgtype.Timestamp
+}
+
+type TotpRecoveryCode struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    pgtype.Timestamp
+	CreatedAt pgtype.Timestamp
 }
 
 type UsedToken struct {
@@ -29,20 +132,49 @@ type UsedToken struct {
 }
 
 type User struct {
-	ID                uuid.UUID
-	Email             string
-	FullName          string
-	Apartment         string
-	PwdSalt           string
-	PwdHash           string
-	PwdIterations     int32
-	PwdParallelism    int16
-	PwdMemory         int32
-	PwdVersion        int32
-	Role              string
-	EmailVerified     bool
-	CreatedAt         pgtype.Timestamp
-	UpdatedAt         pgtype.Timestamp
-	RegistrationState string
-	LastRegistration  pgtype.Timestamp
+	ID                  uuid.UUID
+	Email               string
+	FullName            string
+	Apartment           string
+	PwdSalt             string
+	PwdHash             string
+	PwdIterations       int32
+	PwdParallelism      int16
+	PwdMemory           int32
+	PwdVersion          int32
+	Role                string
+	EmailVerified       bool
+	CreatedAt           pgtype.Timestamp
+	UpdatedAt           pgtype.Timestamp
+	RegistrationState   string
+	LastRegistration    pgtype.Timestamp
+	LockdownExempt      bool
+	TotpSecret          string
+	TotpEnabled         bool
+	InvitationCodeID    pgtype.UUID
+	RenewalRequestedAt  pgtype.Timestamp
+	DecisionReason      pgtype.Text
+	DecisionComment     pgtype.Text
+	ResubmissionAllowed bool
+	TotpLastStep        int64
+}
+
+type UserIdentity struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Issuer     string
+	Subject    string
+	Email      string
+	CreatedAt  pgtype.Timestamp
+	LastUsedAt pgtype.Timestamp
+}
+
+type WebauthnCredential struct {
+	ID           uuid.UUID
+	UserID       uuid.UUID
+	CredentialID []byte
+	Name         string
+	Credential   []byte
+	CreatedAt    pgtype.Timestamp
+	LastUsedAt   pgtype.Timestamp
 }
@@ -23,25 +23,67 @@ returning *;
 update "users" set email_verified = true where id = $1;
 
 -- name: RegistrationPending :one
-update "users" set registration_state = 'pending' where id = $1 returning *;
+update "users" set registration_state = 'pending', decision_reason = null, decision_comment = null, resubmission_allowed = false
+where id = $1 returning *;
 
 -- name: RegistrationAccepted :one
-update "users" set registration_state = 'accepted' where id = $1 returning *;;
+update "users" set registration_state = 'accepted', decision_reason = null, decision_comment = null, resubmission_allowed = false
+where id = $1 returning *;
 
 -- name: RegistrationRejected :one
-update "users" set registration_state = 'rejected' where id = $1 returning *;
+update "users" set registration_state = 'rejected', decision_reason = $2, decision_comment = $3, resubmission_allowed = $4
+where id = $1 returning *;
 
 -- name: RegistrationSuspended :one
-update "users" set registration_state = 'suspended' where id = $1 returning *;
+-- The users who already sent a renewal request wait for its review instead
+update "users" set registration_state = case when renewal_requested_at is null then 'suspended' else 'renewal_pending' end
+where id = $1 returning *;
+
+-- name: RegistrationSuspendedByAdmin :one
+-- A pending renewal request is dropped, the user has to renew again with the reason in mind
+update "users" set registration_state = 'suspended', decision_reason = $2, decision_comment = $3, renewal_requested_at = null
+where id = $1 and registration_state = 'accepted' returning *;
 
 -- name: RenewRegistration :one
-update "users" set registration_state = 'accepted', last_registration = now() where id = $1 returning *;
+update "users" set registration_state = 'accepted', last_registration = now(), renewal_requested_at = null,
+  decision_reason = null, decision_comment = null
+where id = $1 returning *;
+
+-- name: RequestRenewal :one
+-- Suspended users wait for the review, the others keep their access until their registration expires
+update "users" set renewal_requested_at = now(),
+  registration_state = case when registration_state = 'suspended' then 'renewal_pending' else registration_state end
+where id = $1 returning *;
+
+-- name: RenewalRejected :one
+update "users" set registration_state = 'rejected', renewal_requested_at = null,
+  decision_reason = $2, decision_comment = $3, resubmission_allowed = $4
+where id = $1 and renewal_requested_at is not null returning *;
+
+-- name: ResubmitRegistration :one
+update "users" set registration_state = 'pending', full_name = $2, apartment = $3,
+  decision_reason = null, decision_comment = null, resubmission_allowed = false
+where id = $1 and registration_state = 'rejected' and resubmission_allowed returning *;
 
 -- name: UpdatePassword :exec
 update "users" set pwd_salt = $2, pwd_hash = $3, pwd_iterations = $4, pwd_parallelism = $5, pwd_memory = $6, pwd_version = $7 where id = $1;
 
+-- name: CountOutdatedPasswords :one
+select count(*) from "users"
+where pwd_iterations <> sqlc.arg(iterations) or pwd_memory <> sqlc.arg(memory)
+  or pwd_parallelism <> sqlc.arg(parallelism) or pwd_version <> sqlc.arg(version);
+
 -- name: UpdateUserInfo :one
-update "users" set "role" = $2, full_name = $3, apartment = $4, email = $5 where id = $1 returning *;
+-- A new email has to be verified again
+update "users" set "role" = $2, full_name = $3, apartment = $4, email = $5, lockdown_exempt = $6,
+  email_verified = email_verified and email = $5
+where id = $1 returning *;
+
+-- name: UpdateUserEmail :one
+update "users" set email = $2, email_verified = true where id = $1 returning *;
+
+-- name: UpdateUserFullName :one
+update "users" set full_name = $2 where id = $1 returning *;
 
 -- name: DeleteUser :one
 delete from "users" where id = $1 returning *;
@@ -50,25 +92,289 @@ delete from "users" where id = $1 returning *;
 delete from "users";
 
 -- name: CreateLog :one
-insert into "logs" (user_id) values ($1) returning *;
+insert into "logs" (user_id, distance, access_token_id) values ($1, $2, $3) returning *;
 
 -- name: ListLogs :many
 select * from "logs";
 
 -- name: ListLogsByUser :many
-select * from "logs" where user_id = $1 order by created_at desc;
+select logs.*, access_tokens.name as access_token_name from "logs"
+left join "access_tokens" on access_tokens.id = logs.access_token_id
+where logs.user_id = $1 order by logs.created_at desc;
 
 -- name: DeleteOldLogs :execrows
 delete from "logs" where created_at < now() - interval '1 year';
 
--- name: GetRegistrationCode :one
-select code from "registration_code";
+-- name: ListInvitationCodes :many
+select * from "invitation_codes" where revoked_at is null order by created_at desc;
+
+-- name: GetInvitationCode :one
+select * from "invitation_codes" where id = $1;
+
+-- name: CreateInvitationCode :one
+-- Returns no row when the code is already used, without aborting the transaction
+insert into "invitation_codes" (code, label, expires_at, max_uses, apartment, "role", auto_approve, rotates)
+values ($1, $2, $3, $4, $5, $6, $7, $8) on conflict (code) do nothing returning *;
+
+-- name: ListInvitationCodesToRotate :many
+select * from "invitation_codes"
+where rotates and revoked_at is null and coalesce(rotated_at, created_at) < $1;
 
--- name: SetRegistrationCode :exec
-insert into "registration_code" (id, code) values (1, $1) on conflict (id) do update set code = $1;
+-- name: RotateInvitationCode :one
+update "invitation_codes" set code = $2, rotated_at = now() where id = $1 returning *;
 
--- name: CycleRegistrationCode :execrows
-update "registration_code" set code = lpad(floor(random() * 899999 + 100000)::text, 6, '0') where updated_at < now() - sqlc.arg(max_age)::text::interval;
+-- name: RevokeInvitationCode :execrows
+update "invitation_codes" set revoked_at = now() where id = $1 and revoked_at is null;
+
+-- name: GetValidInvitationCode :one
+select * from "invitation_codes"
+where code = $1 and revoked_at is null
+  and (expires_at is null or expires_at > now())
+  and (max_uses is null or uses < max_uses);
+
+-- name: UseInvitationCode :one
+update "invitation_codes" set uses = uses + 1
+where code = $1 and revoked_at is null
+  and (expires_at is null or expires_at > now())
+  and (max_uses is null or uses < max_uses)
+returning *;
+
+-- name: CountActiveInvitationCodes :one
+select count(*) from "invitation_codes" where revoked_at is null;
+
+-- name: RecordInvitationCode :one
+-- Renewals only record the code, the apartment and role it assigns are for new registrations
+update "users" set invitation_code_id = $2 where id = $1 returning *;
+
+-- name: ApplyInvitationCode :one
+update "users" set invitation_code_id = invitation_codes.id,
+  apartment = coalesce(invitation_codes.apartment, users.apartment),
+  "role" = coalesce(invitation_codes.role, users.role)
+from "invitation_codes"
+where users.id = sqlc.arg(user_id) and invitation_codes.id = sqlc.arg(invitation_code_id)
+returning users.*;
 
 -- name: ListUsersRegisteredSince :many
 select * from "users" where last_registration + sqlc.arg(since)::text::interval >= current_date  and last_registration + sqlc.arg(since)::text::interval < current_date + interval '1 day' ;
+
+-- name: ListUsersWithPermission :many
+select * from "users" where "role" in (select name from "roles" where sqlc.arg(permission)::text = any(permissions));
+
+-- name: ListRoles :many
+select * from "roles" order by builtin desc, label;
+
+-- name: GetRole :one
+select * from "roles" where name = $1;
+
+-- name: CreateRole :one
+insert into "roles" (name, label, permissions) values ($1, $2, $3) returning *;
+
+-- name: UpdateRole :one
+update "roles" set label = $2, permissions = $3 where name = $1 and not builtin returning *;
+
+-- name: DeleteRole :one
+delete from "roles" where name = $1 and not builtin returning *;
+
+-- name: GetLockdown :one
+select * from "lockdown_events" order by created_at desc limit 1;
+
+-- name: CreateLockdownEvent :one
+insert into "lockdown_events" (enabled, message, user_id) values ($1, $2, $3) returning *;
+
+-- name: ListLockdownEvents :many
+select sqlc.embed(lockdown_events), users.full_name from "lockdown_events"
+left join "users" on users.id = lockdown_events.user_id
+order by lockdown_events.created_at desc limit 20;
+
+-- name: ListLockdownExemptUsers :many
+select * from "users" where lockdown_exempt;
+
+-- name: ListWebauthnCredentialsByUser :many
+select * from "webauthn_credentials" where user_id = $1 order by created_at;
+
+-- name: CreateWebauthnCredential :one
+insert into "webauthn_credentials" (user_id, credential_id, name, credential) values ($1, $2, $3, $4) returning *;
+
+-- name: UpdateWebauthnCredentialUsage :exec
+update "webauthn_credentials" set credential = $2, last_used_at = now() where credential_id = $1;
+
+-- name: RenameWebauthnCredential :one
+update "webauthn_credentials" set name = $3 where id = $1 and user_id = $2 returning *;
+
+-- name: DeleteWebauthnCredential :one
+delete from "webauthn_credentials" where id = $1 and user_id = $2 returning *;
+
+-- name: SetTOTPSecret :exec
+update "users" set totp_secret = $2, totp_enabled = false, totp_last_step = 0 where id = $1;
+
+-- name: EnableTOTP :exec
+update "users" set totp_enabled = true where id = $1 and totp_secret != '';
+
+-- name: ResetTOTP :exec
+update "users" set totp_secret = '', totp_enabled = false, totp_last_step = 0 where id = $1;
+
+-- name: UseTOTPStep :execrows
+-- The code of a time step is accepted once, the next codes must be of a later step
+update "users" set totp_last_step = $2 where id = $1 and totp_last_step < $2;
+
+-- name: CreateTOTPRecoveryCode :exec
+insert into "totp_recovery_codes" (user_id, code_hash) values ($1, $2);
+
+-- name: UseTOTPRecoveryCode :execrows
+update "totp_recovery_codes" set used_at = now() where user_id = $1 and code_hash = $2 and used_at is null;
+
+-- name: CountUnusedTOTPRecoveryCodes :one
+select count(*) from "totp_recovery_codes" where user_id = $1 and used_at is null;
+
+-- name: DeleteTOTPRecoveryCodes :exec
+delete from "totp_recovery_codes" where user_id = $1;
+
+-- name: CreateSession :one
+insert into "sessions" (user_id, user_agent, ip) values ($1, $2, $3) returning *;
+
+-- name: GetActiveSession :one
+-- A session expires with its token, or when it was not used for as long
+select * from "sessions" where id = $1 and user_id = $2 and revoked_at is null
+  and created_at > now() - make_interval(days => sqlc.arg(max_age_days)::int)
+  and last_seen_at > now() - make_interval(days => sqlc.arg(max_age_days)::int);
+
+-- name: TouchSession :exec
+update "sessions" set last_seen_at = now(), ip = $2 where id = $1;
+
+-- name: ListActiveSessionsByUser :many
+select * from "sessions" where user_id = $1 and revoked_at is null
+  and created_at > now() - make_interval(days => sqlc.arg(max_age_days)::int)
+  and last_seen_at > now() - make_interval(days => sqlc.arg(max_age_days)::int)
+order by last_seen_at desc;
+
+-- name: RevokeSession :execrows
+update "sessions" set revoked_at = now() where id = $1 and user_id = $2 and revoked_at is null;
+
+-- name: RevokeOtherSessions :execrows
+update "sessions" set revoked_at = now() where user_id = $1 and id != $2 and revoked_at is null;
+
+-- name: RevokeUserSessions :execrows
+update "sessions" set revoked_at = now() where user_id = $1 and revoked_at is null;
+
+-- name: DeleteExpiredSessions :execrows
+delete from "sessions" where revoked_at is not null
+  or created_at <= now() - make_interval(days => sqlc.arg(max_age_days)::int)
+  or last_seen_at <= now() - make_interval(days => sqlc.arg(max_age_days)::int);
+
+-- name: UseToken :execrows
+insert into "used_token" (user_id, token) values ($1, $2) on conflict (token) do nothing;
+
+-- name: IsTokenUsed :one
+select exists(select 1 from "used_token" where token = $1);
+
+-- name: DeleteOldUsedTokens :execrows
+delete from "used_token" where created_at < $1;
+
+-- name: CreateAuthAttempt :exec
+insert into "auth_attempts" (kind, ip, email) values ($1, $2, $3);
+
+-- name: CountAuthAttemptsByIP :one
+select count(*) from "auth_attempts" where kind = $1 and ip = $2 and created_at > $3;
+
+-- name: CountAuthAttemptsByEmail :one
+select count(*) from "auth_attempts" where kind = $1 and lower(email) = lower(sqlc.arg(email)) and created_at > sqlc.arg(created_at);
+
+-- name: DeleteOldAuthAttempts :execrows
+delete from "auth_attempts" where created_at < now() - interval '1 day';
+
+-- name: GetLoginLockout :one
+select * from "login_lockouts" where user_id = $1;
+
+-- name: RecordLoginFailure :one
+insert into "login_lockouts" (user_id, failed_logins) values ($1, 1)
+on conflict (user_id) do update set
+  failed_logins = case when login_lockouts.last_failure_at < now() - interval '1 day' then 1 else login_lockouts.failed_logins + 1 end,
+  last_failure_at = now()
+returning *;
+
+-- name: LockAccount :exec
+update "login_lockouts" set locked_until = $2 where user_id = $1;
+
+-- name: DeleteLoginLockout :exec
+delete from "login_lockouts" where user_id = $1;
+
+-- name: ListLockedUsers :many
+select sqlc.embed(users), login_lockouts.locked_until from "users"
+join "login_lockouts" on login_lockouts.user_id = users.id
+where login_lockouts.locked_until > now()
+order by users.apartment;
+
+-- name: ListJWTKeys :many
+select * from "jwt_keys" where expires_at is null or expires_at > now() order by created_at desc;
+
+-- name: CreateJWTKey :one
+insert into "jwt_keys" (id, algorithm, secret) values ($1, $2, $3) returning *;
+
+-- name: UpdateJWTKeySecret :exec
+update "jwt_keys" set secret = $2 where id = $1;
+
+-- name: RetireJWTKeys :exec
+update "jwt_keys" set retired_at = now(), expires_at = $2 where id != $1 and retired_at is null;
+
+-- name: DeleteExpiredJWTKeys :execrows
+delete from "jwt_keys" where expires_at < now();
+
+-- name: GetUserByIdentity :one
+select users.* from "users"
+join "user_identities" on user_identities.user_id = users.id
+where user_identities.issuer = $1 and user_identities.subject = $2;
+
+-- name: CreateUserIdentity :one
+insert into "user_identities" (user_id, issuer, subject, email) values ($1, $2, $3, $4) returning *;
+
+-- name: ListUserIdentitiesByUser :many
+select * from "user_identities" where user_id = $1 order by created_at;
+
+-- name: UpdateUserIdentityUsage :exec
+update "user_identities" set last_used_at = now(), email = $3 where issuer = $1 and subject = $2;
+
+-- name: CreateAccessToken :one
+insert into "access_tokens" (user_id, name, token_hash, scopes, expires_at) values ($1, $2, $3, $4, $5) returning *;
+
+-- name: GetActiveAccessToken :one
+select sqlc.embed(access_tokens), sqlc.embed(users) from "access_tokens"
+join "users" on users.id = access_tokens.user_id
+where access_tokens.token_hash = $1 and access_tokens.revoked_at is null and access_tokens.expires_at > now();
+
+-- name: TouchAccessToken :exec
+update "access_tokens" set last_used_at = now() where id = $1;
+
+-- name: ListActiveAccessTokensByUser :many
+select * from "access_tokens" where user_id = $1 and revoked_at is null and expires_at > now() order by created_at desc;
+
+-- name: RevokeAccessToken :execrows
+update "access_tokens" set revoked_at = now() where id = $1 and user_id = $2 and revoked_at is null;
+
+-- name: CreateImpersonation :one
+insert into "impersonations" (admin_id, user_id, session_id, expires_at) values ($1, $2, $3, $4) returning *;
+
+-- name: GetActiveImpersonation :one
+select sqlc.embed(impersonations), sqlc.embed(users) from "impersonations"
+join "users" on users.id = impersonations.user_id
+where impersonations.id = $1 and impersonations.session_id = $2
+  and impersonations.ended_at is null and impersonations.expires_at > now();
+
+-- name: EndImpersonation :exec
+update "impersonations" set ended_at = now() where id = $1 and session_id = $2 and ended_at is null;
+
+-- name: ListImpersonations :many
+select sqlc.embed(impersonations), admins.full_name as admin_name, users.full_name as user_name, users.apartment as user_apartment
+from "impersonations"
+left join "users" admins on admins.id = impersonations.admin_id
+join "users" on users.id = impersonations.user_id
+order by impersonations.started_at desc limit 100;
+
+-- name: CreateAddressProofDownload :exec
+insert into "address_proof_downloads" (admin_id, user_id, ip, document, preview) values ($1, $2, $3, $4, $5);
+
+-- name: ListAddressProofDownloads :many
+select sqlc.embed(address_proof_downloads), admins.full_name as admin_name, users.full_name as user_name, users.apartment as user_apartment
+from "address_proof_downloads"
+left join "users" admins on admins.id = address_proof_downloads.admin_id
+join "users" on users.id = address_proof_downloads.user_id
+order by address_proof_downloads.created_at desc limit 100;
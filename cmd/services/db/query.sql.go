@@ -9,19 +9,421 @@ import (
 	"context"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const applyInvitationCode = `-- name: ApplyInvitationCode :one
+update "users" set invitation_code_id = invitation_codes.id,
+  apartment = coalesce(invitation_codes.apartment, users.apartment),
+  "role" = coalesce(invitation_codes.role, users.role)
+from "invitation_codes"
+where users.id = $1 and invitation_codes.id = $2
+returning users.id, users.email, users.full_name, users.apartment, users.pwd_salt, users.pwd_hash, users.pwd_iterations, users.pwd_parallelism, users.pwd_memory, users.pwd_version, users.role, users.email_verified, users.created_at, users.updated_at, users.registration_state, users.last_registration, users.lockdown_exempt, users.totp_secret, users.totp_enabled, users.invitation_code_id, users.renewal_requested_at, users.decision_reason, users.decision_comment, users.resubmission_allowed, users.totp_last_step
+`
+
+type ApplyInvitationCodeParams struct {
+	UserID           uuid.UUID
+	InvitationCodeID uuid.UUID
+}
+
+func (q *Queries) ApplyInvitationCode(ctx context.Context, arg ApplyInvitationCodeParams) (User, error) {
+	row := q.db.QueryRow(ctx, applyInvitationCode, arg.UserID, arg.InvitationCodeID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.FullName,
+		&i.Apartment,
+		&i.PwdSalt,
+		&i.PwdHash,
+		&i.PwdIterations,
+		&i.PwdParallelism,
+		&i.PwdMemory,
+		&i.PwdVersion,
+		&i.Role,
+		&i.EmailVerified,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RegistrationState,
+		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const countActiveInvitationCodes = `-- name: CountActiveInvitationCodes :one
+select count(*) from "invitation_codes" where revoked_at is null
+`
+
+func (q *Queries) CountActiveInvitationCodes(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, countActiveInvitationCodes)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countAuthAttemptsByEmail = `-- name: CountAuthAttemptsByEmail :one
+select count(*) from "auth_attempts" where kind = $1 and lower(email) = lower($2) and created_at > $3
+`
+
+type CountAuthAttemptsByEmailParams struct {
+	Kind      string
+	Email     string
+	CreatedAt pgtype.Timestamp
+}
+
+func (q *Queries) CountAuthAttemptsByEmail(ctx context.Context, arg CountAuthAttemptsByEmailParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countAuthAttemptsByEmail, arg.Kind, arg.Email, arg.CreatedAt)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countAuthAttemptsByIP = `-- name: CountAuthAttemptsByIP :one
+select count(*) from "auth_attempts" where kind = $1 and ip = $2 and created_at > $3
+`
+
+type CountAuthAttemptsByIPParams struct {
+	Kind      string
+	Ip        string
+	CreatedAt pgtype.Timestamp
+}
+
+func (q *Queries) CountAuthAttemptsByIP(ctx context.Context, arg CountAuthAttemptsByIPParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countAuthAttemptsByIP, arg.Kind, arg.Ip, arg.CreatedAt)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countOutdatedPasswords = `-- name: CountOutdatedPasswords :one
+select count(*) from "users"
+where pwd_iterations <> $1 or pwd_memory <> $2
+  or pwd_parallelism <> $3 or pwd_version <> $4
+`
+
+type CountOutdatedPasswordsParams struct {
+	Iterations  int32
+	Memory      int32
+	Parallelism int16
+	Version     int32
+}
+
+func (q *Queries) CountOutdatedPasswords(ctx context.Context, arg CountOutdatedPasswordsParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countOutdatedPasswords,
+		arg.Iterations,
+		arg.Memory,
+		arg.Parallelism,
+		arg.Version,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUnusedTOTPRecoveryCodes = `-- name: CountUnusedTOTPRecoveryCodes :one
+select count(*) from "totp_recovery_codes" where user_id = $1 and used_at is null
+`
+
+func (q *Queries) CountUnusedTOTPRecoveryCodes(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countUnusedTOTPRecoveryCodes, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAccessToken = `-- name: CreateAccessToken :one
+insert into "access_tokens" (user_id, name, token_hash, scopes, expires_at) values ($1, $2, $3, $4, $5) returning id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at, revoked_at
+`
+
+type CreateAccessTokenParams struct {
+	UserID    uuid.UUID
+	Name      string
+	TokenHash []byte
+	Scopes    []string
+	ExpiresAt pgtype.Timestamp
+}
+
+func (q *Queries) CreateAccessToken(ctx context.Context, arg CreateAccessTokenParams) (AccessToken, error) {
+	row := q.db.QueryRow(ctx, createAccessToken,
+		arg.UserID,
+		arg.Name,
+		arg.TokenHash,
+		arg.Scopes,
+		arg.ExpiresAt,
+	)
+	var i AccessToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.TokenHash,
+		&i.Scopes,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const createAddressProofDownload = `-- name: CreateAddressProofDownload :exec
+insert into "address_proof_downloads" (admin_id, user_id, ip, document, preview) values ($1, $2, $3, $4, $5)
+`
+
+type CreateAddressProofDownloadParams struct {
+	AdminID  pgtype.UUID
+	UserID   uuid.UUID
+	Ip       string
+	Document pgtype.Text
+	Preview  bool
+}
+
+func (q *Queries) CreateAddressProofDownload(ctx context.Context, arg CreateAddressProofDownloadParams) error {
+	_, err := q.db.Exec(ctx, createAddressProofDownload,
+		arg.AdminID,
+		arg.UserID,
+		arg.Ip,
+		arg.Document,
+		arg.Preview,
+	)
+	return err
+}
+
+const createAuthAttempt = `-- name: CreateAuthAttempt :exec
+insert into "auth_attempts" (kind, ip, email) values ($1, $2, $3)
+`
+
+type CreateAuthAttemptParams struct {
+	Kind  string
+	Ip    string
+	Email string
+}
+
+func (q *Queries) CreateAuthAttempt(ctx context.Context, arg CreateAuthAttemptParams) error {
+	_, err := q.db.Exec(ctx, createAuthAttempt, arg.Kind, arg.Ip, arg.Email)
+	return err
+}
+
+const createImpersonation = `-- name: CreateImpersonation :one
+insert into "impersonations" (admin_id, user_id, session_id, expires_at) values ($1, $2, $3, $4) returning id, admin_id, user_id, session_id, started_at, expires_at, ended_at
+`
+
+type CreateImpersonationParams struct {
+	AdminID   pgtype.UUID
+	UserID    uuid.UUID
+	SessionID pgtype.UUID
+	ExpiresAt pgtype.Timestamp
+}
+
+func (q *Queries) CreateImpersonation(ctx context.Context, arg CreateImpersonationParams) (Impersonation, error) {
+	row := q.db.QueryRow(ctx, createImpersonation,
+		arg.AdminID,
+		arg.UserID,
+		arg.SessionID,
+		arg.ExpiresAt,
+	)
+	var i Impersonation
+	err := row.Scan(
+		&i.ID,
+		&i.AdminID,
+		&i.UserID,
+		&i.SessionID,
+		&i.StartedAt,
+		&i.ExpiresAt,
+		&i.EndedAt,
+	)
+	return i, err
+}
+
+const createInvitationCode = `-- name: CreateInvitationCode :one
+insert into "invitation_codes" (code, label, expires_at, max_uses, apartment, "role", auto_approve, rotates)
+values ($1, $2, $3, $4, $5, $6, $7, $8) on conflict (code) do nothing returning id, code, label, expires_at, max_uses, uses, apartment, role, auto_approve, created_at, revoked_at, rotates, rotated_at
+`
+
+type CreateInvitationCodeParams struct {
+	Code        string
+	Label       string
+	ExpiresAt   pgtype.Timestamp
+	MaxUses     pgtype.Int4
+	Apartment   pgtype.Text
+	Role        pgtype.Text
+	AutoApprove bool
+	Rotates     bool
+}
+
+// Returns no row when the code is already used, without aborting the transaction
+func (q *Queries) CreateInvitationCode(ctx context.Context, arg CreateInvitationCodeParams) (InvitationCode, error) {
+	row := q.db.QueryRow(ctx, createInvitationCode,
+		arg.Code,
+		arg.Label,
+		arg.ExpiresAt,
+		arg.MaxUses,
+		arg.Apartment,
+		arg.Role,
+		arg.AutoApprove,
+		arg.Rotates,
+	)
+	var i InvitationCode
+	err := row.Scan(
+		&i.ID,
+		&i.Code,
+		&i.Label,
+		&i.ExpiresAt,
+		&i.MaxUses,
+		&i.Uses,
+		&i.Apartment,
+		&i.Role,
+		&i.AutoApprove,
+		&i.CreatedAt,
+		&i.RevokedAt,
+		&i.Rotates,
+		&i.RotatedAt,
+	)
+	return i, err
+}
+
+const createJWTKey = `-- name: CreateJWTKey :one
+insert into "jwt_keys" (id, algorithm, secret) values ($1, $2, $3) returning id, algorithm, secret, created_at, retired_at, expires_at
+`
+
+type CreateJWTKeyParams struct {
+	ID        string
+	Algorithm string
+	Secret    []byte
+}
+
+func (q *Queries) CreateJWTKey(ctx context.Context, arg CreateJWTKeyParams) (JwtKey, error) {
+	row := q.db.QueryRow(ctx, createJWTKey, arg.ID, arg.Algorithm, arg.Secret)
+	var i JwtKey
+	err := row.Scan(
+		&i.ID,
+		&i.Algorithm,
+		&i.Secret,
+		&i.CreatedAt,
+		&i.RetiredAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const createLockdownEvent = `-- name: CreateLockdownEvent :one
+insert into "lockdown_events" (enabled, message, user_id) values ($1, $2, $3) returning id, enabled, message, user_id, created_at
+`
+
+type CreateLockdownEventParams struct {
+	Enabled bool
+	Message string
+	UserID  pgtype.UUID
+}
+
+func (q *Queries) CreateLockdownEvent(ctx context.Context, arg CreateLockdownEventParams) (LockdownEvent, error) {
+	row := q.db.QueryRow(ctx, createLockdownEvent, arg.Enabled, arg.Message, arg.UserID)
+	var i LockdownEvent
+	err := row.Scan(
+		&i.ID,
+		&i.Enabled,
+		&i.Message,
+		&i.UserID,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const createLog = `-- name: CreateLog :one
-insert into "logs" (user_id) values ($1) returning id, user_id, created_at
+insert into "logs" (user_id, distance, access_token_id) values ($1, $2, $3) returning id, user_id, created_at, distance, access_token_id
 `
 
-func (q *Queries) CreateLog(ctx context.Context, userID uuid.UUID) (Log, error) {
-	row := q.db.QueryRow(ctx, createLog, userID)
+type CreateLogParams struct {
+	UserID        uuid.UUID
+	Distance      pgtype.Float8
+	AccessTokenID pgtype.UUID
+}
+
+func (q *Queries) CreateLog(ctx context.Context, arg CreateLogParams) (Log, error) {
+	row := q.db.QueryRow(ctx, createLog, arg.UserID, arg.Distance, arg.AccessTokenID)
 	var i Log
-	err := row.Scan(&i.ID, &i.UserID, &i.CreatedAt)
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.Distance,
+		&i.AccessTokenID,
+	)
+	return i, err
+}
+
+const createRole = `-- name: CreateRole :one
+insert into "roles" (name, label, permissions) values ($1, $2, $3) returning name, label, permissions, builtin, created_at, updated_at
+`
+
+type CreateRoleParams struct {
+	Name        string
+	Label       string
+	Permissions []string
+}
+
+func (q *Queries) CreateRole(ctx context.Context, arg CreateRoleParams) (Role, error) {
+	row := q.db.QueryRow(ctx, createRole, arg.Name, arg.Label, arg.Permissions)
+	var i Role
+	err := row.Scan(
+		&i.Name,
+		&i.Label,
+		&i.Permissions,
+		&i.Builtin,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createSession = `-- name: CreateSession :one
+insert into "sessions" (user_id, user_agent, ip) values ($1, $2, $3) returning id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at
+`
+
+type CreateSessionParams struct {
+	UserID    uuid.UUID
+	UserAgent string
+	Ip        string
+}
+
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error) {
+	row := q.db.QueryRow(ctx, createSession, arg.UserID, arg.UserAgent, arg.Ip)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.UserAgent,
+		&i.Ip,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.RevokedAt,
+	)
 	return i, err
 }
 
+const createTOTPRecoveryCode = `-- name: CreateTOTPRecoveryCode :exec
+insert into "totp_recovery_codes" (user_id, code_hash) values ($1, $2)
+`
+
+type CreateTOTPRecoveryCodeParams struct {
+	UserID   uuid.UUID
+	CodeHash string
+}
+
+func (q *Queries) CreateTOTPRecoveryCode(ctx context.Context, arg CreateTOTPRecoveryCodeParams) error {
+	_, err := q.db.Exec(ctx, createTOTPRecoveryCode, arg.UserID, arg.CodeHash)
+	return err
+}
+
 const createUser = `-- name: CreateUser :one
 insert into "users" (email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, "role", registration_state) 
 values (
@@ -29,7 +431,7 @@ values (
   (select (case when count(id) = 0 then 'admin' else 'user' end) role from "users"),
   (select (case when count(id) = 0 then 'accepted' else 'new' end) registration_state from "users")
 ) 
-returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration
+returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
 `
 
 type CreateUserParams struct {
@@ -74,16 +476,122 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.UpdatedAt,
 		&i.RegistrationState,
 		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const createUserIdentity = `-- name: CreateUserIdentity :one
+insert into "user_identities" (user_id, issuer, subject, email) values ($1, $2, $3, $4) returning id, user_id, issuer, subject, email, created_at, last_used_at
+`
+
+type CreateUserIdentityParams struct {
+	UserID  uuid.UUID
+	Issuer  string
+	Subject string
+	Email   string
+}
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) (UserIdentity, error) {
+	row := q.db.QueryRow(ctx, createUserIdentity,
+		arg.UserID,
+		arg.Issuer,
+		arg.Subject,
+		arg.Email,
+	)
+	var i UserIdentity
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Issuer,
+		&i.Subject,
+		&i.Email,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const createWebauthnCredential = `-- name: CreateWebauthnCredential :one
+insert into "webauthn_credentials" (user_id, credential_id, name, credential) values ($1, $2, $3, $4) returning id, user_id, credential_id, name, credential, created_at, last_used_at
+`
+
+type CreateWebauthnCredentialParams struct {
+	UserID       uuid.UUID
+	CredentialID []byte
+	Name         string
+	Credential   []byte
+}
+
+func (q *Queries) CreateWebauthnCredential(ctx context.Context, arg CreateWebauthnCredentialParams) (WebauthnCredential, error) {
+	row := q.db.QueryRow(ctx, createWebauthnCredential,
+		arg.UserID,
+		arg.CredentialID,
+		arg.Name,
+		arg.Credential,
+	)
+	var i WebauthnCredential
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CredentialID,
+		&i.Name,
+		&i.Credential,
+		&i.CreatedAt,
+		&i.LastUsedAt,
 	)
 	return i, err
 }
 
-const cycleRegistrationCode = `-- name: CycleRegistrationCode :execrows
-update "registration_code" set code = lpad(floor(random() * 899999 + 100000)::text, 6, '0') where updated_at < now() - $1::text::interval
+const deleteExpiredJWTKeys = `-- name: DeleteExpiredJWTKeys :execrows
+delete from "jwt_keys" where expires_at < now()
+`
+
+func (q *Queries) DeleteExpiredJWTKeys(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredJWTKeys)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :execrows
+delete from "sessions" where revoked_at is not null
+  or created_at <= now() - make_interval(days => $1::int)
+  or last_seen_at <= now() - make_interval(days => $1::int)
+`
+
+func (q *Queries) DeleteExpiredSessions(ctx context.Context, maxAgeDays int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredSessions, maxAgeDays)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteLoginLockout = `-- name: DeleteLoginLockout :exec
+delete from "login_lockouts" where user_id = $1
+`
+
+func (q *Queries) DeleteLoginLockout(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deleteLoginLockout, userID)
+	return err
+}
+
+const deleteOldAuthAttempts = `-- name: DeleteOldAuthAttempts :execrows
+delete from "auth_attempts" where created_at < now() - interval '1 day'
 `
 
-func (q *Queries) CycleRegistrationCode(ctx context.Context, maxAge string) (int64, error) {
-	result, err := q.db.Exec(ctx, cycleRegistrationCode, maxAge)
+func (q *Queries) DeleteOldAuthAttempts(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOldAuthAttempts)
 	if err != nil {
 		return 0, err
 	}
@@ -102,8 +610,47 @@ func (q *Queries) DeleteOldLogs(ctx context.Context) (int64, error) {
 	return result.RowsAffected(), nil
 }
 
+const deleteOldUsedTokens = `-- name: DeleteOldUsedTokens :execrows
+delete from "used_token" where created_at < $1
+`
+
+func (q *Queries) DeleteOldUsedTokens(ctx context.Context, createdAt pgtype.Timestamp) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOldUsedTokens, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteRole = `-- name: DeleteRole :one
+delete from "roles" where name = $1 and not builtin returning name, label, permissions, builtin, created_at, updated_at
+`
+
+func (q *Queries) DeleteRole(ctx context.Context, name string) (Role, error) {
+	row := q.db.QueryRow(ctx, deleteRole, name)
+	var i Role
+	err := row.Scan(
+		&i.Name,
+		&i.Label,
+		&i.Permissions,
+		&i.Builtin,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const deleteTOTPRecoveryCodes = `-- name: DeleteTOTPRecoveryCodes :exec
+delete from "totp_recovery_codes" where user_id = $1
+`
+
+func (q *Queries) DeleteTOTPRecoveryCodes(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deleteTOTPRecoveryCodes, userID)
+	return err
+}
+
 const deleteUser = `-- name: DeleteUser :one
-delete from "users" where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration
+delete from "users" where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
 `
 
 func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) (User, error) {
@@ -126,6 +673,39 @@ func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.UpdatedAt,
 		&i.RegistrationState,
 		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const deleteWebauthnCredential = `-- name: DeleteWebauthnCredential :one
+delete from "webauthn_credentials" where id = $1 and user_id = $2 returning id, user_id, credential_id, name, credential, created_at, last_used_at
+`
+
+type DeleteWebauthnCredentialParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) DeleteWebauthnCredential(ctx context.Context, arg DeleteWebauthnCredentialParams) (WebauthnCredential, error) {
+	row := q.db.QueryRow(ctx, deleteWebauthnCredential, arg.ID, arg.UserID)
+	var i WebauthnCredential
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CredentialID,
+		&i.Name,
+		&i.Credential,
+		&i.CreatedAt,
+		&i.LastUsedAt,
 	)
 	return i, err
 }
@@ -148,30 +728,256 @@ func (q *Queries) EmailVerified(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
-const getRegistrationCode = `-- name: GetRegistrationCode :one
-select code from "registration_code"
+const enableTOTP = `-- name: EnableTOTP :exec
+update "users" set totp_enabled = true where id = $1 and totp_secret != ''
 `
 
-func (q *Queries) GetRegistrationCode(ctx context.Context) (string, error) {
-	row := q.db.QueryRow(ctx, getRegistrationCode)
-	var code string
-	err := row.Scan(&code)
-	return code, err
+func (q *Queries) EnableTOTP(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, enableTOTP, id)
+	return err
 }
 
-const getUser = `-- name: GetUser :one
-select id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration from "users" where id = $1
+const endImpersonation = `-- name: EndImpersonation :exec
+update "impersonations" set ended_at = now() where id = $1 and session_id = $2 and ended_at is null
 `
 
-func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
-	row := q.db.QueryRow(ctx, getUser, id)
-	var i User
-	err := row.Scan(
-		&i.ID,
-		&i.Email,
-		&i.FullName,
-		&i.Apartment,
-		&i.PwdSalt,
+type EndImpersonationParams struct {
+	ID        uuid.UUID
+	SessionID pgtype.UUID
+}
+
+func (q *Queries) EndImpersonation(ctx context.Context, arg EndImpersonationParams) error {
+	_, err := q.db.Exec(ctx, endImpersonation, arg.ID, arg.SessionID)
+	return err
+}
+
+const getActiveAccessToken = `-- name: GetActiveAccessToken :one
+select access_tokens.id, access_tokens.user_id, access_tokens.name, access_tokens.token_hash, access_tokens.scopes, access_tokens.created_at, access_tokens.expires_at, access_tokens.last_used_at, access_tokens.revoked_at, users.id, users.email, users.full_name, users.apartment, users.pwd_salt, users.pwd_hash, users.pwd_iterations, users.pwd_parallelism, users.pwd_memory, users.pwd_version, users.role, users.email_verified, users.created_at, users.updated_at, users.registration_state, users.last_registration, users.lockdown_exempt, users.totp_secret, users.totp_enabled, users.invitation_code_id, users.renewal_requested_at, users.decision_reason, users.decision_comment, users.resubmission_allowed, users.totp_last_step from "access_tokens"
+join "users" on users.id = access_tokens.user_id
+where access_tokens.token_hash = $1 and access_tokens.revoked_at is null and access_tokens.expires_at > now()
+`
+
+type GetActiveAccessTokenRow struct {
+	AccessToken AccessToken
+	User        User
+}
+
+func (q *Queries) GetActiveAccessToken(ctx context.Context, tokenHash []byte) (GetActiveAccessTokenRow, error) {
+	row := q.db.QueryRow(ctx, getActiveAccessToken, tokenHash)
+	var i GetActiveAccessTokenRow
+	err := row.Scan(
+		&i.AccessToken.ID,
+		&i.AccessToken.UserID,
+		&i.AccessToken.Name,
+		&i.AccessToken.TokenHash,
+		&i.AccessToken.Scopes,
+		&i.AccessToken.CreatedAt,
+		&i.AccessToken.ExpiresAt,
+		&i.AccessToken.LastUsedAt,
+		&i.AccessToken.RevokedAt,
+		&i.User.ID,
+		&i.User.Email,
+		&i.User.FullName,
+		&i.User.Apartment,
+		&i.User.PwdSalt,
+		&i.User.PwdHash,
+		&i.User.PwdIterations,
+		&i.User.PwdParallelism,
+		&i.User.PwdMemory,
+		&i.User.PwdVersion,
+		&i.User.Role,
+		&i.User.EmailVerified,
+		&i.User.CreatedAt,
+		&i.User.UpdatedAt,
+		&i.User.RegistrationState,
+		&i.User.LastRegistration,
+		&i.User.LockdownExempt,
+		&i.User.TotpSecret,
+		&i.User.TotpEnabled,
+		&i.User.InvitationCodeID,
+		&i.User.RenewalRequestedAt,
+		&i.User.DecisionReason,
+		&i.User.DecisionComment,
+		&i.User.ResubmissionAllowed,
+		&i.User.TotpLastStep,
+	)
+	return i, err
+}
+
+const getActiveImpersonation = `-- name: GetActiveImpersonation :one
+select impersonations.id, impersonations.admin_id, impersonations.user_id, impersonations.session_id, impersonations.started_at, impersonations.expires_at, impersonations.ended_at, users.id, users.email, users.full_name, users.apartment, users.pwd_salt, users.pwd_hash, users.pwd_iterations, users.pwd_parallelism, users.pwd_memory, users.pwd_version, users.role, users.email_verified, users.created_at, users.updated_at, users.registration_state, users.last_registration, users.lockdown_exempt, users.totp_secret, users.totp_enabled, users.invitation_code_id, users.renewal_requested_at, users.decision_reason, users.decision_comment, users.resubmission_allowed, users.totp_last_step from "impersonations"
+join "users" on users.id = impersonations.user_id
+where impersonations.id = $1 and impersonations.session_id = $2
+  and impersonations.ended_at is null and impersonations.expires_at > now()
+`
+
+type GetActiveImpersonationParams struct {
+	ID        uuid.UUID
+	SessionID pgtype.UUID
+}
+
+type GetActiveImpersonationRow struct {
+	Impersonation Impersonation
+	User          User
+}
+
+func (q *Queries) GetActiveImpersonation(ctx context.Context, arg GetActiveImpersonationParams) (GetActiveImpersonationRow, error) {
+	row := q.db.QueryRow(ctx, getActiveImpersonation, arg.ID, arg.SessionID)
+	var i GetActiveImpersonationRow
+	err := row.Scan(
+		&i.Impersonation.ID,
+		&i.Impersonation.AdminID,
+		&i.Impersonation.UserID,
+		&i.Impersonation.SessionID,
+		&i.Impersonation.StartedAt,
+		&i.Impersonation.ExpiresAt,
+		&i.Impersonation.EndedAt,
+		&i.User.ID,
+		&i.User.Email,
+		&i.User.FullName,
+		&i.User.Apartment,
+		&i.User.PwdSalt,
+		&i.User.PwdHash,
+		&i.User.PwdIterations,
+		&i.User.PwdParallelism,
+		&i.User.PwdMemory,
+		&i.User.PwdVersion,
+		&i.User.Role,
+		&i.User.EmailVerified,
+		&i.User.CreatedAt,
+		&i.User.UpdatedAt,
+		&i.User.RegistrationState,
+		&i.User.LastRegistration,
+		&i.User.LockdownExempt,
+		&i.User.TotpSecret,
+		&i.User.TotpEnabled,
+		&i.User.InvitationCodeID,
+		&i.User.RenewalRequestedAt,
+		&i.User.DecisionReason,
+		&i.User.DecisionComment,
+		&i.User.ResubmissionAllowed,
+		&i.User.TotpLastStep,
+	)
+	return i, err
+}
+
+const getActiveSession = `-- name: GetActiveSession :one
+select id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at from "sessions" where id = $1 and user_id = $2 and revoked_at is null
+  and created_at > now() - make_interval(days => $3::int)
+  and last_seen_at > now() - make_interval(days => $3::int)
+`
+
+type GetActiveSessionParams struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	MaxAgeDays int32
+}
+
+// A session expires with its token, or when it was not used for as long
+func (q *Queries) GetActiveSession(ctx context.Context, arg GetActiveSessionParams) (Session, error) {
+	row := q.db.QueryRow(ctx, getActiveSession, arg.ID, arg.UserID, arg.MaxAgeDays)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.UserAgent,
+		&i.Ip,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const getInvitationCode = `-- name: GetInvitationCode :one
+select id, code, label, expires_at, max_uses, uses, apartment, role, auto_approve, created_at, revoked_at, rotates, rotated_at from "invitation_codes" where id = $1
+`
+
+func (q *Queries) GetInvitationCode(ctx context.Context, id uuid.UUID) (InvitationCode, error) {
+	row := q.db.QueryRow(ctx, getInvitationCode, id)
+	var i InvitationCode
+	err := row.Scan(
+		&i.ID,
+		&i.Code,
+		&i.Label,
+		&i.ExpiresAt,
+		&i.MaxUses,
+		&i.Uses,
+		&i.Apartment,
+		&i.Role,
+		&i.AutoApprove,
+		&i.CreatedAt,
+		&i.RevokedAt,
+		&i.Rotates,
+		&i.RotatedAt,
+	)
+	return i, err
+}
+
+const getLockdown = `-- name: GetLockdown :one
+select id, enabled, message, user_id, created_at from "lockdown_events" order by created_at desc limit 1
+`
+
+func (q *Queries) GetLockdown(ctx context.Context) (LockdownEvent, error) {
+	row := q.db.QueryRow(ctx, getLockdown)
+	var i LockdownEvent
+	err := row.Scan(
+		&i.ID,
+		&i.Enabled,
+		&i.Message,
+		&i.UserID,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getLoginLockout = `-- name: GetLoginLockout :one
+select user_id, failed_logins, last_failure_at, locked_until from "login_lockouts" where user_id = $1
+`
+
+func (q *Queries) GetLoginLockout(ctx context.Context, userID uuid.UUID) (LoginLockout, error) {
+	row := q.db.QueryRow(ctx, getLoginLockout, userID)
+	var i LoginLockout
+	err := row.Scan(
+		&i.UserID,
+		&i.FailedLogins,
+		&i.LastFailureAt,
+		&i.LockedUntil,
+	)
+	return i, err
+}
+
+const getRole = `-- name: GetRole :one
+select name, label, permissions, builtin, created_at, updated_at from "roles" where name = $1
+`
+
+func (q *Queries) GetRole(ctx context.Context, name string) (Role, error) {
+	row := q.db.QueryRow(ctx, getRole, name)
+	var i Role
+	err := row.Scan(
+		&i.Name,
+		&i.Label,
+		&i.Permissions,
+		&i.Builtin,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getUser = `-- name: GetUser :one
+select id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step from "users" where id = $1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRow(ctx, getUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.FullName,
+		&i.Apartment,
+		&i.PwdSalt,
 		&i.PwdHash,
 		&i.PwdIterations,
 		&i.PwdParallelism,
@@ -183,12 +989,21 @@ func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.UpdatedAt,
 		&i.RegistrationState,
 		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-select id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration from "users" where email = $1
+select id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step from "users" where email = $1
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -211,24 +1026,585 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.UpdatedAt,
 		&i.RegistrationState,
 		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
 	)
 	return i, err
 }
 
-const listLogs = `-- name: ListLogs :many
-select id, user_id, created_at from "logs"
+const getUserByIdentity = `-- name: GetUserByIdentity :one
+select users.id, users.email, users.full_name, users.apartment, users.pwd_salt, users.pwd_hash, users.pwd_iterations, users.pwd_parallelism, users.pwd_memory, users.pwd_version, users.role, users.email_verified, users.created_at, users.updated_at, users.registration_state, users.last_registration, users.lockdown_exempt, users.totp_secret, users.totp_enabled, users.invitation_code_id, users.renewal_requested_at, users.decision_reason, users.decision_comment, users.resubmission_allowed, users.totp_last_step from "users"
+join "user_identities" on user_identities.user_id = users.id
+where user_identities.issuer = $1 and user_identities.subject = $2
+`
+
+type GetUserByIdentityParams struct {
+	Issuer  string
+	Subject string
+}
+
+func (q *Queries) GetUserByIdentity(ctx context.Context, arg GetUserByIdentityParams) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByIdentity, arg.Issuer, arg.Subject)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.FullName,
+		&i.Apartment,
+		&i.PwdSalt,
+		&i.PwdHash,
+		&i.PwdIterations,
+		&i.PwdParallelism,
+		&i.PwdMemory,
+		&i.PwdVersion,
+		&i.Role,
+		&i.EmailVerified,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RegistrationState,
+		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const getValidInvitationCode = `-- name: GetValidInvitationCode :one
+select id, code, label, expires_at, max_uses, uses, apartment, role, auto_approve, created_at, revoked_at, rotates, rotated_at from "invitation_codes"
+where code = $1 and revoked_at is null
+  and (expires_at is null or expires_at > now())
+  and (max_uses is null or uses < max_uses)
+`
+
+func (q *Queries) GetValidInvitationCode(ctx context.Context, code string) (InvitationCode, error) {
+	row := q.db.QueryRow(ctx, getValidInvitationCode, code)
+	var i InvitationCode
+	err := row.Scan(
+		&i.ID,
+		&i.Code,
+		&i.Label,
+		&i.ExpiresAt,
+		&i.MaxUses,
+		&i.Uses,
+		&i.Apartment,
+		&i.Role,
+		&i.AutoApprove,
+		&i.CreatedAt,
+		&i.RevokedAt,
+		&i.Rotates,
+		&i.RotatedAt,
+	)
+	return i, err
+}
+
+const isTokenUsed = `-- name: IsTokenUsed :one
+select exists(select 1 from "used_token" where token = $1)
+`
+
+func (q *Queries) IsTokenUsed(ctx context.Context, token string) (bool, error) {
+	row := q.db.QueryRow(ctx, isTokenUsed, token)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const listActiveAccessTokensByUser = `-- name: ListActiveAccessTokensByUser :many
+select id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at, revoked_at from "access_tokens" where user_id = $1 and revoked_at is null and expires_at > now() order by created_at desc
+`
+
+func (q *Queries) ListActiveAccessTokensByUser(ctx context.Context, userID uuid.UUID) ([]AccessToken, error) {
+	rows, err := q.db.Query(ctx, listActiveAccessTokensByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AccessToken
+	for rows.Next() {
+		var i AccessToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.TokenHash,
+			&i.Scopes,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listActiveSessionsByUser = `-- name: ListActiveSessionsByUser :many
+select id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at from "sessions" where user_id = $1 and revoked_at is null
+  and created_at > now() - make_interval(days => $2::int)
+  and last_seen_at > now() - make_interval(days => $2::int)
+order by last_seen_at desc
+`
+
+type ListActiveSessionsByUserParams struct {
+	UserID     uuid.UUID
+	MaxAgeDays int32
+}
+
+func (q *Queries) ListActiveSessionsByUser(ctx context.Context, arg ListActiveSessionsByUserParams) ([]Session, error) {
+	rows, err := q.db.Query(ctx, listActiveSessionsByUser, arg.UserID, arg.MaxAgeDays)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.UserAgent,
+			&i.Ip,
+			&i.CreatedAt,
+			&i.LastSeenAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAddressProofDownloads = `-- name: ListAddressProofDownloads :many
+select address_proof_downloads.id, address_proof_downloads.admin_id, address_proof_downloads.user_id, address_proof_downloads.ip, address_proof_downloads.created_at, address_proof_downloads.document, address_proof_downloads.preview, admins.full_name as admin_name, users.full_name as user_name, users.apartment as user_apartment
+from "address_proof_downloads"
+left join "users" admins on admins.id = address_proof_downloads.admin_id
+join "users" on users.id = address_proof_downloads.user_id
+order by address_proof_downloads.created_at desc limit 100
+`
+
+type ListAddressProofDownloadsRow struct {
+	AddressProofDownload AddressProofDownload
+	AdminName            pgtype.Text
+	UserName             string
+	UserApartment        string
+}
+
+func (q *Queries) ListAddressProofDownloads(ctx context.Context) ([]ListAddressProofDownloadsRow, error) {
+	rows, err := q.db.Query(ctx, listAddressProofDownloads)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListAddressProofDownloadsRow
+	for rows.Next() {
+		var i ListAddressProofDownloadsRow
+		if err := rows.Scan(
+			&i.AddressProofDownload.ID,
+			&i.AddressProofDownload.AdminID,
+			&i.AddressProofDownload.UserID,
+			&i.AddressProofDownload.Ip,
+			&i.AddressProofDownload.CreatedAt,
+			&i.AddressProofDownload.Document,
+			&i.AddressProofDownload.Preview,
+			&i.AdminName,
+			&i.UserName,
+			&i.UserApartment,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listImpersonations = `-- name: ListImpersonations :many
+select impersonations.id, impersonations.admin_id, impersonations.user_id, impersonations.session_id, impersonations.started_at, impersonations.expires_at, impersonations.ended_at, admins.full_name as admin_name, users.full_name as user_name, users.apartment as user_apartment
+from "impersonations"
+left join "users" admins on admins.id = impersonations.admin_id
+join "users" on users.id = impersonations.user_id
+order by impersonations.started_at desc limit 100
+`
+
+type ListImpersonationsRow struct {
+	Impersonation Impersonation
+	AdminName     pgtype.Text
+	UserName      string
+	UserApartment string
+}
+
+func (q *Queries) ListImpersonations(ctx context.Context) ([]ListImpersonationsRow, error) {
+	rows, err := q.db.Query(ctx, listImpersonations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListImpersonationsRow
+	for rows.Next() {
+		var i ListImpersonationsRow
+		if err := rows.Scan(
+			&i.Impersonation.ID,
+			&i.Impersonation.AdminID,
+			&i.Impersonation.UserID,
+			&i.Impersonation.SessionID,
+			&i.Impersonation.StartedAt,
+			&i.Impersonation.ExpiresAt,
+			&i.Impersonation.EndedAt,
+			&i.AdminName,
+			&i.UserName,
+			&i.UserApartment,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listInvitationCodes = `-- name: ListInvitationCodes :many
+select id, code, label, expires_at, max_uses, uses, apartment, role, auto_approve, created_at, revoked_at, rotates, rotated_at from "invitation_codes" where revoked_at is null order by created_at desc
+`
+
+func (q *Queries) ListInvitationCodes(ctx context.Context) ([]InvitationCode, error) {
+	rows, err := q.db.Query(ctx, listInvitationCodes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []InvitationCode
+	for rows.Next() {
+		var i InvitationCode
+		if err := rows.Scan(
+			&i.ID,
+			&i.Code,
+			&i.Label,
+			&i.ExpiresAt,
+			&i.MaxUses,
+			&i.Uses,
+			&i.Apartment,
+			&i.Role,
+			&i.AutoApprove,
+			&i.CreatedAt,
+			&i.RevokedAt,
+			&i.Rotates,
+			&i.RotatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listInvitationCodesToRotate = `-- name: ListInvitationCodesToRotate :many
+select id, code, label, expires_at, max_uses, uses, apartment, role, auto_approve, created_at, revoked_at, rotates, rotated_at from "invitation_codes"
+where rotates and revoked_at is null and coalesce(rotated_at, created_at) < $1
+`
+
+func (q *Queries) ListInvitationCodesToRotate(ctx context.Context, rotatedAt pgtype.Timestamp) ([]InvitationCode, error) {
+	rows, err := q.db.Query(ctx, listInvitationCodesToRotate, rotatedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []InvitationCode
+	for rows.Next() {
+		var i InvitationCode
+		if err := rows.Scan(
+			&i.ID,
+			&i.Code,
+			&i.Label,
+			&i.ExpiresAt,
+			&i.MaxUses,
+			&i.Uses,
+			&i.Apartment,
+			&i.Role,
+			&i.AutoApprove,
+			&i.CreatedAt,
+			&i.RevokedAt,
+			&i.Rotates,
+			&i.RotatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listJWTKeys = `-- name: ListJWTKeys :many
+select id, algorithm, secret, created_at, retired_at, expires_at from "jwt_keys" where expires_at is null or expires_at > now() order by created_at desc
+`
+
+func (q *Queries) ListJWTKeys(ctx context.Context) ([]JwtKey, error) {
+	rows, err := q.db.Query(ctx, listJWTKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []JwtKey
+	for rows.Next() {
+		var i JwtKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.Algorithm,
+			&i.Secret,
+			&i.CreatedAt,
+			&i.RetiredAt,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLockdownEvents = `-- name: ListLockdownEvents :many
+select lockdown_events.id, lockdown_events.enabled, lockdown_events.message, lockdown_events.user_id, lockdown_events.created_at, users.full_name from "lockdown_events"
+left join "users" on users.id = lockdown_events.user_id
+order by lockdown_events.created_at desc limit 20
+`
+
+type ListLockdownEventsRow struct {
+	LockdownEvent LockdownEvent
+	FullName      pgtype.Text
+}
+
+func (q *Queries) ListLockdownEvents(ctx context.Context) ([]ListLockdownEventsRow, error) {
+	rows, err := q.db.Query(ctx, listLockdownEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListLockdownEventsRow
+	for rows.Next() {
+		var i ListLockdownEventsRow
+		if err := rows.Scan(
+			&i.LockdownEvent.ID,
+			&i.LockdownEvent.Enabled,
+			&i.LockdownEvent.Message,
+			&i.LockdownEvent.UserID,
+			&i.LockdownEvent.CreatedAt,
+			&i.FullName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLockdownExemptUsers = `-- name: ListLockdownExemptUsers :many
+select id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step from "users" where lockdown_exempt
+`
+
+func (q *Queries) ListLockdownExemptUsers(ctx context.Context) ([]User, error) {
+	rows, err := q.db.Query(ctx, listLockdownExemptUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.FullName,
+			&i.Apartment,
+			&i.PwdSalt,
+			&i.PwdHash,
+			&i.PwdIterations,
+			&i.PwdParallelism,
+			&i.PwdMemory,
+			&i.PwdVersion,
+			&i.Role,
+			&i.EmailVerified,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.RegistrationState,
+			&i.LastRegistration,
+			&i.LockdownExempt,
+			&i.TotpSecret,
+			&i.TotpEnabled,
+			&i.InvitationCodeID,
+			&i.RenewalRequestedAt,
+			&i.DecisionReason,
+			&i.DecisionComment,
+			&i.ResubmissionAllowed,
+			&i.TotpLastStep,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLockedUsers = `-- name: ListLockedUsers :many
+select users.id, users.email, users.full_name, users.apartment, users.pwd_salt, users.pwd_hash, users.pwd_iterations, users.pwd_parallelism, users.pwd_memory, users.pwd_version, users.role, users.email_verified, users.created_at, users.updated_at, users.registration_state, users.last_registration, users.lockdown_exempt, users.totp_secret, users.totp_enabled, users.invitation_code_id, users.renewal_requested_at, users.decision_reason, users.decision_comment, users.resubmission_allowed, users.totp_last_step, login_lockouts.locked_until from "users"
+join "login_lockouts" on login_lockouts.user_id = users.id
+where login_lockouts.locked_until > now()
+order by users.apartment
+`
+
+type ListLockedUsersRow struct {
+	User        User
+	LockedUntil pgtype.Timestamp
+}
+
+func (q *Queries) ListLockedUsers(ctx context.Context) ([]ListLockedUsersRow, error) {
+	rows, err := q.db.Query(ctx, listLockedUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListLockedUsersRow
+	for rows.Next() {
+		var i ListLockedUsersRow
+		if err := rows.Scan(
+			&i.User.ID,
+			&i.User.Email,
+			&i.User.FullName,
+			&i.User.Apartment,
+			&i.User.PwdSalt,
+			&i.User.PwdHash,
+			&i.User.PwdIterations,
+			&i.User.PwdParallelism,
+			&i.User.PwdMemory,
+			&i.User.PwdVersion,
+			&i.User.Role,
+			&i.User.EmailVerified,
+			&i.User.CreatedAt,
+			&i.User.UpdatedAt,
+			&i.User.RegistrationState,
+			&i.User.LastRegistration,
+			&i.User.LockdownExempt,
+			&i.User.TotpSecret,
+			&i.User.TotpEnabled,
+			&i.User.InvitationCodeID,
+			&i.User.RenewalRequestedAt,
+			&i.User.DecisionReason,
+			&i.User.DecisionComment,
+			&i.User.ResubmissionAllowed,
+			&i.User.TotpLastStep,
+			&i.LockedUntil,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLogs = `-- name: ListLogs :many
+select id, user_id, created_at, distance, access_token_id from "logs"
+`
+
+func (q *Queries) ListLogs(ctx context.Context) ([]Log, error) {
+	rows, err := q.db.Query(ctx, listLogs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Log
+	for rows.Next() {
+		var i Log
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.Distance,
+			&i.AccessTokenID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLogsByUser = `-- name: ListLogsByUser :many
+select logs.id, logs.user_id, logs.created_at, logs.distance, logs.access_token_id, access_tokens.name as access_token_name from "logs"
+left join "access_tokens" on access_tokens.id = logs.access_token_id
+where logs.user_id = $1 order by logs.created_at desc
 `
 
-func (q *Queries) ListLogs(ctx context.Context) ([]Log, error) {
-	rows, err := q.db.Query(ctx, listLogs)
+type ListLogsByUserRow struct {
+	ID              uuid.UUID
+	UserID          uuid.UUID
+	CreatedAt       pgtype.Timestamp
+	Distance        pgtype.Float8
+	AccessTokenID   pgtype.UUID
+	AccessTokenName pgtype.Text
+}
+
+func (q *Queries) ListLogsByUser(ctx context.Context, userID uuid.UUID) ([]ListLogsByUserRow, error) {
+	rows, err := q.db.Query(ctx, listLogsByUser, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Log
+	var items []ListLogsByUserRow
 	for rows.Next() {
-		var i Log
-		if err := rows.Scan(&i.ID, &i.UserID, &i.CreatedAt); err != nil {
+		var i ListLogsByUserRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.Distance,
+			&i.AccessTokenID,
+			&i.AccessTokenName,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -239,20 +1615,59 @@ func (q *Queries) ListLogs(ctx context.Context) ([]Log, error) {
 	return items, nil
 }
 
-const listLogsByUser = `-- name: ListLogsByUser :many
-select id, user_id, created_at from "logs" where user_id = $1 order by created_at desc
+const listRoles = `-- name: ListRoles :many
+select name, label, permissions, builtin, created_at, updated_at from "roles" order by builtin desc, label
 `
 
-func (q *Queries) ListLogsByUser(ctx context.Context, userID uuid.UUID) ([]Log, error) {
-	rows, err := q.db.Query(ctx, listLogsByUser, userID)
+func (q *Queries) ListRoles(ctx context.Context) ([]Role, error) {
+	rows, err := q.db.Query(ctx, listRoles)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Log
+	var items []Role
 	for rows.Next() {
-		var i Log
-		if err := rows.Scan(&i.ID, &i.UserID, &i.CreatedAt); err != nil {
+		var i Role
+		if err := rows.Scan(
+			&i.Name,
+			&i.Label,
+			&i.Permissions,
+			&i.Builtin,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserIdentitiesByUser = `-- name: ListUserIdentitiesByUser :many
+select id, user_id, issuer, subject, email, created_at, last_used_at from "user_identities" where user_id = $1 order by created_at
+`
+
+func (q *Queries) ListUserIdentitiesByUser(ctx context.Context, userID uuid.UUID) ([]UserIdentity, error) {
+	rows, err := q.db.Query(ctx, listUserIdentitiesByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []UserIdentity
+	for rows.Next() {
+		var i UserIdentity
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Issuer,
+			&i.Subject,
+			&i.Email,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -264,7 +1679,7 @@ func (q *Queries) ListLogsByUser(ctx context.Context, userID uuid.UUID) ([]Log,
 }
 
 const listUsers = `-- name: ListUsers :many
-select id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration from "users"
+select id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step from "users"
 `
 
 func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
@@ -293,6 +1708,15 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 			&i.UpdatedAt,
 			&i.RegistrationState,
 			&i.LastRegistration,
+			&i.LockdownExempt,
+			&i.TotpSecret,
+			&i.TotpEnabled,
+			&i.InvitationCodeID,
+			&i.RenewalRequestedAt,
+			&i.DecisionReason,
+			&i.DecisionComment,
+			&i.ResubmissionAllowed,
+			&i.TotpLastStep,
 		); err != nil {
 			return nil, err
 		}
@@ -305,7 +1729,7 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 }
 
 const listUsersByRole = `-- name: ListUsersByRole :many
-select id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration from "users" where role = $1
+select id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step from "users" where role = $1
 `
 
 func (q *Queries) ListUsersByRole(ctx context.Context, role string) ([]User, error) {
@@ -334,6 +1758,15 @@ func (q *Queries) ListUsersByRole(ctx context.Context, role string) ([]User, err
 			&i.UpdatedAt,
 			&i.RegistrationState,
 			&i.LastRegistration,
+			&i.LockdownExempt,
+			&i.TotpSecret,
+			&i.TotpEnabled,
+			&i.InvitationCodeID,
+			&i.RenewalRequestedAt,
+			&i.DecisionReason,
+			&i.DecisionComment,
+			&i.ResubmissionAllowed,
+			&i.TotpLastStep,
 		); err != nil {
 			return nil, err
 		}
@@ -346,7 +1779,7 @@ func (q *Queries) ListUsersByRole(ctx context.Context, role string) ([]User, err
 }
 
 const listUsersRegisteredSince = `-- name: ListUsersRegisteredSince :many
-select id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration from "users" where last_registration + $1::text::interval >= current_date  and last_registration + $1::text::interval < current_date + interval '1 day'
+select id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step from "users" where last_registration + $1::text::interval >= current_date  and last_registration + $1::text::interval < current_date + interval '1 day'
 `
 
 func (q *Queries) ListUsersRegisteredSince(ctx context.Context, since string) ([]User, error) {
@@ -375,6 +1808,97 @@ func (q *Queries) ListUsersRegisteredSince(ctx context.Context, since string) ([
 			&i.UpdatedAt,
 			&i.RegistrationState,
 			&i.LastRegistration,
+			&i.LockdownExempt,
+			&i.TotpSecret,
+			&i.TotpEnabled,
+			&i.InvitationCodeID,
+			&i.RenewalRequestedAt,
+			&i.DecisionReason,
+			&i.DecisionComment,
+			&i.ResubmissionAllowed,
+			&i.TotpLastStep,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersWithPermission = `-- name: ListUsersWithPermission :many
+select id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step from "users" where "role" in (select name from "roles" where $1::text = any(permissions))
+`
+
+func (q *Queries) ListUsersWithPermission(ctx context.Context, permission string) ([]User, error) {
+	rows, err := q.db.Query(ctx, listUsersWithPermission, permission)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.FullName,
+			&i.Apartment,
+			&i.PwdSalt,
+			&i.PwdHash,
+			&i.PwdIterations,
+			&i.PwdParallelism,
+			&i.PwdMemory,
+			&i.PwdVersion,
+			&i.Role,
+			&i.EmailVerified,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.RegistrationState,
+			&i.LastRegistration,
+			&i.LockdownExempt,
+			&i.TotpSecret,
+			&i.TotpEnabled,
+			&i.InvitationCodeID,
+			&i.RenewalRequestedAt,
+			&i.DecisionReason,
+			&i.DecisionComment,
+			&i.ResubmissionAllowed,
+			&i.TotpLastStep,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebauthnCredentialsByUser = `-- name: ListWebauthnCredentialsByUser :many
+select id, user_id, credential_id, name, credential, created_at, last_used_at from "webauthn_credentials" where user_id = $1 order by created_at
+`
+
+func (q *Queries) ListWebauthnCredentialsByUser(ctx context.Context, userID uuid.UUID) ([]WebauthnCredential, error) {
+	rows, err := q.db.Query(ctx, listWebauthnCredentialsByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebauthnCredential
+	for rows.Next() {
+		var i WebauthnCredential
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CredentialID,
+			&i.Name,
+			&i.Credential,
+			&i.CreatedAt,
+			&i.LastUsedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -386,12 +1910,262 @@ func (q *Queries) ListUsersRegisteredSince(ctx context.Context, since string) ([
 	return items, nil
 }
 
-const registrationAccepted = `-- name: RegistrationAccepted :one
-update "users" set registration_state = 'accepted' where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration
+const lockAccount = `-- name: LockAccount :exec
+update "login_lockouts" set locked_until = $2 where user_id = $1
+`
+
+type LockAccountParams struct {
+	UserID      uuid.UUID
+	LockedUntil pgtype.Timestamp
+}
+
+func (q *Queries) LockAccount(ctx context.Context, arg LockAccountParams) error {
+	_, err := q.db.Exec(ctx, lockAccount, arg.UserID, arg.LockedUntil)
+	return err
+}
+
+const recordInvitationCode = `-- name: RecordInvitationCode :one
+update "users" set invitation_code_id = $2 where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
+`
+
+type RecordInvitationCodeParams struct {
+	ID               uuid.UUID
+	InvitationCodeID pgtype.UUID
+}
+
+// Renewals only record the code, the apartment and role it assigns are for new registrations
+func (q *Queries) RecordInvitationCode(ctx context.Context, arg RecordInvitationCodeParams) (User, error) {
+	row := q.db.QueryRow(ctx, recordInvitationCode, arg.ID, arg.InvitationCodeID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.FullName,
+		&i.Apartment,
+		&i.PwdSalt,
+		&i.PwdHash,
+		&i.PwdIterations,
+		&i.PwdParallelism,
+		&i.PwdMemory,
+		&i.PwdVersion,
+		&i.Role,
+		&i.EmailVerified,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RegistrationState,
+		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const recordLoginFailure = `-- name: RecordLoginFailure :one
+insert into "login_lockouts" (user_id, failed_logins) values ($1, 1)
+on conflict (user_id) do update set
+  failed_logins = case when login_lockouts.last_failure_at < now() - interval '1 day' then 1 else login_lockouts.failed_logins + 1 end,
+  last_failure_at = now()
+returning user_id, failed_logins, last_failure_at, locked_until
+`
+
+func (q *Queries) RecordLoginFailure(ctx context.Context, userID uuid.UUID) (LoginLockout, error) {
+	row := q.db.QueryRow(ctx, recordLoginFailure, userID)
+	var i LoginLockout
+	err := row.Scan(
+		&i.UserID,
+		&i.FailedLogins,
+		&i.LastFailureAt,
+		&i.LockedUntil,
+	)
+	return i, err
+}
+
+const registrationAccepted = `-- name: RegistrationAccepted :one
+update "users" set registration_state = 'accepted', decision_reason = null, decision_comment = null, resubmission_allowed = false
+where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
+`
+
+func (q *Queries) RegistrationAccepted(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRow(ctx, registrationAccepted, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.FullName,
+		&i.Apartment,
+		&i.PwdSalt,
+		&i.PwdHash,
+		&i.PwdIterations,
+		&i.PwdParallelism,
+		&i.PwdMemory,
+		&i.PwdVersion,
+		&i.Role,
+		&i.EmailVerified,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RegistrationState,
+		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const registrationPending = `-- name: RegistrationPending :one
+update "users" set registration_state = 'pending', decision_reason = null, decision_comment = null, resubmission_allowed = false
+where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
+`
+
+func (q *Queries) RegistrationPending(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRow(ctx, registrationPending, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.FullName,
+		&i.Apartment,
+		&i.PwdSalt,
+		&i.PwdHash,
+		&i.PwdIterations,
+		&i.PwdParallelism,
+		&i.PwdMemory,
+		&i.PwdVersion,
+		&i.Role,
+		&i.EmailVerified,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RegistrationState,
+		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const registrationRejected = `-- name: RegistrationRejected :one
+update "users" set registration_state = 'rejected', decision_reason = $2, decision_comment = $3, resubmission_allowed = $4
+where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
+`
+
+type RegistrationRejectedParams struct {
+	ID                  uuid.UUID
+	DecisionReason      pgtype.Text
+	DecisionComment     pgtype.Text
+	ResubmissionAllowed bool
+}
+
+func (q *Queries) RegistrationRejected(ctx context.Context, arg RegistrationRejectedParams) (User, error) {
+	row := q.db.QueryRow(ctx, registrationRejected,
+		arg.ID,
+		arg.DecisionReason,
+		arg.DecisionComment,
+		arg.ResubmissionAllowed,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.FullName,
+		&i.Apartment,
+		&i.PwdSalt,
+		&i.PwdHash,
+		&i.PwdIterations,
+		&i.PwdParallelism,
+		&i.PwdMemory,
+		&i.PwdVersion,
+		&i.Role,
+		&i.EmailVerified,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RegistrationState,
+		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const registrationSuspended = `-- name: RegistrationSuspended :one
+update "users" set registration_state = case when renewal_requested_at is null then 'suspended' else 'renewal_pending' end
+where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
+`
+
+// The users who already sent a renewal request wait for its review instead
+func (q *Queries) RegistrationSuspended(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRow(ctx, registrationSuspended, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.FullName,
+		&i.Apartment,
+		&i.PwdSalt,
+		&i.PwdHash,
+		&i.PwdIterations,
+		&i.PwdParallelism,
+		&i.PwdMemory,
+		&i.PwdVersion,
+		&i.Role,
+		&i.EmailVerified,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RegistrationState,
+		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const registrationSuspendedByAdmin = `-- name: RegistrationSuspendedByAdmin :one
+update "users" set registration_state = 'suspended', decision_reason = $2, decision_comment = $3, renewal_requested_at = null
+where id = $1 and registration_state = 'accepted' returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
 `
 
-func (q *Queries) RegistrationAccepted(ctx context.Context, id uuid.UUID) (User, error) {
-	row := q.db.QueryRow(ctx, registrationAccepted, id)
+type RegistrationSuspendedByAdminParams struct {
+	ID              uuid.UUID
+	DecisionReason  pgtype.Text
+	DecisionComment pgtype.Text
+}
+
+// A pending renewal request is dropped, the user has to renew again with the reason in mind
+func (q *Queries) RegistrationSuspendedByAdmin(ctx context.Context, arg RegistrationSuspendedByAdminParams) (User, error) {
+	row := q.db.QueryRow(ctx, registrationSuspendedByAdmin, arg.ID, arg.DecisionReason, arg.DecisionComment)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -410,16 +2184,52 @@ func (q *Queries) RegistrationAccepted(ctx context.Context, id uuid.UUID) (User,
 		&i.UpdatedAt,
 		&i.RegistrationState,
 		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
 	)
 	return i, err
 }
 
-const registrationPending = `-- name: RegistrationPending :one
-update "users" set registration_state = 'pending' where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration
+const renameWebauthnCredential = `-- name: RenameWebauthnCredential :one
+update "webauthn_credentials" set name = $3 where id = $1 and user_id = $2 returning id, user_id, credential_id, name, credential, created_at, last_used_at
 `
 
-func (q *Queries) RegistrationPending(ctx context.Context, id uuid.UUID) (User, error) {
-	row := q.db.QueryRow(ctx, registrationPending, id)
+type RenameWebauthnCredentialParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	Name   string
+}
+
+func (q *Queries) RenameWebauthnCredential(ctx context.Context, arg RenameWebauthnCredentialParams) (WebauthnCredential, error) {
+	row := q.db.QueryRow(ctx, renameWebauthnCredential, arg.ID, arg.UserID, arg.Name)
+	var i WebauthnCredential
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CredentialID,
+		&i.Name,
+		&i.Credential,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const renewRegistration = `-- name: RenewRegistration :one
+update "users" set registration_state = 'accepted', last_registration = now(), renewal_requested_at = null,
+  decision_reason = null, decision_comment = null
+where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
+`
+
+func (q *Queries) RenewRegistration(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRow(ctx, renewRegistration, id)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -438,16 +2248,39 @@ func (q *Queries) RegistrationPending(ctx context.Context, id uuid.UUID) (User,
 		&i.UpdatedAt,
 		&i.RegistrationState,
 		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
 	)
 	return i, err
 }
 
-const registrationRejected = `-- name: RegistrationRejected :one
-update "users" set registration_state = 'rejected' where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration
+const renewalRejected = `-- name: RenewalRejected :one
+update "users" set registration_state = 'rejected', renewal_requested_at = null,
+  decision_reason = $2, decision_comment = $3, resubmission_allowed = $4
+where id = $1 and renewal_requested_at is not null returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
 `
 
-func (q *Queries) RegistrationRejected(ctx context.Context, id uuid.UUID) (User, error) {
-	row := q.db.QueryRow(ctx, registrationRejected, id)
+type RenewalRejectedParams struct {
+	ID                  uuid.UUID
+	DecisionReason      pgtype.Text
+	DecisionComment     pgtype.Text
+	ResubmissionAllowed bool
+}
+
+func (q *Queries) RenewalRejected(ctx context.Context, arg RenewalRejectedParams) (User, error) {
+	row := q.db.QueryRow(ctx, renewalRejected,
+		arg.ID,
+		arg.DecisionReason,
+		arg.DecisionComment,
+		arg.ResubmissionAllowed,
+	)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -466,16 +2299,28 @@ func (q *Queries) RegistrationRejected(ctx context.Context, id uuid.UUID) (User,
 		&i.UpdatedAt,
 		&i.RegistrationState,
 		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
 	)
 	return i, err
 }
 
-const registrationSuspended = `-- name: RegistrationSuspended :one
-update "users" set registration_state = 'suspended' where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration
+const requestRenewal = `-- name: RequestRenewal :one
+update "users" set renewal_requested_at = now(),
+  registration_state = case when registration_state = 'suspended' then 'renewal_pending' else registration_state end
+where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
 `
 
-func (q *Queries) RegistrationSuspended(ctx context.Context, id uuid.UUID) (User, error) {
-	row := q.db.QueryRow(ctx, registrationSuspended, id)
+// Suspended users wait for the review, the others keep their access until their registration expires
+func (q *Queries) RequestRenewal(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRow(ctx, requestRenewal, id)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -494,16 +2339,42 @@ func (q *Queries) RegistrationSuspended(ctx context.Context, id uuid.UUID) (User
 		&i.UpdatedAt,
 		&i.RegistrationState,
 		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
 	)
 	return i, err
 }
 
-const renewRegistration = `-- name: RenewRegistration :one
-update "users" set registration_state = 'accepted', last_registration = now() where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration
+const resetTOTP = `-- name: ResetTOTP :exec
+update "users" set totp_secret = '', totp_enabled = false, totp_last_step = 0 where id = $1
 `
 
-func (q *Queries) RenewRegistration(ctx context.Context, id uuid.UUID) (User, error) {
-	row := q.db.QueryRow(ctx, renewRegistration, id)
+func (q *Queries) ResetTOTP(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, resetTOTP, id)
+	return err
+}
+
+const resubmitRegistration = `-- name: ResubmitRegistration :one
+update "users" set registration_state = 'pending', full_name = $2, apartment = $3,
+  decision_reason = null, decision_comment = null, resubmission_allowed = false
+where id = $1 and registration_state = 'rejected' and resubmission_allowed returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
+`
+
+type ResubmitRegistrationParams struct {
+	ID        uuid.UUID
+	FullName  string
+	Apartment string
+}
+
+func (q *Queries) ResubmitRegistration(ctx context.Context, arg ResubmitRegistrationParams) (User, error) {
+	row := q.db.QueryRow(ctx, resubmitRegistration, arg.ID, arg.FullName, arg.Apartment)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -522,16 +2393,186 @@ func (q *Queries) RenewRegistration(ctx context.Context, id uuid.UUID) (User, er
 		&i.UpdatedAt,
 		&i.RegistrationState,
 		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const retireJWTKeys = `-- name: RetireJWTKeys :exec
+update "jwt_keys" set retired_at = now(), expires_at = $2 where id != $1 and retired_at is null
+`
+
+type RetireJWTKeysParams struct {
+	ID        string
+	ExpiresAt pgtype.Timestamp
+}
+
+func (q *Queries) RetireJWTKeys(ctx context.Context, arg RetireJWTKeysParams) error {
+	_, err := q.db.Exec(ctx, retireJWTKeys, arg.ID, arg.ExpiresAt)
+	return err
+}
+
+const revokeAccessToken = `-- name: RevokeAccessToken :execrows
+update "access_tokens" set revoked_at = now() where id = $1 and user_id = $2 and revoked_at is null
+`
+
+type RevokeAccessTokenParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) RevokeAccessToken(ctx context.Context, arg RevokeAccessTokenParams) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeAccessToken, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const revokeInvitationCode = `-- name: RevokeInvitationCode :execrows
+update "invitation_codes" set revoked_at = now() where id = $1 and revoked_at is null
+`
+
+func (q *Queries) RevokeInvitationCode(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeInvitationCode, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const revokeOtherSessions = `-- name: RevokeOtherSessions :execrows
+update "sessions" set revoked_at = now() where user_id = $1 and id != $2 and revoked_at is null
+`
+
+type RevokeOtherSessionsParams struct {
+	UserID uuid.UUID
+	ID     uuid.UUID
+}
+
+func (q *Queries) RevokeOtherSessions(ctx context.Context, arg RevokeOtherSessionsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeOtherSessions, arg.UserID, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const revokeSession = `-- name: RevokeSession :execrows
+update "sessions" set revoked_at = now() where id = $1 and user_id = $2 and revoked_at is null
+`
+
+type RevokeSessionParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) RevokeSession(ctx context.Context, arg RevokeSessionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeSession, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const revokeUserSessions = `-- name: RevokeUserSessions :execrows
+update "sessions" set revoked_at = now() where user_id = $1 and revoked_at is null
+`
+
+func (q *Queries) RevokeUserSessions(ctx context.Context, userID uuid.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeUserSessions, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const rotateInvitationCode = `-- name: RotateInvitationCode :one
+update "invitation_codes" set code = $2, rotated_at = now() where id = $1 returning id, code, label, expires_at, max_uses, uses, apartment, role, auto_approve, created_at, revoked_at, rotates, rotated_at
+`
+
+type RotateInvitationCodeParams struct {
+	ID   uuid.UUID
+	Code string
+}
+
+func (q *Queries) RotateInvitationCode(ctx context.Context, arg RotateInvitationCodeParams) (InvitationCode, error) {
+	row := q.db.QueryRow(ctx, rotateInvitationCode, arg.ID, arg.Code)
+	var i InvitationCode
+	err := row.Scan(
+		&i.ID,
+		&i.Code,
+		&i.Label,
+		&i.ExpiresAt,
+		&i.MaxUses,
+		&i.Uses,
+		&i.Apartment,
+		&i.Role,
+		&i.AutoApprove,
+		&i.CreatedAt,
+		&i.RevokedAt,
+		&i.Rotates,
+		&i.RotatedAt,
 	)
 	return i, err
 }
 
-const setRegistrationCode = `-- name: SetRegistrationCode :exec
-insert into "registration_code" (id, code) values (1, $1) on conflict (id) do update set code = $1
+const setTOTPSecret = `-- name: SetTOTPSecret :exec
+update "users" set totp_secret = $2, totp_enabled = false, totp_last_step = 0 where id = $1
+`
+
+type SetTOTPSecretParams struct {
+	ID         uuid.UUID
+	TotpSecret string
+}
+
+func (q *Queries) SetTOTPSecret(ctx context.Context, arg SetTOTPSecretParams) error {
+	_, err := q.db.Exec(ctx, setTOTPSecret, arg.ID, arg.TotpSecret)
+	return err
+}
+
+const touchAccessToken = `-- name: TouchAccessToken :exec
+update "access_tokens" set last_used_at = now() where id = $1
+`
+
+func (q *Queries) TouchAccessToken(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, touchAccessToken, id)
+	return err
+}
+
+const touchSession = `-- name: TouchSession :exec
+update "sessions" set last_seen_at = now(), ip = $2 where id = $1
+`
+
+type TouchSessionParams struct {
+	ID uuid.UUID
+	Ip string
+}
+
+func (q *Queries) TouchSession(ctx context.Context, arg TouchSessionParams) error {
+	_, err := q.db.Exec(ctx, touchSession, arg.ID, arg.Ip)
+	return err
+}
+
+const updateJWTKeySecret = `-- name: UpdateJWTKeySecret :exec
+update "jwt_keys" set secret = $2 where id = $1
 `
 
-func (q *Queries) SetRegistrationCode(ctx context.Context, code string) error {
-	_, err := q.db.Exec(ctx, setRegistrationCode, code)
+type UpdateJWTKeySecretParams struct {
+	ID     string
+	Secret []byte
+}
+
+func (q *Queries) UpdateJWTKeySecret(ctx context.Context, arg UpdateJWTKeySecretParams) error {
+	_, err := q.db.Exec(ctx, updateJWTKeySecret, arg.ID, arg.Secret)
 	return err
 }
 
@@ -562,18 +2603,145 @@ func (q *Queries) UpdatePassword(ctx context.Context, arg UpdatePasswordParams)
 	return err
 }
 
+const updateRole = `-- name: UpdateRole :one
+update "roles" set label = $2, permissions = $3 where name = $1 and not builtin returning name, label, permissions, builtin, created_at, updated_at
+`
+
+type UpdateRoleParams struct {
+	Name        string
+	Label       string
+	Permissions []string
+}
+
+func (q *Queries) UpdateRole(ctx context.Context, arg UpdateRoleParams) (Role, error) {
+	row := q.db.QueryRow(ctx, updateRole, arg.Name, arg.Label, arg.Permissions)
+	var i Role
+	err := row.Scan(
+		&i.Name,
+		&i.Label,
+		&i.Permissions,
+		&i.Builtin,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const updateUserEmail = `-- name: UpdateUserEmail :one
+update "users" set email = $2, email_verified = true where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
+`
+
+type UpdateUserEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) UpdateUserEmail(ctx context.Context, arg UpdateUserEmailParams) (User, error) {
+	row := q.db.QueryRow(ctx, updateUserEmail, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.FullName,
+		&i.Apartment,
+		&i.PwdSalt,
+		&i.PwdHash,
+		&i.PwdIterations,
+		&i.PwdParallelism,
+		&i.PwdMemory,
+		&i.PwdVersion,
+		&i.Role,
+		&i.EmailVerified,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RegistrationState,
+		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const updateUserFullName = `-- name: UpdateUserFullName :one
+update "users" set full_name = $2 where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
+`
+
+type UpdateUserFullNameParams struct {
+	ID       uuid.UUID
+	FullName string
+}
+
+func (q *Queries) UpdateUserFullName(ctx context.Context, arg UpdateUserFullNameParams) (User, error) {
+	row := q.db.QueryRow(ctx, updateUserFullName, arg.ID, arg.FullName)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.FullName,
+		&i.Apartment,
+		&i.PwdSalt,
+		&i.PwdHash,
+		&i.PwdIterations,
+		&i.PwdParallelism,
+		&i.PwdMemory,
+		&i.PwdVersion,
+		&i.Role,
+		&i.EmailVerified,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RegistrationState,
+		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const updateUserIdentityUsage = `-- name: UpdateUserIdentityUsage :exec
+update "user_identities" set last_used_at = now(), email = $3 where issuer = $1 and subject = $2
+`
+
+type UpdateUserIdentityUsageParams struct {
+	Issuer  string
+	Subject string
+	Email   string
+}
+
+func (q *Queries) UpdateUserIdentityUsage(ctx context.Context, arg UpdateUserIdentityUsageParams) error {
+	_, err := q.db.Exec(ctx, updateUserIdentityUsage, arg.Issuer, arg.Subject, arg.Email)
+	return err
+}
+
 const updateUserInfo = `-- name: UpdateUserInfo :one
-update "users" set "role" = $2, full_name = $3, apartment = $4, email = $5 where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration
+update "users" set "role" = $2, full_name = $3, apartment = $4, email = $5, lockdown_exempt = $6,
+  email_verified = email_verified and email = $5
+where id = $1 returning id, email, full_name, apartment, pwd_salt, pwd_hash, pwd_iterations, pwd_parallelism, pwd_memory, pwd_version, role, email_verified, created_at, updated_at, registration_state, last_registration, lockdown_exempt, totp_secret, totp_enabled, invitation_code_id, renewal_requested_at, decision_reason, decision_comment, resubmission_allowed, totp_last_step
 `
 
 type UpdateUserInfoParams struct {
-	ID        uuid.UUID
-	Role      string
-	FullName  string
-	Apartment string
-	Email     string
+	ID             uuid.UUID
+	Role           string
+	FullName       string
+	Apartment      string
+	Email          string
+	LockdownExempt bool
 }
 
+// A new email has to be verified again
 func (q *Queries) UpdateUserInfo(ctx context.Context, arg UpdateUserInfoParams) (User, error) {
 	row := q.db.QueryRow(ctx, updateUserInfo,
 		arg.ID,
@@ -581,6 +2749,7 @@ func (q *Queries) UpdateUserInfo(ctx context.Context, arg UpdateUserInfoParams)
 		arg.FullName,
 		arg.Apartment,
 		arg.Email,
+		arg.LockdownExempt,
 	)
 	var i User
 	err := row.Scan(
@@ -600,6 +2769,110 @@ func (q *Queries) UpdateUserInfo(ctx context.Context, arg UpdateUserInfoParams)
 		&i.UpdatedAt,
 		&i.RegistrationState,
 		&i.LastRegistration,
+		&i.LockdownExempt,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.InvitationCodeID,
+		&i.RenewalRequestedAt,
+		&i.DecisionReason,
+		&i.DecisionComment,
+		&i.ResubmissionAllowed,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const updateWebauthnCredentialUsage = `-- name: UpdateWebauthnCredentialUsage :exec
+update "webauthn_credentials" set credential = $2, last_used_at = now() where credential_id = $1
+`
+
+type UpdateWebauthnCredentialUsageParams struct {
+	CredentialID []byte
+	Credential   []byte
+}
+
+func (q *Queries) UpdateWebauthnCredentialUsage(ctx context.Context, arg UpdateWebauthnCredentialUsageParams) error {
+	_, err := q.db.Exec(ctx, updateWebauthnCredentialUsage, arg.CredentialID, arg.Credential)
+	return err
+}
+
+const useInvitationCode = `-- name: UseInvitationCode :one
+update "invitation_codes" set uses = uses + 1
+where code = $1 and revoked_at is null
+  and (expires_at is null or expires_at > now())
+  and (max_uses is null or uses < max_uses)
+returning id, code, label, expires_at, max_uses, uses, apartment, role, auto_approve, created_at, revoked_at, rotates, rotated_at
+`
+
+func (q *Queries) UseInvitationCode(ctx context.Context, code string) (InvitationCode, error) {
+	row := q.db.QueryRow(ctx, useInvitationCode, code)
+	var i InvitationCode
+	err := row.Scan(
+		&i.ID,
+		&i.Code,
+		&i.Label,
+		&i.ExpiresAt,
+		&i.MaxUses,
+		&i.Uses,
+		&i.Apartment,
+		&i.Role,
+		&i.AutoApprove,
+		&i.CreatedAt,
+		&i.RevokedAt,
+		&i.Rotates,
+		&i.RotatedAt,
 	)
 	return i, err
 }
+
+const useTOTPRecoveryCode = `-- name: UseTOTPRecoveryCode :execrows
+update "totp_recovery_codes" set used_at = now() where user_id = $1 and code_hash = $2 and used_at is null
+`
+
+type UseTOTPRecoveryCodeParams struct {
+	UserID   uuid.UUID
+	CodeHash string
+}
+
+func (q *Queries) UseTOTPRecoveryCode(ctx context.Context, arg UseTOTPRecoveryCodeParams) (int64, error) {
+	result, err := q.db.Exec(ctx, useTOTPRecoveryCode, arg.UserID, arg.CodeHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const useTOTPStep = `-- name: UseTOTPStep :execrows
+update "users" set totp_last_step = $2 where id = $1 and totp_last_step < $2
+`
+
+type UseTOTPStepParams struct {
+	ID           uuid.UUID
+	TotpLastStep int64
+}
+
+// The code of a time step is accepted once, the next codes must be of a later step
+func (q *Queries) UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error) {
+	result, err := q.db.Exec(ctx, useTOTPStep, arg.ID, arg.TotpLastStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const useToken = `-- name: UseToken :execrows
+insert into "used_token" (user_id, token) values ($1, $2) on conflict (token) do nothing
+`
+
+type UseTokenParams struct {
+	UserID uuid.UUID
+	Token  string
+}
+
+func (q *Queries) UseToken(ctx context.Context, arg UseTokenParams) (int64, error) {
+	result, err := q.db.Exec(ctx, useToken, arg.UserID, arg.Token)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
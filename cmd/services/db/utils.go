@@ -3,12 +3,14 @@ package db
 import (
 	"context"
 	"errors"
+	"time"
 
 	"woody-wood-portail/cmd/config"
 	"woody-wood-portail/cmd/ctx"
 	"woody-wood-portail/cmd/logger"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
 )
@@ -124,3 +126,43 @@ func DeleteOldLogs() {
 func QGlobal() *Queries {
 	return New(pool)
 }
+
+// DeleteOldUsedTokens forgets used tokens which are expired anyway
+func DeleteOldUsedTokens() {
+	maxTTL := max(config.Config.Http.JWT.EmailVerificationTTL, config.Config.Http.JWT.ResetPasswordTTL, config.Config.Http.JWT.MagicLinkTTL, config.Config.Http.JWT.EmailChangeTTL)
+
+	queries := New(pool)
+	nbDeletedTokens, err := queries.DeleteOldUsedTokens(context.Background(), pgtype.Timestamp{
+		Time:  time.Now().Add(-maxTTL),
+		Valid: true,
+	})
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("failed to delete old used tokens")
+		return
+	}
+
+	logger.Log.Info().Int64("deleted", nbDeletedTokens).Msg("Old used tokens deleted")
+}
+
+// DeleteExpiredSessions removes the revoked sessions and the ones whose token has expired
+func DeleteExpiredSessions() {
+	queries := New(pool)
+	nbDeletedSessions, err := queries.DeleteExpiredSessions(context.Background(), int32(config.Config.Http.JWT.MaxAge))
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("failed to delete expired sessions")
+		return
+	}
+
+	logger.Log.Info().Int64("deleted", nbDeletedSessions).Msg("Expired sessions deleted")
+}
+
+func DeleteOldAuthAttempts() {
+	queries := New(pool)
+	nbDeletedAttempts, err := queries.DeleteOldAuthAttempts(context.Background())
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("failed to delete old authentication attempts")
+		return
+	}
+
+	logger.Log.Info().Int64("deleted", nbDeletedAttempts).Msg("Old authentication attempts deleted")
+}
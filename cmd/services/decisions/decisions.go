@@ -0,0 +1,61 @@
+// Package decisions lists the reasons an admin can give when rejecting or suspending a registration
+package decisions
+
+import (
+	"woody-wood-portail/cmd/services/db"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Other is the reason to pick when none of the predefined ones applies, a comment must then explain the decision
+const Other = "other"
+
+type Reason struct {
+	Code  string
+	Label string
+}
+
+// Reasons are stored by code, changing a label also changes the message shown for the past decisions
+var Reasons = []Reason{
+	{Code: "unreadable_proof", Label: "Le justificatif de domicile est illisible ou incomplet"},
+	{Code: "outdated_proof", Label: "Le justificatif de domicile n'est pas assez récent"},
+	{Code: "name_mismatch", Label: "Le nom indiqué ne correspond pas au justificatif de domicile"},
+	{Code: "wrong_apartment", Label: "Le numéro d'appartement ne correspond pas au justificatif de domicile"},
+	{Code: "not_resident", Label: "Vous ne faites pas partie des résidents de la résidence"},
+	{Code: "moved_out", Label: "Vous n'habitez plus la résidence"},
+	{Code: "misuse", Label: "Utilisation non conforme du portail"},
+	{Code: Other, Label: "Autre motif"},
+}
+
+// Valid returns whether the code is one of the predefined reasons
+func Valid(code string) bool {
+	for _, reason := range Reasons {
+		if reason.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Label returns the text of a reason, the unknown codes have no label
+func Label(code string) string {
+	if code == Other {
+		return ""
+	}
+	for _, reason := range Reasons {
+		if reason.Code == code {
+			return reason.Label
+		}
+	}
+	return ""
+}
+
+// HasReason returns whether the last decision about the user registration came with an explanation
+func HasReason(user db.User) bool {
+	return Label(user.DecisionReason.String) != "" || user.DecisionComment.String != ""
+}
+
+// Params returns the reason and the optional comment as stored in the database
+func Params(code string, comment string) (pgtype.Text, pgtype.Text) {
+	return pgtype.Text{String: code, Valid: code != ""}, pgtype.Text{String: comment, Valid: comment != ""}
+}
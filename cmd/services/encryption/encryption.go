@@ -0,0 +1,149 @@
+// Package encryption encrypts data with AES-256-GCM keys read from the configuration. Each service has its own keys,
+// the first one encrypts new data and the others are only kept to decrypt the data until it is encrypted again.
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+const KeyLen = 32
+
+type Key struct {
+	ID   string
+	AEAD cipher.AEAD
+}
+
+// Keys decrypt the data, the first one encrypts new data
+type Keys []Key
+
+// ParseKeys parses comma separated base64 encoded 32 bytes keys
+func ParseKeys(encodedKeys string) (Keys, error) {
+	var keys Keys
+	for _, encoded := range strings.Split(encodedKeys, ",") {
+		encoded = strings.TrimSpace(encoded)
+		if encoded == "" {
+			continue
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 key: %w", err)
+		}
+		if len(raw) != KeyLen {
+			return nil, fmt.Errorf("keys must be %d bytes long, got %d", KeyLen, len(raw))
+		}
+
+		aead, err := NewAEAD(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		// The id is derived from the key so that it does not need to be configured
+		sum := sha256.Sum256(raw)
+		keys = append(keys, Key{ID: hex.EncodeToString(sum[:4]), AEAD: aead})
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no key configured")
+	}
+	return keys, nil
+}
+
+func NewAEAD(k []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AES key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Current returns the key encrypting new data
+func (keys Keys) Current() Key {
+	return keys[0]
+}
+
+func (keys Keys) Find(id string) (Key, error) {
+	for _, k := range keys {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+	return Key{}, ErrUnknownKey
+}
+
+// Seal encrypts the plaintext, prefixed by a random nonce
+func Seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func Open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed data too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
+
+// Small secrets, like the JWT signing keys, are sealed directly with the configured keys.
+// Layout: secretMagic | key id length (1 byte) | key id | sealed secret
+const secretMagic = "WWSK1"
+
+// EncryptSecret seals a secret with the current key, the additional data binds it to its owner
+func (keys Keys) EncryptSecret(secret, additionalData []byte) ([]byte, error) {
+	current := keys.Current()
+
+	sealed, err := Seal(current.AEAD, secret, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(secretMagic)
+	buf.WriteByte(byte(len(current.ID)))
+	buf.WriteString(current.ID)
+	buf.Write(sealed)
+	return buf.Bytes(), nil
+}
+
+// DecryptSecret opens a secret sealed by EncryptSecret. The returned bool is true when the secret was sealed with a
+// previous key and should be encrypted again.
+func (keys Keys) DecryptSecret(data, additionalData []byte) ([]byte, bool, error) {
+	if !IsEncryptedSecret(data) {
+		return nil, false, errors.New("not an encrypted secret")
+	}
+	data = data[len(secretMagic):]
+
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return nil, false, errors.New("truncated encrypted secret")
+	}
+	keyID := string(data[1 : 1+data[0]])
+	k, err := keys.Find(keyID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	secret, err := Open(k.AEAD, data[1+data[0]:], additionalData)
+	if err != nil {
+		return nil, false, err
+	}
+	return secret, keyID != keys.Current().ID, nil
+}
+
+// IsEncryptedSecret returns false for the secrets stored in clear before their encryption
+func IsEncryptedSecret(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(secretMagic))
+}
@@ -0,0 +1,78 @@
+package geofence
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"woody-wood-portail/cmd/config"
+)
+
+var (
+	ErrPositionMissing    = errors.New("position missing")
+	ErrPositionInaccurate = errors.New("position is not accurate enough")
+	ErrTooFar             = errors.New("position is too far from the gate")
+)
+
+const earthRadius = 6_371_000 // meters
+
+// Positions less accurate than this fraction of the radius can't tell if the user is near the gate
+const maxAccuracyRatio = 0.5
+
+type Position struct {
+	Latitude  float64
+	Longitude float64
+	// Accuracy of the position in meters, as reported by the browser Geolocation API
+	Accuracy float64
+}
+
+// Required returns true if a user with the given role has to be near the gate to open it.
+func Required(role string) bool {
+	if !config.Config.Gate.Geofence.Enabled {
+		return false
+	}
+
+	for _, exemptRole := range strings.Split(config.Config.Gate.Geofence.ExemptRoles, ",") {
+		if strings.TrimSpace(exemptRole) == role {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check verifies that the given position is within the configured radius around the gate.
+// The accuracy is not added to the radius, it would accept positions far away from the gate. Instead, positions whose
+// accuracy is too large compared to the radius are refused.
+// The distance to the gate is returned even if the check fails, to allow logging it.
+func Check(position Position) (float64, error) {
+	// The Geolocation API always reports a strictly positive accuracy
+	if position.Accuracy <= 0 {
+		return 0, ErrPositionMissing
+	}
+
+	distance := Distance(position.Latitude, position.Longitude, config.Config.Gate.Geofence.Latitude, config.Config.Gate.Geofence.Longitude)
+
+	maxAccuracy := min(config.Config.Gate.Geofence.MaxAccuracy, config.Config.Gate.Geofence.Radius*maxAccuracyRatio)
+	if position.Accuracy > maxAccuracy {
+		return distance, ErrPositionInaccurate
+	}
+
+	if distance > config.Config.Gate.Geofence.Radius {
+		return distance, ErrTooFar
+	}
+
+	return distance, nil
+}
+
+// Distance computes the great-circle distance in meters between two coordinates, using the haversine formula.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	deltaPhi := (lat2 - lat1) * math.Pi / 180
+	deltaLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(deltaPhi/2)*math.Sin(deltaPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(deltaLambda/2)*math.Sin(deltaLambda/2)
+
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
@@ -0,0 +1,66 @@
+// Package invitations draws the codes residents enter to register
+package invitations
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"woody-wood-portail/cmd/services/db"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Codes are short to be typed from the poster, a new one is drawn when it is already used
+const maxAttempts = 10
+
+var ErrNoCodeAvailable = errors.New("no unused invitation code found")
+
+// NewCode returns a random 6 digits code, not starting with 0
+func NewCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(900_000))
+	if err != nil {
+		return "", fmt.Errorf("failed to draw invitation code: %w", err)
+	}
+	return fmt.Sprintf("%06d", n.Int64()+100_000), nil
+}
+
+// Create saves a new invitation code, the code of the params is replaced by an unused random one
+func Create(ctx context.Context, q *db.Queries, params db.CreateInvitationCodeParams) (db.InvitationCode, error) {
+	for range maxAttempts {
+		code, err := NewCode()
+		if err != nil {
+			return db.InvitationCode{}, err
+		}
+		params.Code = code
+
+		invitation, err := q.CreateInvitationCode(ctx, params)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		return invitation, err
+	}
+	return db.InvitationCode{}, ErrNoCodeAvailable
+}
+
+// Rotate replaces the code of an invitation by an unused random one. It must not run in a transaction, a code
+// already used fails the statement.
+func Rotate(ctx context.Context, q *db.Queries, id uuid.UUID) (db.InvitationCode, error) {
+	for range maxAttempts {
+		code, err := NewCode()
+		if err != nil {
+			return db.InvitationCode{}, err
+		}
+
+		invitation, err := q.RotateInvitationCode(ctx, db.RotateInvitationCodeParams{ID: id, Code: code})
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			continue
+		}
+		return invitation, err
+	}
+	return db.InvitationCode{}, ErrNoCodeAvailable
+}
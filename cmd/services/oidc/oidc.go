@@ -0,0 +1,267 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"woody-wood-portail/cmd/config"
+	"woody-wood-portail/cmd/services/cookies"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrFlowNotFound    = errors.New("oidc login flow not found")
+	ErrFlowExpired     = errors.New("oidc login flow expired")
+	ErrInvalidState    = errors.New("oidc state mismatch")
+	ErrMissingIDToken  = errors.New("missing id_token in token response")
+	ErrInvalidNonce    = errors.New("oidc nonce mismatch")
+	ErrMissingSubject  = errors.New("missing subject in id_token")
+	ErrMissingEmail    = errors.New("missing email in id_token")
+	ErrProviderFailure = errors.New("oidc provider unavailable")
+
+	flows         = store[flow]{entries: map[string]entry[flow]{}}
+	registrations = store[Identity]{entries: map[string]entry[Identity]{}}
+
+	provider struct {
+		sync.Mutex
+		provider *oidc.Provider
+		verifier *oidc.IDTokenVerifier
+	}
+)
+
+const (
+	stateCookieName        = "oidc_state"
+	registrationCookieName = "oidc_registration"
+)
+
+// Identity is the account of a user at the OpenID Connect provider
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type flow struct {
+	Nonce    string
+	Verifier string
+	Redirect string
+}
+
+// Enabled returns true if an OpenID Connect provider is configured
+func Enabled() bool {
+	return config.Config.Http.OIDC.Issuer != ""
+}
+
+// AuthCodeURL starts a new authorization code flow with PKCE, and returns the URL of the provider login page.
+// The redirect is the local URL the user will be sent to after login.
+func AuthCodeURL(c echo.Context, redirect string) (string, error) {
+	oauth2Config, _, err := clients(c)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	f := flow{Nonce: nonce, Verifier: oauth2.GenerateVerifier(), Redirect: redirect}
+	flows.save(state, f)
+	setCookie(c, stateCookieName, state)
+
+	return oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(f.Verifier)), nil
+}
+
+// Exchange ends the flow started with AuthCodeURL, using the authorization code given by the provider to the callback.
+// It returns the identity of the user and the local URL to redirect to.
+func Exchange(c echo.Context) (Identity, string, error) {
+	cookie, err := c.Cookie(stateCookieName)
+	clearCookie(c, stateCookieName)
+	if err != nil {
+		return Identity{}, "", ErrFlowNotFound
+	}
+	if cookie.Value != c.QueryParam("state") {
+		return Identity{}, "", ErrInvalidState
+	}
+
+	f, err := flows.pop(cookie.Value)
+	if err != nil {
+		return Identity{}, "", err
+	}
+
+	oauth2Config, verifier, err := clients(c)
+	if err != nil {
+		return Identity{}, "", err
+	}
+
+	token, err := oauth2Config.Exchange(c.Request().Context(), c.QueryParam("code"), oauth2.VerifierOption(f.Verifier))
+	if err != nil {
+		return Identity{}, "", fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, "", ErrMissingIDToken
+	}
+
+	idToken, err := verifier.Verify(c.Request().Context(), rawIDToken)
+	if err != nil {
+		return Identity{}, "", fmt.Errorf("invalid id_token: %w", err)
+	}
+	if idToken.Nonce != f.Nonce {
+		return Identity{}, "", ErrInvalidNonce
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, "", fmt.Errorf("invalid id_token claims: %w", err)
+	}
+	if idToken.Subject == "" {
+		return Identity{}, "", ErrMissingSubject
+	}
+	if claims.Email == "" {
+		return Identity{}, "", ErrMissingEmail
+	}
+
+	return Identity{
+		Issuer:        idToken.Issuer,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, f.Redirect, nil
+}
+
+// SaveRegistration keeps the identity of an unknown user while they fill the registration form
+func SaveRegistration(c echo.Context, identity Identity) error {
+	id, err := randomString()
+	if err != nil {
+		return err
+	}
+
+	registrations.save(id, identity)
+	setCookie(c, registrationCookieName, id)
+	return nil
+}
+
+// GetRegistration returns the identity saved by SaveRegistration for the current browser
+func GetRegistration(c echo.Context) (Identity, error) {
+	cookie, err := c.Cookie(registrationCookieName)
+	if err != nil {
+		return Identity{}, ErrFlowNotFound
+	}
+	return registrations.get(cookie.Value)
+}
+
+// EndRegistration forgets the identity saved by SaveRegistration, once the account is created
+func EndRegistration(c echo.Context) {
+	if cookie, err := c.Cookie(registrationCookieName); err == nil {
+		_, _ = registrations.pop(cookie.Value)
+	}
+	clearCookie(c, registrationCookieName)
+}
+
+// clients discovers the provider configuration on first use, so that the application starts even if the provider is down
+func clients(c echo.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	provider.Lock()
+	defer provider.Unlock()
+
+	if provider.provider == nil {
+		p, err := oidc.NewProvider(c.Request().Context(), config.Config.Http.OIDC.Issuer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrProviderFailure, err)
+		}
+		provider.provider = p
+		provider.verifier = p.Verifier(&oidc.Config{ClientID: config.Config.Http.OIDC.ClientID})
+	}
+
+	return &oauth2.Config{
+		ClientID:     config.Config.Http.OIDC.ClientID,
+		ClientSecret: config.Config.Http.OIDC.ClientSecret,
+		Endpoint:     provider.provider.Endpoint(),
+		RedirectURL:  config.Config.Http.BaseURL + "/login/oidc/callback",
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}, provider.verifier, nil
+}
+
+func setCookie(c echo.Context, name string, value string) {
+	c.SetCookie(cookies.New(name, value, int(config.Config.Http.OIDC.Timeout.Seconds())))
+}
+
+func clearCookie(c echo.Context, name string) {
+	c.SetCookie(cookies.Clear(name))
+}
+
+func randomString() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+type entry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// store keeps short lived data server side, the browser only holds the key in a cookie
+type store[T any] struct {
+	sync.Mutex
+	entries map[string]entry[T]
+}
+
+func (s *store[T]) save(key string, value T) {
+	s.Lock()
+	defer s.Unlock()
+
+	for k, e := range s.entries {
+		if e.expires.Before(time.Now()) {
+			delete(s.entries, k)
+		}
+	}
+	s.entries[key] = entry[T]{value: value, expires: time.Now().Add(config.Config.Http.OIDC.Timeout)}
+}
+
+func (s *store[T]) get(key string) (T, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		var zero T
+		return zero, ErrFlowNotFound
+	}
+	if e.expires.Before(time.Now()) {
+		delete(s.entries, key)
+		var zero T
+		return zero, ErrFlowExpired
+	}
+	return e.value, nil
+}
+
+func (s *store[T]) pop(key string) (T, error) {
+	value, err := s.get(key)
+
+	s.Lock()
+	delete(s.entries, key)
+	s.Unlock()
+
+	return value, err
+}
@@ -0,0 +1,158 @@
+package oidc
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"woody-wood-portail/cmd/config"
+	"woody-wood-portail/cmd/services/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// The provider is discovered once and cached, all the tests share the same issuer
+var issuer *oidctest.Issuer
+
+func TestMain(m *testing.M) {
+	issuer = oidctest.NewIssuer("gate", "gate-secret")
+
+	config.Config.Http.OIDC.Issuer = issuer.URL
+	config.Config.Http.OIDC.ClientID = issuer.ClientID
+	config.Config.Http.OIDC.ClientSecret = issuer.ClientSecret
+
+	code := m.Run()
+	issuer.Close()
+	os.Exit(code)
+}
+
+// startLogin calls AuthCodeURL like the /login/oidc handler, and returns the authorization URL with the state cookie
+func startLogin(t *testing.T, redirect string) (string, *http.Cookie) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/login/oidc", nil), rec)
+	authURL, err := AuthCodeURL(c, redirect)
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == stateCookieName {
+			return authURL, cookie
+		}
+	}
+	t.Fatal("no state cookie set")
+	return "", nil
+}
+
+// callback calls Exchange like the /login/oidc/callback handler
+func callback(stateCookie *http.Cookie, state string, code string) (Identity, string, error) {
+	query := url.Values{"state": {state}, "code": {code}}
+	req := httptest.NewRequest(http.MethodGet, "/login/oidc/callback?"+query.Encode(), nil)
+	if stateCookie != nil {
+		req.AddCookie(stateCookie)
+	}
+	return Exchange(echo.New().NewContext(req, httptest.NewRecorder()))
+}
+
+func TestLoginRoundTrip(t *testing.T) {
+	authURL, cookie := startLogin(t, "/user/gate")
+	state, code := issuer.Authorize(t, authURL, jwt.MapClaims{
+		"sub":            "subject-1",
+		"email":          "resident@example.com",
+		"email_verified": true,
+		"name":           "Resident",
+	})
+	if cookie.Value != state {
+		t.Fatalf("state cookie %q does not match the state sent to the provider %q", cookie.Value, state)
+	}
+
+	identity, redirect, err := callback(cookie, state, code)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+
+	expected := Identity{Issuer: issuer.URL, Subject: "subject-1", Email: "resident@example.com", EmailVerified: true, Name: "Resident"}
+	if identity != expected {
+		t.Errorf("got identity %+v, expected %+v", identity, expected)
+	}
+	if redirect != "/user/gate" {
+		t.Errorf("got redirect %q, expected the one given to AuthCodeURL", redirect)
+	}
+}
+
+func TestUnverifiedEmail(t *testing.T) {
+	for name, claims := range map[string]jwt.MapClaims{
+		"unverified": {"email": "resident@example.com", "email_verified": false},
+		"missing":    {"email": "resident@example.com"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			authURL, cookie := startLogin(t, "")
+			state, code := issuer.Authorize(t, authURL, claims)
+
+			identity, _, err := callback(cookie, state, code)
+			if err != nil {
+				t.Fatalf("Exchange failed: %v", err)
+			}
+			if identity.EmailVerified {
+				t.Error("the email is reported as verified, it would allow linking an existing account")
+			}
+		})
+	}
+}
+
+func TestStateMismatch(t *testing.T) {
+	authURL, cookie := startLogin(t, "")
+	_, code := issuer.Authorize(t, authURL, jwt.MapClaims{"email": "resident@example.com"})
+
+	if _, _, err := callback(cookie, "forged", code); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("got %v, expected ErrInvalidState", err)
+	}
+}
+
+func TestMissingStateCookie(t *testing.T) {
+	authURL, _ := startLogin(t, "")
+	state, code := issuer.Authorize(t, authURL, jwt.MapClaims{"email": "resident@example.com"})
+
+	if _, _, err := callback(nil, state, code); !errors.Is(err, ErrFlowNotFound) {
+		t.Errorf("got %v, expected ErrFlowNotFound", err)
+	}
+}
+
+func TestFlowUsedOnce(t *testing.T) {
+	authURL, cookie := startLogin(t, "")
+	state, code := issuer.Authorize(t, authURL, jwt.MapClaims{"email": "resident@example.com"})
+
+	if _, _, err := callback(cookie, state, code); err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if _, _, err := callback(cookie, state, code); !errors.Is(err, ErrFlowNotFound) {
+		t.Errorf("got %v, expected ErrFlowNotFound on replay", err)
+	}
+}
+
+func TestVerifierOfAnotherFlow(t *testing.T) {
+	authURL, _ := startLogin(t, "")
+	_, code := issuer.Authorize(t, authURL, jwt.MapClaims{"email": "resident@example.com"})
+
+	// The code was issued for the challenge of the first flow, the verifier of the second one must be refused
+	otherURL, otherCookie := startLogin(t, "")
+	otherState, _ := issuer.Authorize(t, otherURL, jwt.MapClaims{"email": "resident@example.com"})
+
+	if _, _, err := callback(otherCookie, otherState, code); err == nil {
+		t.Error("the code was exchanged with the verifier of another flow")
+	}
+}
+
+func TestNonceMismatch(t *testing.T) {
+	authURL, cookie := startLogin(t, "")
+	state, code := issuer.Authorize(t, authURL, jwt.MapClaims{"email": "resident@example.com", "nonce": "replayed"})
+
+	if _, _, err := callback(cookie, state, code); !errors.Is(err, ErrInvalidNonce) {
+		t.Errorf("got %v, expected ErrInvalidNonce", err)
+	}
+}
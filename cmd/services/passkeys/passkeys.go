@@ -0,0 +1,159 @@
+package passkeys
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+	"woody-wood-portail/cmd/config"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/cookies"
+	"woody-wood-portail/cmd/services/db"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	ErrSessionNotFound = errors.New("webauthn session not found")
+	ErrSessionExpired  = errors.New("webauthn session expired")
+
+	WebAuthn *webauthn.WebAuthn
+
+	sessions = sessionStore{sessions: map[string]webauthn.SessionData{}}
+)
+
+const sessionCookieName = "webauthn_session"
+
+// User wraps a database user with its registered credentials to implement webauthn.User
+type User struct {
+	db.User
+	Credentials []webauthn.Credential
+}
+
+func (u *User) WebAuthnID() []byte {
+	return u.ID[:]
+}
+
+func (u *User) WebAuthnName() string {
+	return u.Email
+}
+
+func (u *User) WebAuthnDisplayName() string {
+	return u.FullName
+}
+
+func (u *User) WebAuthnCredentials() []webauthn.Credential {
+	return u.Credentials
+}
+
+func (u *User) WebAuthnIcon() string {
+	return ""
+}
+
+// NewUser decodes the stored credentials of a user
+func NewUser(user db.User, credentials []db.WebauthnCredential) (*User, error) {
+	webauthnUser := &User{User: user, Credentials: make([]webauthn.Credential, 0, len(credentials))}
+	for _, stored := range credentials {
+		credential, err := DecodeCredential(stored)
+		if err != nil {
+			return nil, err
+		}
+		webauthnUser.Credentials = append(webauthnUser.Credentials, credential)
+	}
+	return webauthnUser, nil
+}
+
+func DecodeCredential(stored db.WebauthnCredential) (webauthn.Credential, error) {
+	var credential webauthn.Credential
+	if err := json.Unmarshal(stored.Credential, &credential); err != nil {
+		return credential, fmt.Errorf("failed to decode webauthn credential %s: %w", stored.ID, err)
+	}
+	return credential, nil
+}
+
+func EncodeCredential(credential *webauthn.Credential) ([]byte, error) {
+	return json.Marshal(credential)
+}
+
+type sessionStore struct {
+	sync.Mutex
+	sessions map[string]webauthn.SessionData
+}
+
+// SaveSession keeps the ceremony session data server side, and gives its ID to the browser in a short lived cookie
+func SaveSession(c echo.Context, session *webauthn.SessionData) error {
+	rawID := make([]byte, 32)
+	if _, err := rand.Read(rawID); err != nil {
+		return fmt.Errorf("failed to generate webauthn session id: %w", err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(rawID)
+
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	for key, s := range sessions.sessions {
+		if !s.Expires.IsZero() && s.Expires.Before(time.Now()) {
+			delete(sessions.sessions, key)
+		}
+	}
+	sessions.sessions[id] = *session
+
+	c.SetCookie(cookies.New(sessionCookieName, id, int(config.Config.Http.WebAuthn.Timeout.Seconds())))
+	return nil
+}
+
+// PopSession returns the ceremony session data of the current browser. A session can only be used once.
+func PopSession(c echo.Context) (webauthn.SessionData, error) {
+	c.SetCookie(cookies.Clear(sessionCookieName))
+
+	cookie, err := c.Cookie(sessionCookieName)
+	if err != nil {
+		return webauthn.SessionData{}, ErrSessionNotFound
+	}
+
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	session, ok := sessions.sessions[cookie.Value]
+	if !ok {
+		return webauthn.SessionData{}, ErrSessionNotFound
+	}
+	delete(sessions.sessions, cookie.Value)
+
+	if !session.Expires.IsZero() && session.Expires.Before(time.Now()) {
+		return webauthn.SessionData{}, ErrSessionExpired
+	}
+
+	return session, nil
+}
+
+func init() {
+	baseURL, err := url.Parse(config.Config.Http.BaseURL)
+	if err != nil {
+		logger.Log.Fatal().Err(err).Str("base_url", config.Config.Http.BaseURL).Msg("invalid base URL")
+	}
+
+	timeout := webauthn.TimeoutConfig{
+		Enforce:    true,
+		Timeout:    config.Config.Http.WebAuthn.Timeout,
+		TimeoutUVD: config.Config.Http.WebAuthn.Timeout,
+	}
+
+	WebAuthn, err = webauthn.New(&webauthn.Config{
+		RPID:          baseURL.Hostname(),
+		RPDisplayName: config.Config.Mail.Sender.Name,
+		RPOrigins:     []string{baseURL.Scheme + "://" + baseURL.Host},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        timeout,
+			Registration: timeout,
+		},
+	})
+	if err != nil {
+		logger.Log.Fatal().Err(err).Msg("failed to configure webauthn")
+	}
+}
@@ -0,0 +1,73 @@
+package permissions
+
+import "slices"
+
+type Permission string
+
+const (
+	ApproveRegistrations Permission = "approve_registrations"
+	ManageUsers          Permission = "manage_users"
+	ManageRoles          Permission = "manage_roles"
+	ViewLogs             Permission = "view_logs"
+	ManageGate           Permission = "manage_gate"
+	ManageFirmware       Permission = "manage_firmware"
+	ManageInvitations    Permission = "manage_invitations"
+	ImpersonateUsers     Permission = "impersonate_users"
+)
+
+// All lists every known permission, in the order they should be displayed
+var All = []Permission{
+	ApproveRegistrations,
+	ManageUsers,
+	ManageRoles,
+	ViewLogs,
+	ManageGate,
+	ManageFirmware,
+	ManageInvitations,
+	ImpersonateUsers,
+}
+
+var labels = map[Permission]string{
+	ApproveRegistrations: "Valider les inscriptions",
+	ManageUsers:          "Gérer les utilisateurs",
+	ManageRoles:          "Gérer les rôles",
+	ViewLogs:             "Consulter les ouvertures",
+	ManageGate:           "Gérer le portail",
+	ManageFirmware:       "Mettre à jour le firmware",
+	ManageInvitations:    "Gérer les codes d'invitation",
+	ImpersonateUsers:     "Voir l'application en tant qu'un utilisateur",
+}
+
+func (p Permission) Label() string {
+	if label, ok := labels[p]; ok {
+		return label
+	}
+	return string(p)
+}
+
+func IsValid(permission string) bool {
+	_, ok := labels[Permission(permission)]
+	return ok
+}
+
+// HasAny returns true if at least one of the given permissions is in the granted list.
+func HasAny(granted []string, permissions ...Permission) bool {
+	for _, g := range granted {
+		for _, p := range permissions {
+			if g == string(p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasAll returns true if every permission of the required list is in the granted list.
+func HasAll(granted []string, required []string) bool {
+	for _, r := range required {
+		if !slices.Contains(granted, r) {
+			return false
+		}
+	}
+	return true
+}
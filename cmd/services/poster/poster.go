@@ -0,0 +1,71 @@
+package poster
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"woody-wood-portail/cmd/config"
+
+	"github.com/go-pdf/fpdf"
+)
+
+const (
+	pageWidth = 210.0
+	margin    = 20.0
+	qrSize    = 110.0
+)
+
+// Invitation writes an A4 PDF poster inviting the residents to register with the code, to be printed and displayed in the hall
+func Invitation(w io.Writer, code string, qrPNG []byte) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(margin, margin, margin)
+	pdf.SetAutoPageBreak(false, margin)
+	pdf.SetTitle("Invitation Woody Wood Gate", true)
+	// The core fonts only support cp1252, enough for French
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 30)
+	pdf.CellFormat(0, 16, tr(config.Config.Users.Invitation.BuildingName), "", 1, "C", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 18)
+	pdf.CellFormat(0, 12, tr("Ouvrez le portail avec votre téléphone"), "", 1, "C", false, 0, "")
+
+	pdf.RegisterImageOptionsReader("qrcode", fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(qrPNG))
+	qrY := pdf.GetY() + 10
+	pdf.ImageOptions("qrcode", (pageWidth-qrSize)/2, qrY, qrSize, qrSize, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+	pdf.SetY(qrY + qrSize + 10)
+
+	host := config.Config.Http.BaseURL
+	if _, after, found := strings.Cut(host, "://"); found {
+		host = after
+	}
+
+	pdf.SetFont("Helvetica", "", 14)
+	steps := []string{
+		"1. Scannez ce QR code, ou rendez-vous sur " + host + "/register",
+		"2. Saisissez le code d'invitation ci-dessous",
+		"3. Joignez un justificatif de domicile, votre inscription sera validée par le conseil syndical",
+	}
+	for _, step := range steps {
+		pdf.MultiCell(0, 8, tr(step), "", "L", false)
+		pdf.Ln(2)
+	}
+
+	pdf.Ln(6)
+	pdf.SetFont("Helvetica", "", 14)
+	pdf.CellFormat(0, 8, tr("Code d'invitation"), "", 1, "C", false, 0, "")
+	pdf.SetFont("Courier", "B", 40)
+	pdf.CellFormat(0, 18, code, "", 1, "C", false, 0, "")
+
+	pdf.SetY(-margin - 10)
+	pdf.SetFont("Helvetica", "I", 9)
+	pdf.MultiCell(0, 5, tr("Ce code est réservé aux résidents, merci de ne pas le diffuser en dehors de la résidence."), "", "C", false)
+
+	if err := pdf.Output(w); err != nil {
+		return fmt.Errorf("failed to render invitation poster: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,498 @@
+package proofs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"woody-wood-portail/cmd/config"
+	"woody-wood-portail/cmd/logger"
+	"woody-wood-portail/cmd/services/encryption"
+	"woody-wood-portail/cmd/services/storage"
+
+	"github.com/google/uuid"
+)
+
+// The address proofs are encrypted with a random data key, itself encrypted (wrapped) with a configured key.
+// Rotating the configured key only rewrites the wrapped data keys, the contents are never decrypted at rest.
+//
+// File layout: magic | key id length (1 byte) | key id | wrapped key length (2 bytes) | wrapped key | sealed content
+// The sealed content is the original file name length (2 bytes), the file name and the file content.
+//
+// A user can upload several documents, stored as <user id>/<document id>.enc, with their preview generated on the
+// first display stored as <user id>/<document id>.preview.enc
+
+var (
+	ErrNotFound        = errors.New("address proof not found")
+	ErrUnsupportedType = errors.New("unsupported address proof type")
+	ErrTooLarge        = errors.New("address proof too large")
+)
+
+const (
+	magic      = "WWAP1"
+	dataKeyLen = 32
+
+	documentExt = ".enc"
+	previewExt  = ".preview.enc"
+)
+
+// AllowedTypes are the content types accepted for the address proofs, with a description for the users
+var AllowedTypes = map[string]string{
+	"application/pdf": "PDF",
+	"image/jpeg":      "JPEG",
+	"image/png":       "PNG",
+}
+
+// AcceptedTypes returns the allowed types for the accept attribute of the file inputs
+func AcceptedTypes() string {
+	types := make([]string, 0, len(AllowedTypes))
+	for contentType := range AllowedTypes {
+		types = append(types, contentType)
+	}
+	sort.Strings(types)
+	return strings.Join(types, ",")
+}
+
+// AllowedTypesLabel lists the allowed types for the users, like "JPEG, PDF ou PNG"
+func AllowedTypesLabel() string {
+	labels := make([]string, 0, len(AllowedTypes))
+	for _, label := range AllowedTypes {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	if len(labels) == 1 {
+		return labels[0]
+	}
+	return strings.Join(labels[:len(labels)-1], ", ") + " ou " + labels[len(labels)-1]
+}
+
+// The document id is a uuid, or "proof" for the single document stored before several documents were allowed
+var documentIDRegexp = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Document is a decrypted address proof
+type Document struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+	ModTime     time.Time
+}
+
+// keys decrypt the proofs, the first one encrypts the new proofs
+var keys encryption.Keys
+
+// LoadKeys parses the configured keys, the first one being the current key and the others only kept to decrypt
+// the proofs until they are re-encrypted
+func LoadKeys() error {
+	var err error
+	keys, err = encryption.ParseKeys(config.Config.Users.AddressProofKeys)
+	if err != nil {
+		return fmt.Errorf("invalid address proof keys: %w", err)
+	}
+	return nil
+}
+
+func documentKey(userID uuid.UUID, documentID string) string {
+	return userID.String() + "/" + documentID + documentExt
+}
+
+func previewKey(userID uuid.UUID, documentID string) string {
+	return userID.String() + "/" + documentID + previewExt
+}
+
+// Validate checks the size and the type of an uploaded address proof, and returns its content type
+func Validate(content []byte) (string, error) {
+	if int64(len(content)) > config.Config.Users.AddressProofMaxSize {
+		return "", ErrTooLarge
+	}
+
+	// The type is detected from the content, the type sent by the browser cannot be trusted
+	contentType, _, _ := strings.Cut(http.DetectContentType(content), ";")
+	if _, ok := AllowedTypes[contentType]; !ok {
+		return "", ErrUnsupportedType
+	}
+	return contentType, nil
+}
+
+// Save encrypts and stores an address proof of a user, and returns the id of the new document
+func Save(ctx context.Context, userID uuid.UUID, filename string, content []byte) (string, error) {
+	documentID := uuid.NewString()
+	if err := save(ctx, userID, documentKey(userID, documentID), path.Base(filename), content, time.Now()); err != nil {
+		return "", err
+	}
+	return documentID, nil
+}
+
+func save(ctx context.Context, userID uuid.UUID, key string, filename string, content []byte, modTime time.Time) error {
+	current := keys.Current()
+
+	dataKey := make([]byte, dataKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return fmt.Errorf("failed to generate data key: %w", err)
+	}
+	dataAEAD, err := encryption.NewAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	// The user id is authenticated so that a proof cannot be moved to another user
+	plaintext := binary.BigEndian.AppendUint16(nil, uint16(len(filename)))
+	plaintext = append(plaintext, filename...)
+	plaintext = append(plaintext, content...)
+	sealed, err := encryption.Seal(dataAEAD, plaintext, userID[:])
+	if err != nil {
+		return err
+	}
+
+	wrapped, err := encryption.Seal(current.AEAD, dataKey, userID[:])
+	if err != nil {
+		return err
+	}
+
+	return write(ctx, key, current.ID, wrapped, sealed, modTime)
+}
+
+func write(ctx context.Context, key string, keyID string, wrapped, sealed []byte, modTime time.Time) error {
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+	buf.WriteByte(byte(len(keyID)))
+	buf.WriteString(keyID)
+	buf.Write(binary.BigEndian.AppendUint16(nil, uint16(len(wrapped))))
+	buf.Write(wrapped)
+	buf.Write(sealed)
+
+	if err := storage.AddressProofs.Put(ctx, key, buf.Bytes(), modTime); err != nil {
+		return fmt.Errorf("failed to save address proof: %w", err)
+	}
+	return nil
+}
+
+type envelope struct {
+	KeyID   string
+	Wrapped []byte
+	Sealed  []byte
+	ModTime time.Time
+}
+
+func read(ctx context.Context, key string) (*envelope, error) {
+	data, info, err := storage.AddressProofs.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read address proof %s: %w", key, err)
+	}
+
+	invalid := fmt.Errorf("invalid address proof %s", key)
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, invalid
+	}
+	data = data[len(magic):]
+
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return nil, invalid
+	}
+	env := &envelope{KeyID: string(data[1 : 1+data[0]]), ModTime: info.ModTime}
+	data = data[1+data[0]:]
+
+	if len(data) < 2 {
+		return nil, invalid
+	}
+	wrappedLen := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+wrappedLen {
+		return nil, invalid
+	}
+	env.Wrapped = data[2 : 2+wrappedLen]
+	env.Sealed = data[2+wrappedLen:]
+
+	return env, nil
+}
+
+func decrypt(ctx context.Context, userID uuid.UUID, key string) (*Document, error) {
+	env, err := read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := keys.Find(env.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := encryption.Open(k.AEAD, env.Wrapped, userID[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap address proof key: %w", err)
+	}
+	dataAEAD, err := encryption.NewAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := encryption.Open(dataAEAD, env.Sealed, userID[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt address proof: %w", err)
+	}
+
+	if len(plaintext) < 2 || len(plaintext) < 2+int(binary.BigEndian.Uint16(plaintext)) {
+		return nil, errors.New("invalid decrypted address proof")
+	}
+	nameLen := int(binary.BigEndian.Uint16(plaintext))
+	content := plaintext[2+nameLen:]
+	contentType, _, _ := strings.Cut(http.DetectContentType(content), ";")
+
+	return &Document{
+		Filename:    string(plaintext[2 : 2+nameLen]),
+		ContentType: contentType,
+		Content:     content,
+		ModTime:     env.ModTime,
+	}, nil
+}
+
+// List returns the ids of the address proof documents of a user
+func List(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	keys, err := storage.AddressProofs.List(ctx, userID.String()+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list address proofs: %w", err)
+	}
+
+	documents := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if documentID, ok := parseDocumentKey(userID, key); ok {
+			documents = append(documents, documentID)
+		}
+	}
+	return documents, nil
+}
+
+func parseDocumentKey(userID uuid.UUID, key string) (string, bool) {
+	name := strings.TrimPrefix(key, userID.String()+"/")
+	if strings.HasSuffix(name, previewExt) || !strings.HasSuffix(name, documentExt) {
+		return "", false
+	}
+	documentID := strings.TrimSuffix(name, documentExt)
+	return documentID, documentIDRegexp.MatchString(documentID)
+}
+
+// Open decrypts an address proof document, it must only be called to send it to an authorized administrator
+func Open(ctx context.Context, userID uuid.UUID, documentID string) (*Document, error) {
+	if !documentIDRegexp.MatchString(documentID) {
+		return nil, ErrNotFound
+	}
+	return decrypt(ctx, userID, documentKey(userID, documentID))
+}
+
+// Watermarked decrypts an address proof document, with the configured watermark over its content.
+// The documents of types which cannot be watermarked, only uploaded before the types were checked, are returned as is.
+func Watermarked(ctx context.Context, userID uuid.UUID, documentID string) (*Document, error) {
+	document, err := Open(ctx, userID, documentID)
+	if err != nil || config.Config.Users.AddressProofWatermark == "" {
+		return document, err
+	}
+	if _, ok := AllowedTypes[document.ContentType]; !ok {
+		logger.Log.Warn().Stringer("user", userID).Str("type", document.ContentType).Msg("address proof sent without watermark")
+		return document, nil
+	}
+
+	document.Content, err = watermarkCopy(document.ContentType, document.Content)
+	if err != nil {
+		return nil, err
+	}
+	if document.ContentType == "application/pdf" {
+		// The copy is always a PDF, but the name must still have the right extension
+		document.Filename = strings.TrimSuffix(document.Filename, path.Ext(document.Filename)) + ".pdf"
+	}
+	return document, nil
+}
+
+// Preview returns a JPEG thumbnail of an address proof document, generated and stored encrypted on the first call
+func Preview(ctx context.Context, userID uuid.UUID, documentID string) ([]byte, error) {
+	if !documentIDRegexp.MatchString(documentID) {
+		return nil, ErrNotFound
+	}
+
+	key := previewKey(userID, documentID)
+	if stored, err := decrypt(ctx, userID, key); err == nil {
+		return stored.Content, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	document, err := Open(ctx, userID, documentID)
+	if err != nil {
+		return nil, err
+	}
+	content, err := preview(document.ContentType, document.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	// The preview keeps the time of the document, to not delay its purge
+	if err := save(ctx, userID, key, "preview.jpg", content, document.ModTime); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// DeleteDocuments removes some address proof documents of a user, with their preview
+func DeleteDocuments(ctx context.Context, userID uuid.UUID, documentIDs []string) error {
+	for _, documentID := range documentIDs {
+		for _, key := range []string{documentKey(userID, documentID), previewKey(userID, documentID)} {
+			if err := storage.AddressProofs.Delete(ctx, key); err != nil {
+				return fmt.Errorf("failed to remove address proof: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Delete removes the address proofs of a user, if any
+func Delete(ctx context.Context, userID uuid.UUID) error {
+	if err := storage.DeletePrefix(ctx, storage.AddressProofs, userID.String()+"/"); err != nil {
+		return fmt.Errorf("failed to remove address proof: %w", err)
+	}
+	return nil
+}
+
+// listFiles returns the keys of the stored files by user
+func listFiles(ctx context.Context) (map[uuid.UUID][]string, error) {
+	keys, err := storage.AddressProofs.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[uuid.UUID][]string{}
+	for _, key := range keys {
+		dir, _, _ := strings.Cut(key, "/")
+		userID, err := uuid.Parse(dir)
+		if err != nil {
+			continue
+		}
+		files[userID] = append(files[userID], key)
+	}
+	return files, nil
+}
+
+func sortedUsers(files map[uuid.UUID][]string) []uuid.UUID {
+	users := make([]uuid.UUID, 0, len(files))
+	for userID := range files {
+		users = append(users, userID)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].String() < users[j].String() })
+	return users
+}
+
+// Reencrypt wraps the data keys with the current key, and encrypts the proofs stored in clear before the encryption
+// was introduced
+func Reencrypt() {
+	ctx := context.Background()
+	files, err := listFiles(ctx)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("failed to list address proofs to re-encrypt")
+		return
+	}
+
+	for _, userID := range sortedUsers(files) {
+		for _, key := range files[userID] {
+			var err error
+			if strings.HasSuffix(key, documentExt) {
+				err = rewrap(ctx, userID, key)
+			} else {
+				err = encryptLegacy(ctx, userID, key)
+			}
+			if err != nil {
+				logger.Log.Error().Err(err).Stringer("user", userID).Str("key", key).Msg("failed to re-encrypt address proof")
+			}
+		}
+	}
+}
+
+func rewrap(ctx context.Context, userID uuid.UUID, key string) error {
+	env, err := read(ctx, key)
+	if err != nil {
+		return err
+	}
+	current := keys.Current()
+	if env.KeyID == current.ID {
+		return nil
+	}
+
+	k, err := keys.Find(env.KeyID)
+	if err != nil {
+		return err
+	}
+	dataKey, err := encryption.Open(k.AEAD, env.Wrapped, userID[:])
+	if err != nil {
+		return fmt.Errorf("failed to unwrap address proof key: %w", err)
+	}
+	wrapped, err := encryption.Seal(current.AEAD, dataKey, userID[:])
+	if err != nil {
+		return err
+	}
+
+	// The modification time is kept for the retention period to start from the upload
+	if err := write(ctx, key, current.ID, wrapped, env.Sealed, env.ModTime); err != nil {
+		return err
+	}
+	logger.Log.Info().Stringer("user", userID).Str("key", key).Str("from", env.KeyID).Str("to", current.ID).Msg("address proof key rotated")
+	return nil
+}
+
+func encryptLegacy(ctx context.Context, userID uuid.UUID, key string) error {
+	content, info, err := storage.AddressProofs.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := save(ctx, userID, documentKey(userID, uuid.NewString()), path.Base(key), content, info.ModTime); err != nil {
+		return err
+	}
+	if err := storage.AddressProofs.Delete(ctx, key); err != nil {
+		return err
+	}
+	logger.Log.Info().Stringer("user", userID).Msg("clear address proof encrypted")
+	return nil
+}
+
+// Purge deletes the address proofs older than the retention period, whatever the registration state
+func Purge() {
+	retention := config.Config.Users.AddressProofRetention
+	if retention <= 0 {
+		return
+	}
+
+	ctx := context.Background()
+	files, err := listFiles(ctx)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("failed to list address proofs to purge")
+		return
+	}
+
+	// Each document is purged on its own, a newer document of the same registration must not keep an expired one
+	cutoff := time.Now().Add(-retention)
+	for _, userID := range sortedUsers(files) {
+		purged := 0
+		for _, key := range files[userID] {
+			info, err := storage.AddressProofs.Stat(ctx, key)
+			if err != nil {
+				logger.Log.Error().Err(err).Str("key", key).Msg("failed to check address proof age")
+				continue
+			}
+			if info.ModTime.After(cutoff) {
+				continue
+			}
+
+			// The preview keeps the time of its document, they are purged together
+			if err := storage.AddressProofs.Delete(ctx, key); err != nil {
+				logger.Log.Error().Err(err).Str("key", key).Msg("failed to purge address proof")
+				continue
+			}
+			purged++
+		}
+		if purged > 0 {
+			logger.Log.Info().Stringer("user", userID).Int("files", purged).Msg("expired address proofs purged")
+		}
+	}
+}
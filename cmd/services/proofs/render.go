@@ -0,0 +1,244 @@
+package proofs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"sync"
+	"time"
+	"woody-wood-portail/cmd/config"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/webassembly"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// Width of the previews displayed in the registrations list, in pixels
+	previewWidth = 320
+	// Resolution of the PDF pages rasterized to be watermarked
+	watermarkDPI = 150
+	// Pages of a PDF beyond this limit are not included in the watermarked copy
+	maxWatermarkedPages = 20
+)
+
+// The PDF renderer is a WebAssembly build of pdfium, to not depend on cgo. It is only started on the first PDF to render.
+var pdfRenderer struct {
+	sync.Mutex
+	pool pdfium.Pool
+}
+
+func pdfInstance() (pdfium.Pdfium, error) {
+	pdfRenderer.Lock()
+	defer pdfRenderer.Unlock()
+
+	if pdfRenderer.pool == nil {
+		pool, err := webassembly.Init(webassembly.Config{MinIdle: 0, MaxIdle: 1, MaxTotal: 2})
+		if err != nil {
+			return nil, fmt.Errorf("failed to start the PDF renderer: %w", err)
+		}
+		pdfRenderer.pool = pool
+	}
+
+	return pdfRenderer.pool.GetInstance(30 * time.Second)
+}
+
+// renderPDF rasterizes the first pages of a PDF document
+func renderPDF(content []byte, maxPages int, dpi int) ([]image.Image, error) {
+	instance, err := pdfInstance()
+	if err != nil {
+		return nil, err
+	}
+	defer instance.Close()
+
+	doc, err := instance.OpenDocument(&requests.OpenDocument{File: &content})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF: %w", err)
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{Document: doc.Document})
+
+	count, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{Document: doc.Document})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count PDF pages: %w", err)
+	}
+
+	pages := make([]image.Image, 0, min(count.PageCount, maxPages))
+	for i := 0; i < count.PageCount && i < maxPages; i++ {
+		render, err := instance.RenderPageInDPI(&requests.RenderPageInDPI{
+			DPI:  dpi,
+			Page: requests.Page{ByIndex: &requests.PageByIndex{Document: doc.Document, Index: i}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to render PDF page %d: %w", i, err)
+		}
+
+		// The image lives in the WebAssembly memory until the cleanup
+		page := image.NewRGBA(render.Result.Image.Bounds())
+		draw.Draw(page, page.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(page, page.Bounds(), render.Result.Image, render.Result.Image.Bounds().Min, draw.Over)
+		render.Cleanup()
+
+		pages = append(pages, page)
+	}
+
+	if len(pages) == 0 {
+		return nil, errors.New("empty PDF")
+	}
+	return pages, nil
+}
+
+// decodePages returns the images of a document, or its first pages for a PDF
+func decodePages(contentType string, content []byte, maxPages int, dpi int) ([]image.Image, error) {
+	switch contentType {
+	case "application/pdf":
+		return renderPDF(content, maxPages, dpi)
+	case "image/jpeg", "image/png":
+		img, _, err := image.Decode(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+		return []image.Image{img}, nil
+	default:
+		return nil, ErrUnsupportedType
+	}
+}
+
+// preview renders a watermarked JPEG thumbnail of an image, or of the first page of a PDF
+func preview(contentType string, content []byte) ([]byte, error) {
+	// The first page is rendered small, it is resized to the preview width anyway
+	pages, err := decodePages(contentType, content, 1, 72)
+	if err != nil {
+		return nil, err
+	}
+
+	src := pages[0]
+	bounds := src.Bounds()
+	width := min(previewWidth, bounds.Dx())
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), src, bounds, draw.Src, nil)
+	if err := watermark(thumbnail); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, fmt.Errorf("failed to encode preview: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// watermarkCopy returns a copy of the document with the configured watermark over each page. The PDF pages are
+// rasterized, the copy is only meant to be read.
+func watermarkCopy(contentType string, content []byte) ([]byte, error) {
+	pages, err := decodePages(contentType, content, maxWatermarkedPages, watermarkDPI)
+	if err != nil {
+		return nil, err
+	}
+
+	marked := make([]*image.RGBA, len(pages))
+	for i, page := range pages {
+		marked[i] = image.NewRGBA(page.Bounds())
+		draw.Draw(marked[i], marked[i].Bounds(), page, page.Bounds().Min, draw.Src)
+		if err := watermark(marked[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	switch contentType {
+	case "image/png":
+		err = png.Encode(&buf, marked[0])
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, marked[0], &jpeg.Options{Quality: 90})
+	default:
+		err = imagesToPDF(&buf, marked)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode watermarked copy: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func imagesToPDF(buf *bytes.Buffer, pages []*image.RGBA) error {
+	pdf := fpdf.NewCustom(&fpdf.InitType{UnitStr: "pt"})
+	pdf.SetAutoPageBreak(false, 0)
+
+	for i, page := range pages {
+		var jpg bytes.Buffer
+		if err := jpeg.Encode(&jpg, page, &jpeg.Options{Quality: 85}); err != nil {
+			return err
+		}
+
+		// Pages keep their size, in points of 1/72 inch
+		width := float64(page.Bounds().Dx()) * 72 / watermarkDPI
+		height := float64(page.Bounds().Dy()) * 72 / watermarkDPI
+		orientation := "P"
+		if width > height {
+			orientation = "L"
+		}
+		pdf.AddPageFormat(orientation, fpdf.SizeType{Wd: width, Ht: height})
+
+		name := fmt.Sprintf("page%d", i)
+		pdf.RegisterImageOptionsReader(name, fpdf.ImageOptions{ImageType: "JPG"}, &jpg)
+		pdf.ImageOptions(name, 0, 0, width, height, false, fpdf.ImageOptions{ImageType: "JPG"}, 0, "")
+	}
+
+	return pdf.Output(buf)
+}
+
+var watermarkFont struct {
+	sync.Once
+	font *opentype.Font
+	err  error
+}
+
+// watermark writes the configured text across the image, in several translucent lines
+func watermark(img *image.RGBA) error {
+	text := config.Config.Users.AddressProofWatermark
+	if text == "" {
+		return nil
+	}
+
+	watermarkFont.Do(func() {
+		watermarkFont.font, watermarkFont.err = opentype.Parse(gobold.TTF)
+	})
+	if watermarkFont.err != nil {
+		return fmt.Errorf("failed to load the watermark font: %w", watermarkFont.err)
+	}
+
+	bounds := img.Bounds()
+	// The text spans about the whole width of the image
+	size := math.Max(8, float64(bounds.Dx())/float64(len([]rune(text)))*1.4)
+	face, err := opentype.NewFace(watermarkFont.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return fmt.Errorf("failed to create the watermark font face: %w", err)
+	}
+	defer face.Close()
+
+	drawer := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.NRGBA{R: 220, G: 38, B: 38, A: 90}),
+		Face: face,
+	}
+	textWidth := drawer.MeasureString(text).Ceil()
+	lineHeight := int(size * 4)
+
+	for y := bounds.Min.Y + lineHeight/2; y < bounds.Max.Y; y += lineHeight {
+		drawer.Dot = fixed.P(bounds.Min.X+(bounds.Dx()-textWidth)/2, y)
+		drawer.DrawString(text)
+	}
+	return nil
+}
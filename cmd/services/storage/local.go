@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Prefix of the files being written, ignored when listing
+const localTempPrefix = ".tmp-"
+
+type local struct {
+	root string
+}
+
+// NewLocal returns a storage keeping the blobs as files in the root directory
+func NewLocal(root string) Storage {
+	return &local{root: root}
+}
+
+func (l *local) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || cleaned != "/"+key {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(l.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (l *local) Put(ctx context.Context, key string, content []byte, modTime time.Time) error {
+	filePath, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	// Written next to the file then renamed, to never leave a truncated file
+	tmp, err := os.CreateTemp(dir, localTempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create file in %s: %w", dir, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", tmp.Name(), err)
+	}
+	if err := os.Chtimes(tmp.Name(), modTime, modTime); err != nil {
+		return fmt.Errorf("failed to set file time of %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to save file %s: %w", filePath, err)
+	}
+	return nil
+}
+
+func (l *local) Get(ctx context.Context, key string) ([]byte, Info, error) {
+	info, err := l.Stat(ctx, key)
+	if err != nil {
+		return nil, info, err
+	}
+	filePath, _ := l.path(key)
+	content, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, info, ErrNotFound
+	} else if err != nil {
+		return nil, info, fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+	return content, info, nil
+}
+
+func (l *local) Stat(ctx context.Context, key string) (Info, error) {
+	filePath, err := l.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	stat, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && stat.IsDir()) {
+		return Info{}, ErrNotFound
+	} else if err != nil {
+		return Info{}, fmt.Errorf("failed to stat file %s: %w", filePath, err)
+	}
+	return Info{Key: key, ModTime: stat.ModTime(), Size: stat.Size()}, nil
+}
+
+func (l *local) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(l.root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && filePath == l.root {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), localTempPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.root, filePath)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files of %s: %w", l.root, err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (l *local) Delete(ctx context.Context, key string) error {
+	filePath, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete file %s: %w", filePath, err)
+	}
+
+	// Removes the directories left empty, the errors of non empty directories are expected
+	root := filepath.Clean(l.root)
+	for dir := filepath.Dir(filePath); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	testStorage(t, NewLocal(t.TempDir()))
+}
+
+func TestLocalMissingRoot(t *testing.T) {
+	s := NewLocal(filepath.Join(t.TempDir(), "missing"))
+	if keys, err := s.List(context.Background(), ""); err != nil || len(keys) != 0 {
+		t.Errorf("got %v, %v when listing a storage never written", keys, err)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	root := t.TempDir()
+	l := &local{root: root}
+
+	if filePath, err := l.path("user/doc"); err != nil || filePath != filepath.Join(root, "user", "doc") {
+		t.Errorf("got %q, %v for a valid key", filePath, err)
+	}
+
+	for _, key := range []string{"", "/", "..", "../doc", "user/../../doc", "user/../doc", "/etc/passwd", "user//doc", "user/./doc", "user/"} {
+		if filePath, err := l.path(key); err == nil {
+			t.Errorf("key %q is accepted as %q", key, filePath)
+		}
+	}
+}
+
+func TestLocalRejectsEscapingKeys(t *testing.T) {
+	parent := t.TempDir()
+	s := NewLocal(filepath.Join(parent, "root"))
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "../escaped", []byte("content"), testModTime); err == nil {
+		t.Error("Put accepted a key outside of the root")
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped")); !os.IsNotExist(err) {
+		t.Error("a file was written outside of the root")
+	}
+
+	outside := filepath.Join(parent, "outside")
+	if err := os.WriteFile(outside, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Get(ctx, "../outside"); err == nil {
+		t.Error("Get read a file outside of the root")
+	}
+	if err := s.Delete(ctx, "../outside"); err == nil {
+		t.Error("Delete accepted a key outside of the root")
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("the file outside of the root was deleted: %v", err)
+	}
+}
+
+func TestLocalDeleteRemovesEmptyDirectories(t *testing.T) {
+	root := t.TempDir()
+	s := NewLocal(root)
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "user/preview/doc", []byte("content"), testModTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "user/preview/doc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "user")); !os.IsNotExist(err) {
+		t.Error("the empty directories of the deleted file are kept")
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Errorf("the root directory was removed: %v", err)
+	}
+}
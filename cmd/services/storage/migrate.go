@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"woody-wood-portail/cmd/config"
+	"woody-wood-portail/cmd/logger"
+)
+
+// Migrate copies the files of the local filesystem to the configured backend, keeping their modification time.
+// The files already present in the backend are skipped, and the local files are deleted once copied if deleteLocal is set.
+func Migrate(ctx context.Context, deleteLocal bool) error {
+	if config.Config.Storage.Backend == "local" {
+		return errors.New("the configured storage backend is already the local filesystem")
+	}
+	if err := Open(); err != nil {
+		return err
+	}
+
+	localAddressProofs, localFirmwares := LocalStorages()
+	if err := migrate(ctx, "address proofs", localAddressProofs, AddressProofs, deleteLocal); err != nil {
+		return err
+	}
+	return migrate(ctx, "firmwares", localFirmwares, Firmwares, deleteLocal)
+}
+
+func migrate(ctx context.Context, name string, from, to Storage, deleteLocal bool) error {
+	keys, err := from.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to list local %s: %w", name, err)
+	}
+
+	copied := 0
+	for _, key := range keys {
+		if _, err := to.Stat(ctx, key); err == nil {
+			logger.Log.Info().Str("key", key).Msgf("%s file already migrated", name)
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		} else {
+			content, info, err := from.Get(ctx, key)
+			if err != nil {
+				return err
+			}
+			if err := to.Put(ctx, key, content, info.ModTime); err != nil {
+				return err
+			}
+			copied++
+		}
+
+		if deleteLocal {
+			if err := from.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	logger.Log.Info().Int("files", len(keys)).Int("copied", copied).Bool("local deleted", deleteLocal).Msgf("%s migrated", name)
+	return nil
+}
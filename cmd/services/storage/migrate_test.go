@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"woody-wood-portail/cmd/config"
+)
+
+// useLocalDirectories sets the directories of the local backend for the duration of the test
+func useLocalDirectories(t *testing.T) {
+	t.Helper()
+
+	previousProofs, previousFirmwares := config.Config.Users.AddressProofsDirectory, config.Config.Gate.FirmwareDirectory
+	t.Cleanup(func() {
+		config.Config.Users.AddressProofsDirectory, config.Config.Gate.FirmwareDirectory = previousProofs, previousFirmwares
+	})
+
+	config.Config.Users.AddressProofsDirectory = t.TempDir()
+	config.Config.Gate.FirmwareDirectory = t.TempDir()
+}
+
+func TestMigrateRefusesLocalBackend(t *testing.T) {
+	previous := config.Config.Storage.Backend
+	t.Cleanup(func() { config.Config.Storage.Backend = previous })
+	config.Config.Storage.Backend = "local"
+
+	if err := Migrate(context.Background(), true); err == nil {
+		t.Error("migrated the local filesystem to itself")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	for _, deleteLocal := range []bool{false, true} {
+		name := "keep local"
+		if deleteLocal {
+			name = "delete local"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			useFakeS3(t)
+			useLocalDirectories(t)
+			ctx := context.Background()
+			localProofs, localFirmwares := LocalStorages()
+
+			for _, key := range []string{"user-1/doc", "user-1/preview/doc", "user-2/doc"} {
+				if err := localProofs.Put(ctx, key, []byte("local "+key), testModTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := localFirmwares.Put(ctx, "firmware.bin", []byte("firmware"), testModTime); err != nil {
+				t.Fatal(err)
+			}
+
+			// Left by a previous interrupted run, it must not be replaced
+			if err := newTestS3(t, "address_proofs/").Put(ctx, "user-2/doc", []byte("already migrated"), testModTime); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Migrate(ctx, deleteLocal); err != nil {
+				t.Fatalf("Migrate failed: %v", err)
+			}
+
+			if keys, err := AddressProofs.List(ctx, ""); err != nil || !slices.Equal(keys, []string{"user-1/doc", "user-1/preview/doc", "user-2/doc"}) {
+				t.Errorf("got %v, %v in the migrated address proofs", keys, err)
+			}
+			content, info, err := AddressProofs.Get(ctx, "user-1/preview/doc")
+			if err != nil || string(content) != "local user-1/preview/doc" || !info.ModTime.Equal(testModTime) {
+				t.Errorf("got %q, %+v, %v, expected the local content and time", content, info, err)
+			}
+			if content, _, err := AddressProofs.Get(ctx, "user-2/doc"); err != nil || string(content) != "already migrated" {
+				t.Errorf("got %q, %v, expected the blob already migrated to be kept", content, err)
+			}
+			if content, _, err := Firmwares.Get(ctx, "firmware.bin"); err != nil || string(content) != "firmware" {
+				t.Errorf("got %q, %v in the migrated firmwares", content, err)
+			}
+
+			proofKeys, _ := localProofs.List(ctx, "")
+			firmwareKeys, _ := localFirmwares.List(ctx, "")
+			if deleteLocal && (len(proofKeys) != 0 || len(firmwareKeys) != 0) {
+				t.Errorf("local files %v %v are kept after the migration", proofKeys, firmwareKeys)
+			}
+			if !deleteLocal && (len(proofKeys) != 3 || len(firmwareKeys) != 1) {
+				t.Errorf("local files %v %v were deleted without -delete-local", proofKeys, firmwareKeys)
+			}
+
+			// A second run has nothing left to copy
+			if err := Migrate(ctx, deleteLocal); err != nil {
+				t.Errorf("second Migrate failed: %v", err)
+			}
+		})
+	}
+}
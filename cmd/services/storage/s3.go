@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"woody-wood-portail/cmd/config"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// User metadata keeping the modification time given to Put, the object last modified date changes when it is copied
+const s3ModTimeMeta = "Mtime"
+
+type s3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 returns a storage keeping the blobs as objects of the configured S3 compatible bucket, with keys starting with prefix
+func NewS3(prefix string) (Storage, error) {
+	cfg := config.Config.Storage.S3
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	return &s3{client: client, bucket: cfg.Bucket, prefix: cfg.Prefix + prefix}, nil
+}
+
+func isNotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+func (s *s3) Put(ctx context.Context, key string, content []byte, modTime time.Time) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.prefix+key, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		UserMetadata: map[string]string{s3ModTimeMeta: modTime.UTC().Format(time.RFC3339Nano)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *s3) info(key string, object minio.ObjectInfo) Info {
+	info := Info{Key: key, ModTime: object.LastModified, Size: object.Size}
+	if modTime, err := time.Parse(time.RFC3339Nano, object.UserMetadata[s3ModTimeMeta]); err == nil {
+		info.ModTime = modTime
+	}
+	return info
+}
+
+func (s *s3) Get(ctx context.Context, key string) ([]byte, Info, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, s.prefix+key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+	defer object.Close()
+
+	// The request is only sent on the first read
+	stat, err := object.Stat()
+	if isNotFound(err) {
+		return nil, Info{}, ErrNotFound
+	} else if err != nil {
+		return nil, Info{}, fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+	content, err := io.ReadAll(object)
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("failed to read object %s: %w", key, err)
+	}
+	return content, s.info(key, stat), nil
+}
+
+func (s *s3) Stat(ctx context.Context, key string) (Info, error) {
+	stat, err := s.client.StatObject(ctx, s.bucket, s.prefix+key, minio.StatObjectOptions{})
+	if isNotFound(err) {
+		return Info{}, ErrNotFound
+	} else if err != nil {
+		return Info{}, fmt.Errorf("failed to stat object %s: %w", key, err)
+	}
+	return s.info(key, stat), nil
+}
+
+func (s *s3) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix + prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", object.Err)
+		}
+		keys = append(keys, strings.TrimPrefix(object.Key, s.prefix))
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *s3) Delete(ctx context.Context, key string) error {
+	if err := s.client.RemoveObject(ctx, s.bucket, s.prefix+key, minio.RemoveObjectOptions{}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
+	return nil
+}
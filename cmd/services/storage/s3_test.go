@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"woody-wood-portail/cmd/config"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// useFakeS3 configures the s3 backend to use an in memory S3 server for the duration of the test
+func useFakeS3(t *testing.T) {
+	t.Helper()
+
+	backend := s3mem.New()
+	if err := backend.CreateBucket("gate"); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(gofakes3.New(backend, gofakes3.WithLogger(gofakes3.DiscardLog())).Server())
+	t.Cleanup(server.Close)
+
+	previous := config.Config.Storage
+	t.Cleanup(func() { config.Config.Storage = previous })
+
+	config.Config.Storage.Backend = "s3"
+	config.Config.Storage.S3.Endpoint = strings.TrimPrefix(server.URL, "http://")
+	config.Config.Storage.S3.Region = "us-east-1"
+	config.Config.Storage.S3.Bucket = "gate"
+	config.Config.Storage.S3.AccessKey = "access"
+	config.Config.Storage.S3.SecretKey = "secret"
+	config.Config.Storage.S3.UseSSL = false
+	config.Config.Storage.S3.Prefix = "portail/"
+}
+
+func newTestS3(t *testing.T, prefix string) Storage {
+	t.Helper()
+
+	s, err := NewS3(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestS3(t *testing.T) {
+	useFakeS3(t)
+	testStorage(t, newTestS3(t, "address_proofs/"))
+}
+
+func TestS3Prefixes(t *testing.T) {
+	useFakeS3(t)
+	addressProofs, firmwares := newTestS3(t, "address_proofs/"), newTestS3(t, "firmwares/")
+	ctx := context.Background()
+
+	testStorage(t, addressProofs)
+
+	// The storages share the bucket, they must not see the blobs of each other
+	if keys, err := firmwares.List(ctx, ""); err != nil || len(keys) != 0 {
+		t.Errorf("got %v, %v when listing the firmwares", keys, err)
+	}
+	if keys, err := newTestS3(t, "").List(ctx, ""); err != nil || len(keys) != 1 || keys[0] != "address_proofs/other/doc" {
+		t.Errorf("got %v, %v, expected the keys to be under the configured prefix", keys, err)
+	}
+}
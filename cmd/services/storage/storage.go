@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"woody-wood-portail/cmd/config"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Info describes a stored blob
+type Info struct {
+	Key string
+	// ModTime is the time given when the blob was stored, kept when the blob is moved to another backend
+	ModTime time.Time
+	Size    int64
+}
+
+// Storage stores blobs by slash separated keys
+type Storage interface {
+	// Put stores the content under key, replacing the previous content if any
+	Put(ctx context.Context, key string, content []byte, modTime time.Time) error
+	// Get returns the content stored under key, or ErrNotFound
+	Get(ctx context.Context, key string) ([]byte, Info, error)
+	// Stat returns the information of the blob stored under key, or ErrNotFound
+	Stat(ctx context.Context, key string) (Info, error)
+	// List returns the keys starting with prefix, sorted
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete removes the blob stored under key, without error if it does not exist
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	// AddressProofs stores the encrypted address proofs of the users
+	AddressProofs Storage
+	// Firmwares stores the firmware of the gate
+	Firmwares Storage
+)
+
+// Open creates the storages of the configured backend
+func Open() error {
+	switch config.Config.Storage.Backend {
+	case "local":
+		AddressProofs, Firmwares = LocalStorages()
+	case "s3":
+		var err error
+		if AddressProofs, err = NewS3("address_proofs/"); err != nil {
+			return err
+		}
+		if Firmwares, err = NewS3("firmwares/"); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown storage backend %q", config.Config.Storage.Backend)
+	}
+	return nil
+}
+
+// LocalStorages returns the storages of the local filesystem backend
+func LocalStorages() (addressProofs Storage, firmwares Storage) {
+	return NewLocal(config.Config.Users.AddressProofsDirectory), NewLocal(config.Config.Gate.FirmwareDirectory)
+}
+
+// DeletePrefix removes all the blobs whose key starts with prefix
+func DeletePrefix(ctx context.Context, s Storage, prefix string) error {
+	keys, err := s.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
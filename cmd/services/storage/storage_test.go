@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testModTime has no sub-second part, so that it is kept by all the backends
+var testModTime = time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+// testStorage checks the behavior expected from all the backends
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	if _, _, err := s.Get(ctx, "user/doc"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v when getting a missing blob, expected ErrNotFound", err)
+	}
+	if _, err := s.Stat(ctx, "user/doc"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v when stating a missing blob, expected ErrNotFound", err)
+	}
+	if keys, err := s.List(ctx, ""); err != nil || len(keys) != 0 {
+		t.Fatalf("got %v, %v when listing an empty storage", keys, err)
+	}
+
+	for _, key := range []string{"user/doc", "user/preview/doc", "other/doc"} {
+		if err := s.Put(ctx, key, []byte("content of "+key), testModTime); err != nil {
+			t.Fatalf("Put %s failed: %v", key, err)
+		}
+	}
+
+	content, info, err := s.Get(ctx, "user/doc")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if string(content) != "content of user/doc" {
+		t.Errorf("got content %q", content)
+	}
+	if info.Key != "user/doc" || info.Size != int64(len(content)) || !info.ModTime.Equal(testModTime) {
+		t.Errorf("got info %+v, expected the key, size and time given to Put", info)
+	}
+
+	if err := s.Put(ctx, "user/doc", []byte("replaced"), testModTime); err != nil {
+		t.Fatalf("Put over an existing blob failed: %v", err)
+	}
+	if stat, err := s.Stat(ctx, "user/doc"); err != nil || stat.Size != int64(len("replaced")) {
+		t.Errorf("got %+v, %v after replacing the blob", stat, err)
+	}
+
+	if keys, err := s.List(ctx, ""); err != nil || !slices.Equal(keys, []string{"other/doc", "user/doc", "user/preview/doc"}) {
+		t.Errorf("got %v, %v when listing all the blobs", keys, err)
+	}
+	if keys, err := s.List(ctx, "user/"); err != nil || !slices.Equal(keys, []string{"user/doc", "user/preview/doc"}) {
+		t.Errorf("got %v, %v when listing a prefix", keys, err)
+	}
+
+	if err := s.Delete(ctx, "user/doc"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, _, err := s.Get(ctx, "user/doc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v when getting a deleted blob, expected ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, "user/doc"); err != nil {
+		t.Errorf("got %v when deleting a missing blob", err)
+	}
+
+	if err := DeletePrefix(ctx, s, "user/"); err != nil {
+		t.Fatalf("DeletePrefix failed: %v", err)
+	}
+	if keys, err := s.List(ctx, ""); err != nil || !slices.Equal(keys, []string{"other/doc"}) {
+		t.Errorf("got %v, %v after deleting the prefix", keys, err)
+	}
+}
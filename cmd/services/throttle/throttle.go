@@ -0,0 +1,46 @@
+package throttle
+
+import (
+	"time"
+	"woody-wood-portail/cmd/config"
+)
+
+const (
+	KindLoginFailure  = "login_failure"
+	KindPasswordReset = "password_reset"
+	KindMagicLink     = "magic_link"
+	// Email change confirmations are counted by target address, and by account with the user id as email
+	KindEmailChange        = "email_change"
+	KindEmailChangeAccount = "email_change_account"
+)
+
+const (
+	maxLockDuration = 24 * time.Hour
+	delayStep       = 500 * time.Millisecond
+	maxDelay        = 5 * time.Second
+)
+
+// LockDuration returns how long an account should be locked after the given number of consecutive failed logins.
+// The account is locked each time MaxFailures more failures occur, twice as long as the previous time.
+func LockDuration(failedLogins int32) time.Duration {
+	maxFailures := int32(config.Config.Users.Login.MaxFailures)
+	if maxFailures <= 0 || failedLogins < maxFailures || failedLogins%maxFailures != 0 {
+		return 0
+	}
+
+	duration := config.Config.Users.Login.LockDuration
+	for i := int32(1); i < failedLogins/maxFailures && duration < maxLockDuration; i++ {
+		duration *= 2
+	}
+	return min(duration, maxLockDuration)
+}
+
+// Delay returns how long to wait before answering a failed login, growing with the recent failures of the same IP address
+func Delay(recentFailures int64) time.Duration {
+	return min(time.Duration(recentFailures)*delayStep, maxDelay)
+}
+
+// Since returns the start of the window in which attempts from the same IP address are counted
+func Since() time.Time {
+	return time.Now().Add(-config.Config.Users.Login.IPWindow)
+}
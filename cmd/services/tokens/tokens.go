@@ -0,0 +1,69 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+type Scope string
+
+const (
+	GateOpen Scope = "gate:open"
+	LogsRead Scope = "logs:read"
+)
+
+// All lists every known scope, in the order they should be displayed
+var All = []Scope{
+	GateOpen,
+	LogsRead,
+}
+
+var labels = map[Scope]string{
+	GateOpen: "Ouvrir le portail",
+	LogsRead: "Consulter mes ouvertures",
+}
+
+// Prefix makes the tokens easy to recognize, for example by secret scanners
+const Prefix = "wwg_"
+
+func (s Scope) Label() string {
+	if label, ok := labels[s]; ok {
+		return label
+	}
+	return string(s)
+}
+
+func IsValid(scope string) bool {
+	_, ok := labels[Scope(scope)]
+	return ok
+}
+
+// Has returns true if the scope is in the granted list
+func Has(granted []string, scope Scope) bool {
+	for _, g := range granted {
+		if g == string(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// Generate creates a new random token. Only its hash should be stored, the token is shown once to the user.
+func Generate() (string, []byte, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	token := Prefix + base64.RawURLEncoding.EncodeToString(raw)
+	return token, Hash(token), nil
+}
+
+// Hash returns the value stored in database for a token.
+// A fast hash is enough since tokens are random and long, unlike passwords.
+func Hash(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
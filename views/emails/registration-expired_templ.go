@@ -11,8 +11,13 @@ import templruntime "github.com/a-h/templ/runtime"
 import (
 	"fmt"
 	"woody-wood-portail/cmd/config"
+	"woody-wood-portail/cmd/services/db"
 )
 
+func renewalURL() templ.SafeURL {
+	return templ.SafeURL(fmt.Sprintf("%s/renew-registration", config.Config.Http.BaseURL))
+}
+
 func RegistrationWillExpire(until string) templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
 		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
@@ -31,23 +36,53 @@ func RegistrationWillExpire(until string) templ.Component {
 			templ_7745c5c3_Var1 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<h1>Votre inscription va bientôt expirer</h1><p>Votre inscription à Woody Wood Gate est sur le point d'expirer.<br>Pour des raisons de sécurité, votre inscription doit être renouvellée tous les 2 mois en entrant  à nouveau le code d'inscription se trouvant dans la résidence.<br></p><p><a href=\"")
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<h1>Votre inscription va bientôt expirer</h1><p>Votre inscription à Woody Wood Gate est sur le point d'expirer.<br>")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		if config.Config.Users.RenewalReview {
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("Pour des raisons de sécurité, votre inscription doit être renouvellée tous les 2 mois en entrant à nouveau le code d'inscription se trouvant dans la résidence, et en envoyant un justificatif de domicile récent.<br>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		} else {
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("Pour des raisons de sécurité, votre inscription doit être renouvellée tous les 2 mois en entrant  à nouveau le code d'inscription se trouvant dans la résidence.<br>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</p><p><a href=\"")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		var templ_7745c5c3_Var2 templ.SafeURL = templ.SafeURL(fmt.Sprintf("%s/renew-registraton", config.Config.Http.BaseURL))
+		var templ_7745c5c3_Var2 templ.SafeURL = renewalURL()
 		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(string(templ_7745c5c3_Var2)))
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\">Veuillez vous rendre sur votre page de renouvellement et entrer le nouveau code d'inscription.</a></p><p>Sans action de votre part, votre compte sera suspendu dans <strong>")
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\">")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		if config.Config.Users.RenewalReview {
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("Veuillez vous rendre sur votre page de renouvellement, entrer le nouveau code d'inscription et envoyer votre justificatif.")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		} else {
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("Veuillez vous rendre sur votre page de renouvellement et entrer le nouveau code d'inscription.")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</a></p><p>Sans action de votre part, votre compte sera suspendu dans <strong>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
 		var templ_7745c5c3_Var3 string
 		templ_7745c5c3_Var3, templ_7745c5c3_Err = templ.JoinStringErrs(until)
 		if templ_7745c5c3_Err != nil {
-			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/emails/registration-expired.templ`, Line: 25, Col: 77}
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/emails/registration-expired.templ`, Line: 39, Col: 77}
 		}
 		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var3))
 		if templ_7745c5c3_Err != nil {
@@ -83,12 +118,27 @@ func RegistrationSuspended() templ.Component {
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		var templ_7745c5c3_Var5 templ.SafeURL = templ.SafeURL(fmt.Sprintf("%s/renew-registraton", config.Config.Http.BaseURL))
+		var templ_7745c5c3_Var5 templ.SafeURL = renewalURL()
 		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(string(templ_7745c5c3_Var5)))
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\">veuillez vous rendre sur votre page de renouvellement et entrer le nouveau  code d'inscription.</a></p><p>Sans action de votre part, votre compte et toutes les donnés qui lui sont liées, seront supprimés dans 1 an.</p>")
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\">")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		if config.Config.Users.RenewalReview {
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("veuillez vous rendre sur votre page de renouvellement, entrer le nouveau code d'inscription et envoyer un justificatif de domicile récent. Votre compte sera réactivé après sa vérification.")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		} else {
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("veuillez vous rendre sur votre page de renouvellement et entrer le nouveau  code d'inscription.")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</a></p><p>Sans action de votre part, votre compte et toutes les donnés qui lui sont liées, seront supprimés dans 1 an.</p>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -96,7 +146,7 @@ func RegistrationSuspended() templ.Component {
 	})
 }
 
-func RegistrationRenewed() templ.Component {
+func RegistrationSuspendedByAdmin(user db.User) templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
 		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
 		templ_7745c5c3_Buffer, templ_7745c5c3_IsBuffer := templruntime.GetBuffer(templ_7745c5c3_W)
@@ -114,6 +164,64 @@ func RegistrationRenewed() templ.Component {
 			templ_7745c5c3_Var6 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<h1>Compte suspendu</h1><p>Votre compte Woody Wood Gate a été suspendu par le conseil syndical, vous ne pouvez plus ouvrir le portail.</p>")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = decisionReason(user).Render(ctx, templ_7745c5c3_Buffer)
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<p>Pour réactiver votre compte, <a href=\"")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		var templ_7745c5c3_Var7 templ.SafeURL = renewalURL()
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(string(templ_7745c5c3_Var7)))
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\">")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		if config.Config.Users.RenewalReview {
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("veuillez vous rendre sur votre page de renouvellement, entrer le code d'inscription se trouvant dans la résidence et envoyer un justificatif de domicile récent. Votre compte sera réactivé après sa vérification.")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		} else {
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("veuillez vous rendre sur votre page de renouvellement et entrer le code d'inscription se trouvant dans la résidence.")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</a></p><p>Si vous pensez qu'il s'agit d'une erreur, veuillez vous rapprocher du conseil syndical.</p>")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		return templ_7745c5c3_Err
+	})
+}
+
+func RegistrationRenewed() templ.Component {
+	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
+		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
+		templ_7745c5c3_Buffer, templ_7745c5c3_IsBuffer := templruntime.GetBuffer(templ_7745c5c3_W)
+		if !templ_7745c5c3_IsBuffer {
+			defer func() {
+				templ_7745c5c3_BufErr := templruntime.ReleaseBuffer(templ_7745c5c3_Buffer)
+				if templ_7745c5c3_Err == nil {
+					templ_7745c5c3_Err = templ_7745c5c3_BufErr
+				}
+			}()
+		}
+		ctx = templ.InitializeContext(ctx)
+		templ_7745c5c3_Var8 := templ.GetChildren(ctx)
+		if templ_7745c5c3_Var8 == nil {
+			templ_7745c5c3_Var8 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
 		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<h1>Inscription renouvelée</h1><p>Votre inscription à Woody Wood Gate a bien été renouvelée.</p>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
@@ -135,9 +243,9 @@ func AccountDeleted() templ.Component {
 			}()
 		}
 		ctx = templ.InitializeContext(ctx)
-		templ_7745c5c3_Var7 := templ.GetChildren(ctx)
-		if templ_7745c5c3_Var7 == nil {
-			templ_7745c5c3_Var7 = templ.NopComponent
+		templ_7745c5c3_Var9 := templ.GetChildren(ctx)
+		if templ_7745c5c3_Var9 == nil {
+			templ_7745c5c3_Var9 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
 		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<h1>Votre compte a été supprimé</h1><p>Votre compte Woody Wood Gate a été supprimé.</p><p>Votre compte n'a pas été activé depuis 1 an, conformément a la législation en vigueur, votre compte et toutes les données qui lui sont liées ont été supprimées.</p><p>Si vous pensez qu'il s'agit d'une erreur, veuillez vous rapprocher du conseil syndical.</p>")
@@ -147,3 +255,29 @@ func AccountDeleted() templ.Component {
 		return templ_7745c5c3_Err
 	})
 }
+
+func AccountDeletedByUser() templ.Component {
+	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
+		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
+		templ_7745c5c3_Buffer, templ_7745c5c3_IsBuffer := templruntime.GetBuffer(templ_7745c5c3_W)
+		if !templ_7745c5c3_IsBuffer {
+			defer func() {
+				templ_7745c5c3_BufErr := templruntime.ReleaseBuffer(templ_7745c5c3_Buffer)
+				if templ_7745c5c3_Err == nil {
+					templ_7745c5c3_Err = templ_7745c5c3_BufErr
+				}
+			}()
+		}
+		ctx = templ.InitializeContext(ctx)
+		templ_7745c5c3_Var10 := templ.GetChildren(ctx)
+		if templ_7745c5c3_Var10 == nil {
+			templ_7745c5c3_Var10 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<h1>Votre compte a été supprimé</h1><p>Comme vous l'avez demandé, votre compte Woody Wood Gate et toutes les données qui lui sont liées ont été supprimés.</p><p>Si vous n'êtes pas à l'origine de cette suppression, veuillez vous rapprocher du conseil syndical.</p>")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		return templ_7745c5c3_Err
+	})
+}
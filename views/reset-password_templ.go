@@ -20,7 +20,7 @@ type PasswordForgottenFormValues struct {
 }
 
 type ResetPasswordFormValues struct {
-	Password string `form:"Password" tr:"Mot de passe"                 validate:"required,min=16"`
+	Password string `form:"Password" tr:"Mot de passe"                 validate:"required,password_length,password_strength,not_breached"`
 	Confirm  string `form:"Confirm"  tr:"Confirmation du mot de passe" validate:"required,eqfield=Password"`
 }
 
@@ -105,7 +105,7 @@ func PasswordForgottenForm(model PasswordForgottenModel) templ.Component {
 				return templ_7745c5c3_Err
 			}
 			if model.EmailSent {
-				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<p class=\"text-green-500\">Un email contenant un lien de réinitialisation vous a été envoyé.</p>")
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<p class=\"text-green-500\">Si un compte existe pour cet email, un lien de réinitialisation vous a été envoyé.</p>")
 				if templ_7745c5c3_Err != nil {
 					return templ_7745c5c3_Err
 				}